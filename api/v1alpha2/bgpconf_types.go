@@ -18,11 +18,45 @@ package v1alpha2
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
 
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/openelb/openelb/pkg/client"
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/util"
 	api "github.com/osrg/gobgp/api"
+	bgppacket "github.com/osrg/gobgp/pkg/packet/bgp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// DefaultBgpConfName is the name of the only BgpConf applied by the speakers.
+const DefaultBgpConfName = "default"
+
+const (
+	// the router id is the ipv4 internal ip of the node
+	RouterIdModeNodeIP = "node-ip"
+	// the router id is derived from a hash of the node name
+	RouterIdModeHash = "hash"
+	// the router id is taken from the openelb.kubesphere.io/router-id node annotation
+	RouterIdModeAnnotation = "annotation"
+
+	// BgpConfRouterIdConflict is true while several nodes run with the same router id
+	BgpConfRouterIdConflict = "RouterIdConflict"
 )
 
 type NodeConfStatus struct {
@@ -36,6 +70,32 @@ type NodeConfStatus struct {
 // BgpConfStatus defines the observed state of BgpConf
 type BgpConfStatus struct {
 	NodesConfStatus map[string]NodeConfStatus `json:"nodesConfStatus,omitempty"`
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// RouterIdConflicts returns the router ids shared by several of the nodes,
+// with the sorted names of the nodes using them.
+func (s BgpConfStatus) RouterIdConflicts(nodes []string) map[string][]string {
+	used := make(map[string][]string)
+	for _, node := range nodes {
+		status, ok := s.NodesConfStatus[node]
+		if !ok || status.RouterId == "" {
+			continue
+		}
+		used[status.RouterId] = append(used[status.RouterId], node)
+	}
+
+	conflicts := make(map[string][]string)
+	for routerId, nodes := range used {
+		if len(nodes) > 1 {
+			sort.Strings(nodes)
+			conflicts[routerId] = nodes
+		}
+	}
+	return conflicts
 }
 
 // +kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpconfs,verbs=get;list;watch;create;update;patch;delete
@@ -67,8 +127,83 @@ type BgpConfSpec struct {
 	Families         []uint32          `json:"families,omitempty"`
 	UseMultiplePaths bool              `json:"useMultiplePaths,omitempty"`
 	GracefulRestart  *GracefulRestart  `json:"gracefulRestart,omitempty"`
+	// how each speaker derives its router id: node-ip, hash or annotation.
+	// If empty, routerId is used on the nodes without a rack in asPerRack
+	// and the node ip on the others.
+	// +kubebuilder:validation:Enum=node-ip;hash;annotation
+	// +optional
+	RouterIdMode string `json:"routerIdMode,omitempty"`
+	// peer the speaker nodes with each other, instead of every node with
+	// the external routers
+	// +optional
+	NodeMesh *NodeMesh `json:"nodeMesh,omitempty"`
 	// +optional
 	Policy string `json:"policy,omitempty"`
+	// number of times the AS is prepended to paths sent to eBGP peers with a
+	// next hop in the rack, overridden by the openelb.kubesphere.io/as-path-prepend
+	// node annotation
+	// +optional
+	AsPathPrependPerRack map[string]uint32 `json:"asPathPrependPerRack,omitempty"`
+	// link bandwidth in bits per second (e.g. 10G) advertised as extended community
+	// with paths with a next hop in the rack, overridden by the
+	// openelb.kubesphere.io/link-bandwidth node annotation
+	// +optional
+	LinkBandwidthPerRack map[string]string `json:"linkBandwidthPerRack,omitempty"`
+}
+
+// NodeMesh generates the BGP sessions between the speaker nodes, a full
+// mesh or route reflectors with their clients.
+type NodeMesh struct {
+	// nodes joining the mesh, all nodes if empty
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// nodes acting as route reflectors for the other nodes of the mesh,
+	// which then only peer with them. A full mesh if empty.
+	// +optional
+	RouteReflectorSelector *metav1.LabelSelector `json:"routeReflectorSelector,omitempty"`
+	// cluster id of the route reflectors, the router id of each reflector by default
+	// +optional
+	ClusterId string `json:"clusterId,omitempty"`
+}
+
+// NodeSelected reports whether the node joins the mesh.
+func (m NodeMesh) NodeSelected(node *corev1.Node) (bool, error) {
+	return selectorMatches(m.NodeSelector, node, true)
+}
+
+// RouteReflector reports whether the node is a route reflector of the mesh.
+func (m NodeMesh) RouteReflector(node *corev1.Node) (bool, error) {
+	return selectorMatches(m.RouteReflectorSelector, node, false)
+}
+
+func selectorMatches(s *metav1.LabelSelector, node *corev1.Node, empty bool) (bool, error) {
+	if s == nil {
+		return empty, nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(s)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(labels.Set(node.Labels)), nil
+}
+
+func (m NodeMesh) validate(listenPort int32) error {
+	if listenPort == -1 {
+		return fmt.Errorf("field Spec.NodeMesh requires the speakers to listen, Spec.ListenPort should not be -1")
+	}
+	if _, err := m.NodeSelected(&corev1.Node{}); err != nil {
+		return fmt.Errorf("field Spec.NodeMesh.NodeSelector invalid: %v", err)
+	}
+	if _, err := m.RouteReflector(&corev1.Node{}); err != nil {
+		return fmt.Errorf("field Spec.NodeMesh.RouteReflectorSelector invalid: %v", err)
+	}
+	if m.ClusterId != "" {
+		if ip := net.ParseIP(m.ClusterId); ip == nil || ip.To4() == nil {
+			return fmt.Errorf("field Spec.NodeMesh.ClusterId invalid: %s is no ipv4 address", m.ClusterId)
+		}
+	}
+	return nil
 }
 
 type GracefulRestart struct {
@@ -97,6 +232,10 @@ type BgpConfList struct {
 
 func (c BgpConfSpec) ToGoBgpGlobalConf() (*api.Global, error) {
 	c.AsPerRack = nil
+	c.AsPathPrependPerRack = nil
+	c.LinkBandwidthPerRack = nil
+	c.RouterIdMode = ""
+	c.NodeMesh = nil
 
 	jsonBytes, err := json.Marshal(c)
 	if err != nil {
@@ -108,6 +247,195 @@ func (c BgpConfSpec) ToGoBgpGlobalConf() (*api.Global, error) {
 	return &result, m.Unmarshal(bytes.NewReader(jsonBytes), &result)
 }
 
+// NodeAs returns the AS of the speaker on the node, the AS of its rack if
+// configured.
+func (c BgpConfSpec) NodeAs(node *corev1.Node) uint32 {
+	rack := node.Labels[constant.OpenELBNodeRack]
+	if rack != "" && c.AsPerRack[rack] > 0 {
+		return c.AsPerRack[rack]
+	}
+	return c.As
+}
+
+// NodeRouterId returns the router id of the speaker on the node according
+// to the router id mode.
+func (c BgpConfSpec) NodeRouterId(node *corev1.Node) (string, error) {
+	switch c.RouterIdMode {
+	case RouterIdModeAnnotation:
+		routerId := node.Annotations[constant.OpenELBNodeRouterId]
+		if ip := net.ParseIP(routerId); ip == nil || ip.To4() == nil {
+			return "", fmt.Errorf("annotation %s of node %s invalid: %q is no ipv4 address", constant.OpenELBNodeRouterId, node.Name, routerId)
+		}
+		return routerId, nil
+	case RouterIdModeHash:
+		return hashRouterId(node.Name), nil
+	case "":
+		rack := node.Labels[constant.OpenELBNodeRack]
+		if c.RouterId != "" && (rack == "" || c.AsPerRack == nil) {
+			return c.RouterId, nil
+		}
+	}
+
+	ip := util.GetNodeIP(*node).To4()
+	if ip == nil {
+		return "", fmt.Errorf("node %s has no ipv4 internal ip to use as router id", node.Name)
+	}
+	return ip.String(), nil
+}
+
+// hashRouterId derives a stable router id from the node name, conflicts
+// are unlikely but still reported by the controller.
+func hashRouterId(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	sum := h.Sum32()
+	if sum == 0 {
+		sum = 1
+	}
+	return net.IPv4(byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum)).String()
+}
+
+// RouterIdConflictCondition returns the RouterIdConflict condition for the
+// router ids shared by several nodes.
+func RouterIdConflictCondition(conflicts map[string][]string) metav1.Condition {
+	if len(conflicts) == 0 {
+		return metav1.Condition{
+			Type:    BgpConfRouterIdConflict,
+			Status:  metav1.ConditionFalse,
+			Reason:  "UniqueRouterIds",
+			Message: "the router ids of the nodes are unique",
+		}
+	}
+
+	var msgs []string
+	for routerId, nodes := range conflicts {
+		msgs = append(msgs, fmt.Sprintf("router id %s is used by nodes %s", routerId, strings.Join(nodes, ", ")))
+	}
+	sort.Strings(msgs)
+	return metav1.Condition{
+		Type:    BgpConfRouterIdConflict,
+		Status:  metav1.ConditionTrue,
+		Reason:  "DuplicateRouterIds",
+		Message: strings.Join(msgs, "; "),
+	}
+}
+
+func (c BgpConfSpec) validate() (admission.Warnings, error) {
+	var warnings admission.Warnings
+
+	if c.As == 0 {
+		return nil, fmt.Errorf("field Spec.As should not be 0")
+	}
+	for rack, as := range c.AsPerRack {
+		if as == 0 {
+			return nil, fmt.Errorf("field Spec.AsPerRack invalid: as of rack %s should not be 0", rack)
+		}
+	}
+
+	if c.RouterId != "" {
+		if ip := net.ParseIP(c.RouterId); ip == nil || ip.To4() == nil {
+			return nil, fmt.Errorf("field Spec.RouterId invalid: %s is no ipv4 address", c.RouterId)
+		}
+		if c.RouterIdMode != "" {
+			warnings = append(warnings, "spec.routerId is ignored with spec.routerIdMode set")
+		} else if len(c.AsPerRack) > 0 {
+			warnings = append(warnings, "spec.routerId is ignored on the nodes of the racks in spec.asPerRack")
+		}
+	}
+
+	if c.ListenPort < -1 || c.ListenPort > 65535 {
+		return nil, fmt.Errorf("field Spec.ListenPort invalid: %d", c.ListenPort)
+	}
+
+	if c.NodeMesh != nil {
+		if err := c.NodeMesh.validate(c.ListenPort); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, family := range c.Families {
+		if _, ok := bgppacket.AddressFamilyNameMap[bgppacket.RouteFamily(family)]; !ok {
+			return nil, fmt.Errorf("field Spec.Families invalid: unknown family %d", family)
+		}
+	}
+
+	if _, err := c.ToGoBgpGlobalConf(); err != nil {
+		return nil, err
+	}
+
+	return warnings, nil
+}
+
+// +kubebuilder:webhook:admissionReviewVersions=v1,path=/validate-network-kubesphere-io-v1alpha2-bgpconf,mutating=false,sideEffects=None,failurePolicy=fail,groups=network.kubesphere.io,resources=bgpconfs,verbs=create;update,versions=v1alpha2,name=validate.bgpconf.network.kubesphere.io
+
+var _ webhook.Validator = &BgpConf{}
+
+func (c BgpConf) ValidateCreate() (admission.Warnings, error) {
+	return c.validate()
+}
+
+// ValidateUpdate lists the peers only on spec changes, status and metadata
+// updates are not affected by them.
+func (c BgpConf) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	if c.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	if oldC, ok := old.(*BgpConf); ok && reflect.DeepEqual(c.Spec, oldC.Spec) {
+		return nil, nil
+	}
+	return c.validate()
+}
+
+func (c BgpConf) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (c BgpConf) validate() (admission.Warnings, error) {
+	if c.Name != DefaultBgpConfName {
+		return admission.Warnings{fmt.Sprintf("only the BgpConf named %s is applied", DefaultBgpConfName)}, nil
+	}
+
+	warnings, err := c.Spec.validate()
+	if err != nil {
+		return warnings, err
+	}
+
+	// the peers must stay compatible with the AS of the nodes they are selected onto
+	peers := &BgpPeerList{}
+	if err := client.Client.List(context.Background(), peers); err != nil {
+		return warnings, err
+	}
+	nodes := &corev1.NodeList{}
+	if err := client.Client.List(context.Background(), nodes); err != nil {
+		return warnings, err
+	}
+	for _, peer := range peers.Items {
+		if err := peer.validateLocalAs(&c, nodes.Items); err != nil {
+			return warnings, err
+		}
+	}
+
+	return warnings, nil
+}
+
+// getDefaultBgpConf returns the BgpConf applied by the speakers, nil if it
+// does not exist yet.
+func getDefaultBgpConf() (*BgpConf, error) {
+	conf := &BgpConf{}
+	err := client.Client.Get(context.Background(), types.NamespacedName{Name: DefaultBgpConfName}, conf)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	return conf, err
+}
+
+func (c BgpConf) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&c).
+		Complete()
+}
+
 func init() {
 	SchemeBuilder.Register(&BgpConf{}, &BgpConfList{})
 }
@@ -18,11 +18,22 @@ package v1alpha2
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
 
 	"github.com/golang/protobuf/jsonpb"
+	"github.com/openelb/openelb/pkg/client"
 	api "github.com/osrg/gobgp/api"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -90,6 +101,42 @@ type BgpPeerStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
 	NodesPeerStatus map[string]NodePeerStatus `json:"nodesPeerStatus,omitempty"`
+	// sessions accepted from the dynamic neighbors on each node
+	NodesDynamicPeerStatus map[string][]NodePeerStatus `json:"nodesDynamicPeerStatus,omitempty"`
+	// nodes peering with the neighbor keyed by their rack, each advertises
+	// the paths with a next hop in its own rack only
+	RackNodes map[string][]string `json:"rackNodes,omitempty"`
+}
+
+// SetNodeRack records the node under its rack in RackNodes, or drops it if
+// the rack is empty.
+func (s *BgpPeerStatus) SetNodeRack(node, rack string) {
+	for r, nodes := range s.RackNodes {
+		for i, n := range nodes {
+			if n == node {
+				nodes = append(nodes[:i:i], nodes[i+1:]...)
+				break
+			}
+		}
+		if len(nodes) == 0 {
+			delete(s.RackNodes, r)
+		} else {
+			s.RackNodes[r] = nodes
+		}
+	}
+	if len(s.RackNodes) == 0 {
+		s.RackNodes = nil
+	}
+
+	if rack == "" {
+		return
+	}
+	if s.RackNodes == nil {
+		s.RackNodes = make(map[string][]string)
+	}
+	nodes := append(s.RackNodes[rack], node)
+	sort.Strings(nodes)
+	s.RackNodes[rack] = nodes
 }
 
 // +kubebuilder:object:root=true
@@ -179,6 +226,11 @@ type AfiSafi struct {
 	AddPaths          *AddPaths          `json:"addPaths,omitempty"`
 }
 
+type RouteReflector struct {
+	RouteReflectorClient    bool   `json:"routeReflectorClient,omitempty"`
+	RouteReflectorClusterId string `json:"routeReflectorClusterId,omitempty"`
+}
+
 type EbgpMultihop struct {
 	Enabled     bool   `json:"enabled,omitempty"`
 	MultihopTtl uint32 `json:"multihopTtl,omitempty"`
@@ -191,8 +243,20 @@ type BgpPeerSpec struct {
 	Transport       *Transport       `json:"transport,omitempty"`
 	GracefulRestart *GracefulRestart `json:"gracefulRestart,omitempty"`
 	AfiSafis        []*AfiSafi       `json:"afiSafis,omitempty"`
+	RouteReflector  *RouteReflector  `json:"routeReflector,omitempty"`
 
 	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// advertise the local address of the session as next hop to this peer
+	NextHopSelf bool `json:"nextHopSelf,omitempty"`
+	// advertise the address of this interface on the speaker node as next hop
+	// to this peer, can_reach:<ip> is supported as well
+	NextHopInterface string `json:"nextHopInterface,omitempty"`
+
+	// accept passive sessions from neighbors within these prefixes into the
+	// peer group conf.peerGroup (the BgpPeer name by default), instead of
+	// peering with conf.neighborAddress
+	DynamicNeighbors []string `json:"dynamicNeighbors,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -205,8 +269,45 @@ type BgpPeerList struct {
 	Items           []BgpPeer `json:"items"`
 }
 
+// IsDynamic reports whether the BgpPeer accepts sessions from dynamic neighbors.
+func (p BgpPeer) IsDynamic() bool {
+	return len(p.Spec.DynamicNeighbors) > 0
+}
+
+// IsUnnumbered reports whether the BgpPeer peers over the IPv6 link-local
+// address of conf.neighborInterface instead of a neighbor address.
+func (p BgpPeer) IsUnnumbered() bool {
+	return p.Spec.Conf != nil && p.Spec.Conf.NeighborInterface != "" && p.Spec.Conf.NeighborAddress == ""
+}
+
+// PeerGroupName returns the gobgp peer group of the dynamic neighbors.
+func (p BgpPeer) PeerGroupName() string {
+	if p.Spec.Conf != nil && p.Spec.Conf.PeerGroup != "" {
+		return p.Spec.Conf.PeerGroup
+	}
+	return p.Name
+}
+
+// HasNeighbor reports whether a session with the address belongs to the BgpPeer.
+func (p BgpPeer) HasNeighbor(address string) bool {
+	if p.Spec.Conf != nil && p.Spec.Conf.NeighborAddress == address {
+		return true
+	}
+
+	ip := net.ParseIP(address)
+	for _, prefix := range p.Spec.DynamicNeighbors {
+		if _, ipNet, err := net.ParseCIDR(prefix); err == nil && ip != nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 func (c BgpPeerSpec) ToGoBgpPeer() (*api.Peer, error) {
 	c.NodeSelector = nil
+	c.NextHopSelf = false
+	c.NextHopInterface = ""
+	c.DynamicNeighbors = nil
 
 	jsonBytes, err := json.Marshal(c)
 	if err != nil {
@@ -244,6 +345,169 @@ func GetStatusFromGoBgpPeer(peer *api.Peer) (NodePeerStatus, error) {
 	return nodePeerStatus, err
 }
 
+// NodeSelected reports whether the BgpPeer is selected onto the node.
+func (p BgpPeer) NodeSelected(node *corev1.Node) (bool, error) {
+	selected, err := selectorMatches(p.Spec.NodeSelector, node, true)
+	if err != nil {
+		return false, fmt.Errorf("field Spec.NodeSelector invalid: %v", err)
+	}
+	return selected, nil
+}
+
+// conflicts reports whether both BgpPeers peer with the same neighbor.
+func (p BgpPeer) conflicts(o BgpPeer) bool {
+	if p.Name == o.Name || o.Spec.Conf == nil {
+		return false
+	}
+
+	switch {
+	case p.IsDynamic():
+		return o.IsDynamic() && p.PeerGroupName() == o.PeerGroupName()
+	case p.IsUnnumbered():
+		return o.IsUnnumbered() && p.Spec.Conf.NeighborInterface == o.Spec.Conf.NeighborInterface
+	default:
+		return p.Spec.Conf.NeighborAddress == o.Spec.Conf.NeighborAddress
+	}
+}
+
+func (p BgpPeer) validateSpec() error {
+	conf := p.Spec.Conf
+	if conf == nil {
+		return fmt.Errorf("field Spec.Conf should not be empty")
+	}
+	if conf.PeerAs == 0 {
+		return fmt.Errorf("field Spec.Conf.PeerAs should not be 0")
+	}
+
+	switch {
+	case p.IsDynamic():
+		if conf.NeighborAddress != "" || conf.NeighborInterface != "" {
+			return fmt.Errorf("field Spec.DynamicNeighbors conflicts with Spec.Conf.NeighborAddress and Spec.Conf.NeighborInterface")
+		}
+		for _, prefix := range p.Spec.DynamicNeighbors {
+			if _, _, err := net.ParseCIDR(prefix); err != nil {
+				return fmt.Errorf("field Spec.DynamicNeighbors invalid: %v", err)
+			}
+		}
+	case p.IsUnnumbered():
+	default:
+		if net.ParseIP(conf.NeighborAddress) == nil {
+			return fmt.Errorf("field Spec.Conf.NeighborAddress invalid: %q", conf.NeighborAddress)
+		}
+	}
+
+	if _, err := p.NodeSelected(&corev1.Node{}); err != nil {
+		return err
+	}
+
+	if _, err := p.Spec.ToGoBgpPeer(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// validateLocalAs checks that the local AS of the BgpPeer matches the AS of
+// the nodes it is selected onto.
+func (p BgpPeer) validateLocalAs(conf *BgpConf, nodes []corev1.Node) error {
+	if conf == nil || p.Spec.Conf == nil || p.Spec.Conf.LocalAs == 0 {
+		return nil
+	}
+
+	for i := range nodes {
+		node := &nodes[i]
+		selected, err := p.NodeSelected(node)
+		if err != nil {
+			return err
+		}
+		if as := conf.Spec.NodeAs(node); selected && as != p.Spec.Conf.LocalAs {
+			return fmt.Errorf("local as %d of BgpPeer %s differs from as %d of node %s", p.Spec.Conf.LocalAs, p.Name, as, node.Name)
+		}
+	}
+	return nil
+}
+
+// validateNodes checks that no node is selected by another BgpPeer with the
+// same neighbor, the speaker would apply either of them.
+func (p BgpPeer) validateNodes(peers []BgpPeer, nodes []corev1.Node) error {
+	for _, o := range peers {
+		if !p.conflicts(o) {
+			continue
+		}
+
+		for i := range nodes {
+			node := &nodes[i]
+			selected, err := p.NodeSelected(node)
+			if err != nil {
+				return err
+			}
+			other, err := o.NodeSelected(node)
+			if err != nil {
+				continue
+			}
+			if selected && other {
+				return fmt.Errorf("BgpPeer %s peers with the same neighbor as %s on node %s", p.Name, o.Name, node.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// +kubebuilder:webhook:admissionReviewVersions=v1,path=/validate-network-kubesphere-io-v1alpha2-bgppeer,mutating=false,sideEffects=None,failurePolicy=fail,groups=network.kubesphere.io,resources=bgppeers,verbs=create;update,versions=v1alpha2,name=validate.bgppeer.network.kubesphere.io
+
+var _ webhook.Validator = &BgpPeer{}
+
+func (p BgpPeer) ValidateCreate() (admission.Warnings, error) {
+	return nil, p.validate()
+}
+
+// ValidateUpdate validates the peer only on spec changes, so a peer that
+// became conflicting, or was created before stricter checks, can still have
+// its status and finalizer updated and be deleted.
+func (p BgpPeer) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
+	if p.DeletionTimestamp != nil {
+		return nil, nil
+	}
+
+	if oldP, ok := old.(*BgpPeer); ok && reflect.DeepEqual(p.Spec, oldP.Spec) {
+		return nil, nil
+	}
+	return nil, p.validate()
+}
+
+func (p BgpPeer) ValidateDelete() (admission.Warnings, error) {
+	return nil, nil
+}
+
+func (p BgpPeer) validate() error {
+	if err := p.validateSpec(); err != nil {
+		return err
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := client.Client.List(context.Background(), nodes); err != nil {
+		return err
+	}
+	peers := &BgpPeerList{}
+	if err := client.Client.List(context.Background(), peers); err != nil {
+		return err
+	}
+	if err := p.validateNodes(peers.Items, nodes.Items); err != nil {
+		return err
+	}
+
+	conf, err := getDefaultBgpConf()
+	if err != nil {
+		return err
+	}
+	return p.validateLocalAs(conf, nodes.Items)
+}
+
+func (p BgpPeer) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&p).
+		Complete()
+}
+
 func init() {
 	SchemeBuilder.Register(&BgpPeer{}, &BgpPeerList{})
 }
@@ -0,0 +1,153 @@
+/*
+Copyright 2020 The Kubesphere Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha2
+
+import (
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/any"
+	api "github.com/osrg/gobgp/api"
+	bgppacket "github.com/osrg/gobgp/pkg/packet/bgp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BgpVrfSpec defines the desired state of BgpVrf
+type BgpVrfSpec struct {
+	// route distinguisher of the vrf, <asn>:<number> or <ipv4>:<number>
+	Rd string `json:"rd"`
+	// route targets of the paths imported into the vrf
+	// +optional
+	ImportRts []string `json:"importRts,omitempty"`
+	// route targets attached to the paths exported from the vrf
+	// +optional
+	ExportRts []string `json:"exportRts,omitempty"`
+}
+
+// +kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpvrfs,verbs=get;list;watch;create;update;patch;delete
+
+// +kubebuilder:object:root=true
+// +kubebuilder:object:generate=true
+// +kubebuilder:storageversion
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+// +kubebuilder:resource:scope=Cluster
+
+// BgpVrf is the Schema for the bgpvrfs API, Eips and BgpPeers refer to
+// it by name to advertise paths in the vrf.
+type BgpVrf struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BgpVrfSpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BgpVrfList contains a list of BgpVrf
+type BgpVrfList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BgpVrf `json:"items"`
+}
+
+func (v BgpVrf) ToGoBgpVrf() (*api.Vrf, error) {
+	rd, err := bgppacket.ParseRouteDistinguisher(v.Spec.Rd)
+	if err != nil {
+		return nil, fmt.Errorf("field Spec.Rd invalid: %v", err)
+	}
+
+	result := &api.Vrf{Name: v.Name}
+	switch rd := rd.(type) {
+	case *bgppacket.RouteDistinguisherTwoOctetAS:
+		result.Rd, err = ptypes.MarshalAny(&api.RouteDistinguisherTwoOctetAS{
+			Admin:    uint32(rd.Admin),
+			Assigned: rd.Assigned,
+		})
+	case *bgppacket.RouteDistinguisherIPAddressAS:
+		result.Rd, err = ptypes.MarshalAny(&api.RouteDistinguisherIPAddress{
+			Admin:    rd.Admin.String(),
+			Assigned: uint32(rd.Assigned),
+		})
+	case *bgppacket.RouteDistinguisherFourOctetAS:
+		result.Rd, err = ptypes.MarshalAny(&api.RouteDistinguisherFourOctetAS{
+			Admin:    rd.Admin,
+			Assigned: uint32(rd.Assigned),
+		})
+	default:
+		err = fmt.Errorf("unsupported route distinguisher %s", v.Spec.Rd)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if result.ImportRt, err = toRouteTargets(v.Spec.ImportRts); err != nil {
+		return nil, fmt.Errorf("field Spec.ImportRts invalid: %v", err)
+	}
+	if result.ExportRt, err = toRouteTargets(v.Spec.ExportRts); err != nil {
+		return nil, fmt.Errorf("field Spec.ExportRts invalid: %v", err)
+	}
+
+	return result, nil
+}
+
+func toRouteTargets(rts []string) ([]*any.Any, error) {
+	var result []*any.Any
+	for _, s := range rts {
+		rt, err := bgppacket.ParseRouteTarget(s)
+		if err != nil {
+			return nil, err
+		}
+
+		var a *any.Any
+		switch rt := rt.(type) {
+		case *bgppacket.TwoOctetAsSpecificExtended:
+			a, err = ptypes.MarshalAny(&api.TwoOctetAsSpecificExtended{
+				IsTransitive: rt.IsTransitive,
+				SubType:      uint32(rt.SubType),
+				As:           uint32(rt.AS),
+				LocalAdmin:   rt.LocalAdmin,
+			})
+		case *bgppacket.IPv4AddressSpecificExtended:
+			a, err = ptypes.MarshalAny(&api.IPv4AddressSpecificExtended{
+				IsTransitive: rt.IsTransitive,
+				SubType:      uint32(rt.SubType),
+				Address:      rt.IPv4.String(),
+				LocalAdmin:   uint32(rt.LocalAdmin),
+			})
+		case *bgppacket.FourOctetAsSpecificExtended:
+			a, err = ptypes.MarshalAny(&api.FourOctetAsSpecificExtended{
+				IsTransitive: rt.IsTransitive,
+				SubType:      uint32(rt.SubType),
+				As:           rt.AS,
+				LocalAdmin:   uint32(rt.LocalAdmin),
+			})
+		default:
+			err = fmt.Errorf("unsupported route target %s", s)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, a)
+	}
+
+	return result, nil
+}
+
+func init() {
+	SchemeBuilder.Register(&BgpVrf{}, &BgpVrfList{})
+}
@@ -22,14 +22,17 @@ import (
 	"net"
 	"reflect"
 	"strings"
+	"time"
 
 	"github.com/openelb/openelb/pkg/client"
 	"github.com/openelb/openelb/pkg/util"
+	"github.com/openelb/openelb/pkg/util/iprange"
 	"github.com/openelb/openelb/pkg/validate"
 
 	"github.com/openelb/openelb/pkg/constant"
 
 	cnet "github.com/openelb/openelb/pkg/util/net"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -107,7 +110,9 @@ type EipSpec struct {
 	// +kubebuilder:validation:Required
 	Address string `json:"address,required"`
 	// +kubebuilder:validation:Enum=bgp;layer2;vip
-	Protocol      string `json:"protocol,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// interface the addresses are announced on, a name or can_reach:<ip>,
+	// subnet:<cidr>, mac:<address> or label:<node label key>
 	Interface     string `json:"interface,omitempty"`
 	Disable       bool   `json:"disable,omitempty"`
 	UsingKnownIPs bool   `json:"usingKnownIPs,omitempty"`
@@ -117,8 +122,156 @@ type EipSpec struct {
 	Namespaces []string `json:"namespaces,omitempty"`
 	// specify the namespace for allocation by selector
 	NamespaceSelector map[string]string `json:"namespaceSelector,omitempty"`
+	// advertise the pool as prefixes of this length instead of per-IP host routes,
+	// services with ExternalTrafficPolicy=Local are still announced as host routes.
+	// only valid for the bgp protocol, and the pool must start and end on the boundaries of these prefixes
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=128
+	AggregationLength int `json:"aggregationLength,omitempty"`
+	// name of the BgpVrf the paths are advertised in instead of the global table,
+	// only valid for the bgp protocol
+	// +optional
+	Vrf string `json:"vrf,omitempty"`
+	// gratuitous ARP/NDP packets sent for the addresses, only valid for the
+	// layer2 protocol
+	// +optional
+	Layer2Announce *Layer2Announce `json:"layer2Announce,omitempty"`
+	// nodes preferred to announce the addresses, only valid for the layer2 protocol
+	// +optional
+	Layer2Election *Layer2Election `json:"layer2Election,omitempty"`
+	// announce on a VLAN sub-interface of Interface, or without an address
+	// in the subnet of the Eip, only valid for the layer2 protocol
+	// +optional
+	Layer2Interface *Layer2Interface `json:"layer2Interface,omitempty"`
+	// interfaces of single nodes, the first entry matching a node overrides
+	// Interface, only valid for the layer2 and vip protocols
+	// +optional
+	NodeInterfaces []NodeInterface `json:"nodeInterfaces,omitempty"`
 }
 
+// NodeInterface sets the interface of a node, or of the nodes matching a
+// selector.
+type NodeInterface struct {
+	// +optional
+	Node string `json:"node,omitempty"`
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// interface of the nodes, in the format of Interface
+	// +kubebuilder:validation:Required
+	Interface string `json:"interface"`
+}
+
+// NodeInterfaceStatus is the interface a speaker resolved for an Eip.
+type NodeInterfaceStatus struct {
+	Interface string `json:"interface,omitempty"`
+	// set if the interface could not be resolved or configured
+	Error string `json:"error,omitempty"`
+}
+
+// InterfaceOfNode returns the interface of the first entry of NodeInterfaces
+// matching the node, Interface if none does.
+func (e *Eip) InterfaceOfNode(node *corev1.Node) (string, error) {
+	for _, i := range e.Spec.NodeInterfaces {
+		if i.Node != "" && i.Node != node.Name {
+			continue
+		}
+		matched, err := selectorMatches(i.NodeSelector, node, true)
+		if err != nil {
+			return "", err
+		}
+		if matched {
+			return i.Interface, nil
+		}
+	}
+	return e.Spec.Interface, nil
+}
+
+// Layer2Interface configures the interface the addresses of a layer2 Eip are
+// announced on.
+type Layer2Interface struct {
+	// announce on the VLAN sub-interface of Interface with this id, the
+	// speaker creates it and removes it once no Eip uses it
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=4094
+	// +optional
+	VlanID int `json:"vlanID,omitempty"`
+	// addresses keyed by node name, the speaker of a node assigns its address
+	// to the interface if missing and sends the NDP packets from it, the
+	// interface then needs no address in the subnet of the Eip. Only valid
+	// for IPv6, ARP packets are sent from the announced addresses
+	// +optional
+	SourceAddresses map[string]string `json:"sourceAddresses,omitempty"`
+}
+
+// Layer2Election steers the election of the node announcing an address of a
+// layer2 Eip. The nodes are ranked by their position in Nodes, then by
+// matching NodeSelector, then by the openelb.kubesphere.io/layer2-priority
+// label, the hash of node and address only breaks the remaining ties.
+type Layer2Election struct {
+	// nodes preferred in this order over all others
+	// +optional
+	Nodes []string `json:"nodes,omitempty"`
+	// nodes matching the selector are preferred over the others
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+	// spread the used addresses of the Eip evenly over the nodes, no node
+	// announces more than the used addresses divided by the nodes, rounded up
+	// +optional
+	Balanced bool `json:"balanced,omitempty"`
+}
+
+// NodeIndex returns the position of the node in Nodes, len(Nodes) if it is not listed.
+func (e *Layer2Election) NodeIndex(node string) int {
+	if e == nil {
+		return 0
+	}
+	for i, n := range e.Nodes {
+		if n == node {
+			return i
+		}
+	}
+	return len(e.Nodes)
+}
+
+// NodePreferred reports whether the node matches NodeSelector.
+func (e *Layer2Election) NodePreferred(node *corev1.Node) (bool, error) {
+	if e == nil {
+		return false, nil
+	}
+	return selectorMatches(e.NodeSelector, node, false)
+}
+
+// Layer2Announce configures the gratuitous ARP requests and replies, or the
+// unsolicited neighbor advertisements, announcing the addresses of an Eip.
+type Layer2Announce struct {
+	// number of packets sent when an address is announced and on every refresh, defaults to 1
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	BurstCount int `json:"burstCount,omitempty"`
+	// interval between the packets of a burst, defaults to 1s
+	// +optional
+	BurstInterval *metav1.Duration `json:"burstInterval,omitempty"`
+	// interval the bursts are repeated at while the address is announced,
+	// unset or 0 sends them once
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+	// what the announcing node does when another host claims an address,
+	// conflicts are always reported with events and metrics. StepDown stops
+	// announcing the address until it is elected again, Reassert sends the
+	// gratuitous packets again, at most once a minute and three times while
+	// the conflict lasts. Defaults to Report
+	// +kubebuilder:validation:Enum=Report;StepDown;Reassert
+	// +optional
+	ConflictPolicy string `json:"conflictPolicy,omitempty"`
+}
+
+const (
+	Layer2ConflictReport   = "Report"
+	Layer2ConflictStepDown = "StepDown"
+	Layer2ConflictReassert = "Reassert"
+)
+
 // EipStatus defines the observed state of EIP
 type EipStatus struct {
 	Occupied bool              `json:"occupied,omitempty"`
@@ -129,6 +282,8 @@ type EipStatus struct {
 	LastIP   string            `json:"lastIP,omitempty"`
 	Ready    bool              `json:"ready,omitempty"`
 	V4       bool              `json:"v4,omitempty"`
+	// interfaces resolved by the speakers, keyed by node name
+	NodeInterfaces map[string]NodeInterfaceStatus `json:"nodeInterfaces,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -181,6 +336,10 @@ func (e Eip) Contains(ip net.IP) bool {
 		cnet.IPToBigInt(cnet.IP{IP: ip}).Cmp(big.NewInt(0).Add(cnet.IPToBigInt(cnet.IP{IP: base}), big.NewInt(size-1))) <= 0
 }
 
+func (e Eip) IsAggregated() bool {
+	return e.GetProtocol() == constant.OpenELBProtocolBGP && e.Spec.AggregationLength > 0
+}
+
 func (e Eip) IsDefault() bool {
 	return e.Annotations[constant.OpenELBEIPAnnotationDefaultPool] == "true"
 }
@@ -191,8 +350,28 @@ func (e Eip) ValidateCreate() (admission.Warnings, error) {
 		return nil, err
 	}
 
-	if (e.Spec.Protocol == constant.OpenELBProtocolLayer2 || e.Spec.Protocol == constant.OpenELBProtocolVip) && e.Spec.Interface == "" {
-		return nil, fmt.Errorf("if protocol is layer2 or vip, interface should not be empty")
+	if err := e.validateInterfaces(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateAggregationLength(); err != nil {
+		return nil, err
+	}
+
+	if e.Spec.Vrf != "" && e.GetProtocol() != constant.OpenELBProtocolBGP {
+		return nil, fmt.Errorf("vrf is only supported when protocol is bgp")
+	}
+
+	if err := e.validateLayer2Announce(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateLayer2Election(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateLayer2Interface(); err != nil {
+		return nil, err
 	}
 	return nil, e.validate(true)
 }
@@ -213,6 +392,132 @@ func (e Eip) validate(overlap bool) error {
 
 }
 
+func (e Eip) validateAggregationLength() error {
+	if e.Spec.AggregationLength == 0 {
+		return nil
+	}
+
+	if e.GetProtocol() != constant.OpenELBProtocolBGP {
+		return fmt.Errorf("aggregationLength is only supported when protocol is bgp")
+	}
+
+	r, err := iprange.ParseRange(e.Spec.Address)
+	if err != nil {
+		return err
+	}
+
+	// the prefixes must not advertise addresses out of the pool
+	_, err = iprange.Prefixes(r, e.Spec.AggregationLength)
+	return err
+}
+
+func (e Eip) validateLayer2Announce() error {
+	a := e.Spec.Layer2Announce
+	if a == nil {
+		return nil
+	}
+
+	if e.GetProtocol() != constant.OpenELBProtocolLayer2 {
+		return fmt.Errorf("layer2Announce is only supported when protocol is layer2")
+	}
+	if a.BurstCount < 0 || a.BurstCount > 100 {
+		return fmt.Errorf("layer2Announce.burstCount should be between 1 and 100")
+	}
+	if a.BurstInterval != nil && a.BurstInterval.Duration < 0 {
+		return fmt.Errorf("layer2Announce.burstInterval should not be negative")
+	}
+	if a.RefreshInterval != nil && a.RefreshInterval.Duration != 0 && a.RefreshInterval.Duration < time.Second {
+		return fmt.Errorf("layer2Announce.refreshInterval should be at least 1s")
+	}
+	switch a.ConflictPolicy {
+	case "", Layer2ConflictReport, Layer2ConflictStepDown, Layer2ConflictReassert:
+	default:
+		return fmt.Errorf("layer2Announce.conflictPolicy should be one of %s, %s or %s",
+			Layer2ConflictReport, Layer2ConflictStepDown, Layer2ConflictReassert)
+	}
+
+	return nil
+}
+
+func (e Eip) validateLayer2Election() error {
+	if e.Spec.Layer2Election == nil {
+		return nil
+	}
+
+	if e.GetProtocol() != constant.OpenELBProtocolLayer2 {
+		return fmt.Errorf("layer2Election is only supported when protocol is layer2")
+	}
+	if _, err := e.Spec.Layer2Election.NodePreferred(&corev1.Node{}); err != nil {
+		return fmt.Errorf("layer2Election.nodeSelector invalid: %v", err)
+	}
+
+	return nil
+}
+
+func (e Eip) validateInterfaces() error {
+	if e.Spec.Protocol != constant.OpenELBProtocolLayer2 && e.Spec.Protocol != constant.OpenELBProtocolVip {
+		if len(e.Spec.NodeInterfaces) > 0 {
+			return fmt.Errorf("nodeInterfaces is only supported when protocol is layer2 or vip")
+		}
+		return nil
+	}
+
+	if e.Spec.Interface == "" && len(e.Spec.NodeInterfaces) == 0 {
+		return fmt.Errorf("if protocol is layer2 or vip, interface should not be empty")
+	}
+	for i, n := range e.Spec.NodeInterfaces {
+		if n.Interface == "" {
+			return fmt.Errorf("nodeInterfaces[%d].interface should not be empty", i)
+		}
+		if n.Node == "" && n.NodeSelector == nil {
+			return fmt.Errorf("nodeInterfaces[%d] should set node or nodeSelector", i)
+		}
+		if _, err := selectorMatches(n.NodeSelector, &corev1.Node{}, true); err != nil {
+			return fmt.Errorf("nodeInterfaces[%d].nodeSelector invalid: %v", i, err)
+		}
+	}
+
+	return nil
+}
+
+func (e Eip) validateLayer2Interface() error {
+	i := e.Spec.Layer2Interface
+	if i == nil {
+		return nil
+	}
+
+	if e.GetProtocol() != constant.OpenELBProtocolLayer2 {
+		return fmt.Errorf("layer2Interface is only supported when protocol is layer2")
+	}
+	if i.VlanID < 0 || i.VlanID > 4094 {
+		return fmt.Errorf("layer2Interface.vlanID should be between 1 and 4094")
+	}
+	if len(i.SourceAddresses) == 0 {
+		return nil
+	}
+
+	r, err := iprange.ParseRange(e.Spec.Address)
+	if err != nil {
+		return err
+	}
+	if r.Family() != iprange.V6Family {
+		return fmt.Errorf("layer2Interface.sourceAddresses is only supported for IPv6 addresses")
+	}
+	nodes := make(map[string]string, len(i.SourceAddresses))
+	for node, addr := range i.SourceAddresses {
+		source := net.ParseIP(addr)
+		if source == nil || source.To4() != nil {
+			return fmt.Errorf("layer2Interface.sourceAddresses[%s] %s is not an IPv6 address", node, addr)
+		}
+		if other, ok := nodes[source.String()]; ok {
+			return fmt.Errorf("layer2Interface.sourceAddresses %s is used by both %s and %s", addr, other, node)
+		}
+		nodes[source.String()] = node
+	}
+
+	return nil
+}
+
 func (e Eip) validateDefault(eips *EipList) error {
 	if eips == nil {
 		return nil
@@ -267,8 +572,28 @@ func (e Eip) ValidateUpdate(old runtime.Object) (admission.Warnings, error) {
 		}
 	}
 
-	if (e.Spec.Protocol == constant.OpenELBProtocolLayer2 || e.Spec.Protocol == constant.OpenELBProtocolVip) && e.Spec.Interface == "" {
-		return nil, fmt.Errorf("if protocol is layer2 or vip, interface should not be empty")
+	if err := e.validateInterfaces(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateAggregationLength(); err != nil {
+		return nil, err
+	}
+
+	if e.Spec.Vrf != "" && e.GetProtocol() != constant.OpenELBProtocolBGP {
+		return nil, fmt.Errorf("vrf is only supported when protocol is bgp")
+	}
+
+	if err := e.validateLayer2Announce(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateLayer2Election(); err != nil {
+		return nil, err
+	}
+
+	if err := e.validateLayer2Interface(); err != nil {
+		return nil, err
 	}
 
 	return nil, nil
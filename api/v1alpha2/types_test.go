@@ -3,11 +3,17 @@ package v1alpha2
 import (
 	"net"
 	"testing"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"github.com/openelb/openelb/pkg/client"
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
 
@@ -145,5 +151,495 @@ var _ = Describe("Test eip types", func() {
 		e2.Spec.Disable = true
 		_, err = e2.ValidateUpdate(e)
 		Expect(err).ShouldNot(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.AggregationLength = 32
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(e2.IsAggregated()).Should(BeTrue())
+
+		// 192.168.0.0/24 contains addresses out of the pool
+		e2.Spec.AggregationLength = 24
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2.Spec.AggregationLength = 33
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2.Spec.AggregationLength = 16
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2.Spec.AggregationLength = 32
+		e2.Spec.Protocol = "layer2"
+		e2.Spec.Interface = "eth0"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+		Expect(e2.IsAggregated()).Should(BeFalse())
+	})
+
+	It("Test validate Layer2Announce", func() {
+		e := &Eip{
+			Spec: EipSpec{
+				Address:   "192.168.0.100-192.168.0.200",
+				Protocol:  constant.OpenELBProtocolLayer2,
+				Interface: "eth0",
+				Layer2Announce: &Layer2Announce{
+					BurstCount:      3,
+					BurstInterval:   &metav1.Duration{Duration: 200 * time.Millisecond},
+					RefreshInterval: &metav1.Duration{Duration: time.Minute},
+				},
+			},
+		}
+		_, err := e.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e2 := e.DeepCopy()
+		e2.Spec.Layer2Announce.RefreshInterval.Duration = time.Millisecond
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Layer2Announce.BurstInterval.Duration = -time.Second
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Layer2Announce.ConflictPolicy = Layer2ConflictStepDown
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e2.Spec.Layer2Announce.ConflictPolicy = "Ignore"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Protocol = constant.OpenELBProtocolBGP
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test validate Layer2Election", func() {
+		e := &Eip{
+			Spec: EipSpec{
+				Address:   "192.168.0.100-192.168.0.200",
+				Protocol:  constant.OpenELBProtocolLayer2,
+				Interface: "eth0",
+				Layer2Election: &Layer2Election{
+					Nodes:        []string{"node1"},
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"edge": "true"}},
+				},
+			},
+		}
+		_, err := e.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(e.Spec.Layer2Election.NodeIndex("node1")).Should(Equal(0))
+		Expect(e.Spec.Layer2Election.NodeIndex("node2")).Should(Equal(1))
+
+		e2 := e.DeepCopy()
+		e2.Spec.Layer2Election.NodeSelector.MatchLabels["edge"] = "not valid"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Protocol = constant.OpenELBProtocolVip
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test InterfaceOfNode", func() {
+		e := &Eip{
+			Spec: EipSpec{
+				Address:   "192.168.0.100-192.168.0.200",
+				Protocol:  constant.OpenELBProtocolLayer2,
+				Interface: "eth0",
+				NodeInterfaces: []NodeInterface{
+					{Node: "node1", Interface: "ens192"},
+					{NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"nic": "bond"}}, Interface: "bond0"},
+				},
+			},
+		}
+		_, err := e.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		iface, err := e.InterfaceOfNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{"nic": "bond"}}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface).Should(Equal("ens192"))
+		iface, err = e.InterfaceOfNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{"nic": "bond"}}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface).Should(Equal("bond0"))
+		iface, err = e.InterfaceOfNode(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node3"}})
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(iface).Should(Equal("eth0"))
+
+		e2 := e.DeepCopy()
+		e2.Spec.Interface = ""
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.NodeInterfaces[0].Node = ""
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Protocol = constant.OpenELBProtocolBGP
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test validate Layer2Interface", func() {
+		e := &Eip{
+			Spec: EipSpec{
+				Address:   "fd00:1::100-fd00:1::200",
+				Protocol:  constant.OpenELBProtocolLayer2,
+				Interface: "eth0",
+				Layer2Interface: &Layer2Interface{
+					VlanID:          100,
+					SourceAddresses: map[string]string{"node1": "fd00::1", "node2": "fd00::2"},
+				},
+			},
+		}
+		_, err := e.ValidateUpdate(e)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e2 := e.DeepCopy()
+		e2.Spec.Layer2Interface.VlanID = 4095
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Layer2Interface.SourceAddresses["node2"] = "10.0.0.1"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Layer2Interface.SourceAddresses["node2"] = "not an ip"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Layer2Interface.SourceAddresses["node2"] = "fd00:0::1"
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+
+		// source addresses are not used by ipv4 eips
+		e2 = e.DeepCopy()
+		e2.Spec.Address = "192.168.0.100-192.168.0.200"
+		_, err = e2.ValidateUpdate(e2)
+		Expect(err).Should(HaveOccurred())
+
+		e2.Spec.Layer2Interface.SourceAddresses = nil
+		_, err = e2.ValidateUpdate(e2)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		e2 = e.DeepCopy()
+		e2.Spec.Protocol = constant.OpenELBProtocolBGP
+		_, err = e2.ValidateUpdate(e)
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Test bgpvrf types", func() {
+	It("Test ToGoBgpVrf", func() {
+		v := &BgpVrf{
+			ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+			Spec: BgpVrfSpec{
+				Rd:        "65000:100",
+				ImportRts: []string{"65000:100", "10.0.0.1:100"},
+				ExportRts: []string{"4200000000:100"},
+			},
+		}
+
+		vrf, err := v.ToGoBgpVrf()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(vrf.Name).Should(Equal("tenant"))
+		Expect(vrf.Rd).ShouldNot(BeNil())
+		Expect(vrf.ImportRt).Should(HaveLen(2))
+		Expect(vrf.ExportRt).Should(HaveLen(1))
+
+		v.Spec.Rd = "10.0.0.1:100"
+		_, err = v.ToGoBgpVrf()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		v.Spec.Rd = "xxxx"
+		_, err = v.ToGoBgpVrf()
+		Expect(err).Should(HaveOccurred())
+
+		v.Spec.Rd = "65000:100"
+		v.Spec.ExportRts = []string{"65000"}
+		_, err = v.ToGoBgpVrf()
+		Expect(err).Should(HaveOccurred())
+	})
+})
+
+var _ = Describe("Test bgp webhooks", func() {
+	var (
+		nodes []corev1.Node
+		conf  *BgpConf
+		peer  *BgpPeer
+	)
+
+	setClient := func(objs ...runtime.Object) {
+		scheme := runtime.NewScheme()
+		Expect(AddToScheme(scheme)).ShouldNot(HaveOccurred())
+		Expect(corev1.AddToScheme(scheme)).ShouldNot(HaveOccurred())
+		for i := range nodes {
+			objs = append(objs, &nodes[i])
+		}
+		client.Client = fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).Build()
+	}
+
+	BeforeEach(func() {
+		nodes = []corev1.Node{
+			{ObjectMeta: metav1.ObjectMeta{Name: "node1", Labels: map[string]string{constant.OpenELBNodeRack: "rack1"}}},
+			{ObjectMeta: metav1.ObjectMeta{Name: "node2", Labels: map[string]string{constant.OpenELBNodeRack: "rack2"}}},
+		}
+		conf = &BgpConf{
+			ObjectMeta: metav1.ObjectMeta{Name: DefaultBgpConfName},
+			Spec: BgpConfSpec{
+				As:         65000,
+				AsPerRack:  map[string]uint32{"rack1": 65001},
+				ListenPort: 17900,
+			},
+		}
+		peer = &BgpPeer{
+			ObjectMeta: metav1.ObjectMeta{Name: "peer1"},
+			Spec: BgpPeerSpec{
+				Conf: &PeerConf{
+					NeighborAddress: "192.168.0.2",
+					PeerAs:          65100,
+				},
+			},
+		}
+	})
+
+	It("Test BgpConf validate", func() {
+		setClient()
+		_, err := conf.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(conf.Spec.NodeAs(&nodes[0])).Should(Equal(uint32(65001)))
+		Expect(conf.Spec.NodeAs(&nodes[1])).Should(Equal(uint32(65000)))
+
+		conf.Spec.RouterId = "10.0.0.1"
+		warnings, err := conf.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(warnings).Should(HaveLen(1))
+
+		conf.Spec.RouterId = "fd00::1"
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		conf.Spec.RouterId = ""
+		conf.Spec.AsPerRack["rack2"] = 0
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		delete(conf.Spec.AsPerRack, "rack2")
+		conf.Spec.Families = []uint32{12345}
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		conf.Spec.Families = nil
+		conf.Spec.NodeMesh = &NodeMesh{ClusterId: "10.0.0.1"}
+		_, err = conf.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		conf.Spec.NodeMesh.ClusterId = "xxxx"
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		conf.Spec.NodeMesh.ClusterId = ""
+		conf.Spec.ListenPort = -1
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+		conf.Spec.ListenPort = 17900
+		conf.Spec.NodeMesh = nil
+
+		// the peer is selected onto node2 in the default as
+		conf.Spec.Families = nil
+		peer.Spec.Conf.LocalAs = 65001
+		setClient(peer)
+		_, err = conf.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		// status and metadata updates skip the peers
+		oldConf := conf.DeepCopy()
+		conf.Status.NodesConfStatus = map[string]NodeConfStatus{"node1": {}}
+		_, err = conf.ValidateUpdate(oldConf)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		oldConf.Spec.ListenPort = 17901
+		_, err = conf.ValidateUpdate(oldConf)
+		Expect(err).Should(HaveOccurred())
+
+		peer.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{constant.OpenELBNodeRack: "rack1"}}
+		setClient(peer)
+		_, err = conf.ValidateUpdate(oldConf)
+		Expect(err).ShouldNot(HaveOccurred())
+	})
+
+	It("Test BgpPeer validate", func() {
+		setClient(conf)
+		_, err := peer.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		peer.Spec.Conf.NeighborAddress = "xxxx"
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		peer.Spec.Conf.NeighborAddress = ""
+		peer.Spec.DynamicNeighbors = []string{"192.168.0.0/24"}
+		_, err = peer.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		peer.Spec.Conf.NeighborAddress = "192.168.0.2"
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		peer.Spec.DynamicNeighbors = nil
+		peer.Spec.Conf.PeerAs = 0
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		peer.Spec.Conf.PeerAs = 65100
+		peer.Spec.NodeSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+			{Key: constant.OpenELBNodeRack, Operator: "xxxx"},
+		}}
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		// node1 peers in the as of rack1
+		peer.Spec.NodeSelector = nil
+		peer.Spec.Conf.LocalAs = 65001
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		peer.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{constant.OpenELBNodeRack: "rack1"}}
+		_, err = peer.ValidateCreate()
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// another peer with the same neighbor is selected onto node1
+		other := peer.DeepCopy()
+		other.Name = "peer2"
+		other.Spec.NodeSelector = nil
+		other.Spec.Conf.LocalAs = 0
+		setClient(conf, other)
+		_, err = peer.ValidateCreate()
+		Expect(err).Should(HaveOccurred())
+
+		// the conflicting peer can still be updated without spec changes and
+		// have its finalizer removed
+		oldPeer := peer.DeepCopy()
+		peer.Finalizers = []string{constant.FinalizerName}
+		_, err = peer.ValidateUpdate(oldPeer)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		oldPeer.Spec.Conf.PeerAs = 65200
+		_, err = peer.ValidateUpdate(oldPeer)
+		Expect(err).Should(HaveOccurred())
+
+		now := metav1.Now()
+		peer.DeletionTimestamp = &now
+		peer.Finalizers = nil
+		_, err = peer.ValidateUpdate(oldPeer)
+		Expect(err).ShouldNot(HaveOccurred())
+		peer.DeletionTimestamp = nil
+
+		other.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: map[string]string{constant.OpenELBNodeRack: "rack2"}}
+		setClient(conf, other)
+		_, err = peer.ValidateUpdate(oldPeer)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		// a peer created before the stricter checks can still get its finalizer
+		invalid := peer.DeepCopy()
+		invalid.Spec.Conf.PeerAs = 0
+		oldInvalid := invalid.DeepCopy()
+		invalid.Finalizers = []string{constant.FinalizerName}
+		_, err = invalid.ValidateUpdate(oldInvalid)
+		Expect(err).ShouldNot(HaveOccurred())
+
+		oldInvalid.Spec.Conf.PeerAs = 65100
+		_, err = invalid.ValidateUpdate(oldInvalid)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test SetNodeRack", func() {
+		status := &BgpPeerStatus{}
+		status.SetNodeRack("node2", "rack1")
+		status.SetNodeRack("node1", "rack1")
+		Expect(status.RackNodes).Should(Equal(map[string][]string{"rack1": {"node1", "node2"}}))
+
+		status.SetNodeRack("node2", "rack2")
+		Expect(status.RackNodes).Should(Equal(map[string][]string{"rack1": {"node1"}, "rack2": {"node2"}}))
+
+		status.SetNodeRack("node1", "")
+		status.SetNodeRack("node2", "")
+		Expect(status.RackNodes).Should(BeNil())
+	})
+})
+
+var _ = Describe("Test bgpconf router id", func() {
+	It("Test NodeRouterId", func() {
+		node := &corev1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "node1",
+				Labels:      map[string]string{constant.OpenELBNodeRack: "rack1"},
+				Annotations: map[string]string{constant.OpenELBNodeRouterId: "10.0.0.100"},
+			},
+			Status: corev1.NodeStatus{Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "192.168.0.1"},
+			}},
+		}
+		spec := BgpConfSpec{As: 65000, RouterId: "10.0.0.1"}
+
+		routerId, err := spec.NodeRouterId(node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(routerId).Should(Equal("10.0.0.1"))
+
+		spec.AsPerRack = map[string]uint32{"rack1": 65001}
+		routerId, err = spec.NodeRouterId(node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(routerId).Should(Equal("192.168.0.1"))
+
+		spec.RouterIdMode = RouterIdModeAnnotation
+		routerId, err = spec.NodeRouterId(node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(routerId).Should(Equal("10.0.0.100"))
+
+		node.Annotations[constant.OpenELBNodeRouterId] = "fd00::1"
+		_, err = spec.NodeRouterId(node)
+		Expect(err).Should(HaveOccurred())
+
+		spec.RouterIdMode = RouterIdModeHash
+		routerId, err = spec.NodeRouterId(node)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(net.ParseIP(routerId).To4()).ShouldNot(BeNil())
+		other, _ := spec.NodeRouterId(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node2"}})
+		Expect(other).ShouldNot(Equal(routerId))
+
+		spec.RouterIdMode = RouterIdModeNodeIP
+		node.Status.Addresses[0].Address = "fd00::2"
+		_, err = spec.NodeRouterId(node)
+		Expect(err).Should(HaveOccurred())
+	})
+
+	It("Test RouterIdConflicts", func() {
+		status := BgpConfStatus{NodesConfStatus: map[string]NodeConfStatus{
+			"node1": {RouterId: "10.0.0.1"},
+			"node2": {RouterId: "10.0.0.2"},
+			"node3": {RouterId: "10.0.0.1"},
+		}}
+
+		conflicts := status.RouterIdConflicts([]string{"node3", "node2", "node1"})
+		Expect(conflicts).Should(Equal(map[string][]string{"10.0.0.1": {"node1", "node3"}}))
+		condition := RouterIdConflictCondition(conflicts)
+		Expect(condition.Status).Should(Equal(metav1.ConditionTrue))
+		Expect(condition.Message).Should(ContainSubstring("node1, node3"))
+
+		conflicts = status.RouterIdConflicts([]string{"node1", "node2"})
+		Expect(conflicts).Should(BeEmpty())
+		Expect(RouterIdConflictCondition(conflicts).Status).Should(Equal(metav1.ConditionFalse))
 	})
 })
@@ -195,6 +195,25 @@ func (in *BgpConfSpec) DeepCopyInto(out *BgpConfSpec) {
 		*out = new(GracefulRestart)
 		**out = **in
 	}
+	if in.NodeMesh != nil {
+		in, out := &in.NodeMesh, &out.NodeMesh
+		*out = new(NodeMesh)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AsPathPrependPerRack != nil {
+		in, out := &in.AsPathPrependPerRack, &out.AsPathPrependPerRack
+		*out = make(map[string]uint32, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.LinkBandwidthPerRack != nil {
+		in, out := &in.LinkBandwidthPerRack, &out.LinkBandwidthPerRack
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpConfSpec.
@@ -217,6 +236,13 @@ func (in *BgpConfStatus) DeepCopyInto(out *BgpConfStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]v1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpConfStatus.
@@ -327,11 +353,21 @@ func (in *BgpPeerSpec) DeepCopyInto(out *BgpPeerSpec) {
 			}
 		}
 	}
+	if in.RouteReflector != nil {
+		in, out := &in.RouteReflector, &out.RouteReflector
+		*out = new(RouteReflector)
+		**out = **in
+	}
 	if in.NodeSelector != nil {
 		in, out := &in.NodeSelector, &out.NodeSelector
 		*out = new(v1.LabelSelector)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.DynamicNeighbors != nil {
+		in, out := &in.DynamicNeighbors, &out.DynamicNeighbors
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpPeerSpec.
@@ -354,6 +390,38 @@ func (in *BgpPeerStatus) DeepCopyInto(out *BgpPeerStatus) {
 			(*out)[key] = *val.DeepCopy()
 		}
 	}
+	if in.NodesDynamicPeerStatus != nil {
+		in, out := &in.NodesDynamicPeerStatus, &out.NodesDynamicPeerStatus
+		*out = make(map[string][]NodePeerStatus, len(*in))
+		for key, val := range *in {
+			var outVal []NodePeerStatus
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]NodePeerStatus, len(*in))
+				for i := range *in {
+					(*in)[i].DeepCopyInto(&(*out)[i])
+				}
+			}
+			(*out)[key] = outVal
+		}
+	}
+	if in.RackNodes != nil {
+		in, out := &in.RackNodes, &out.RackNodes
+		*out = make(map[string][]string, len(*in))
+		for key, val := range *in {
+			var outVal []string
+			if val == nil {
+				(*out)[key] = nil
+			} else {
+				in, out := &val, &outVal
+				*out = make([]string, len(*in))
+				copy(*out, *in)
+			}
+			(*out)[key] = outVal
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpPeerStatus.
@@ -366,6 +434,89 @@ func (in *BgpPeerStatus) DeepCopy() *BgpPeerStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BgpVrf) DeepCopyInto(out *BgpVrf) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpVrf.
+func (in *BgpVrf) DeepCopy() *BgpVrf {
+	if in == nil {
+		return nil
+	}
+	out := new(BgpVrf)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BgpVrf) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BgpVrfList) DeepCopyInto(out *BgpVrfList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]BgpVrf, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpVrfList.
+func (in *BgpVrfList) DeepCopy() *BgpVrfList {
+	if in == nil {
+		return nil
+	}
+	out := new(BgpVrfList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BgpVrfList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BgpVrfSpec) DeepCopyInto(out *BgpVrfSpec) {
+	*out = *in
+	if in.ImportRts != nil {
+		in, out := &in.ImportRts, &out.ImportRts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ExportRts != nil {
+		in, out := &in.ExportRts, &out.ExportRts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BgpVrfSpec.
+func (in *BgpVrfSpec) DeepCopy() *BgpVrfSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BgpVrfSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *EbgpMultihop) DeepCopyInto(out *EbgpMultihop) {
 	*out = *in
@@ -455,6 +606,28 @@ func (in *EipSpec) DeepCopyInto(out *EipSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.Layer2Announce != nil {
+		in, out := &in.Layer2Announce, &out.Layer2Announce
+		*out = new(Layer2Announce)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Layer2Election != nil {
+		in, out := &in.Layer2Election, &out.Layer2Election
+		*out = new(Layer2Election)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Layer2Interface != nil {
+		in, out := &in.Layer2Interface, &out.Layer2Interface
+		*out = new(Layer2Interface)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.NodeInterfaces != nil {
+		in, out := &in.NodeInterfaces, &out.NodeInterfaces
+		*out = make([]NodeInterface, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EipSpec.
@@ -477,6 +650,13 @@ func (in *EipStatus) DeepCopyInto(out *EipStatus) {
 			(*out)[key] = val
 		}
 	}
+	if in.NodeInterfaces != nil {
+		in, out := &in.NodeInterfaces, &out.NodeInterfaces
+		*out = make(map[string]NodeInterfaceStatus, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EipStatus.
@@ -519,6 +699,78 @@ func (in *GracefulRestart) DeepCopy() *GracefulRestart {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Layer2Announce) DeepCopyInto(out *Layer2Announce) {
+	*out = *in
+	if in.BurstInterval != nil {
+		in, out := &in.BurstInterval, &out.BurstInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Layer2Announce.
+func (in *Layer2Announce) DeepCopy() *Layer2Announce {
+	if in == nil {
+		return nil
+	}
+	out := new(Layer2Announce)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Layer2Election) DeepCopyInto(out *Layer2Election) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Layer2Election.
+func (in *Layer2Election) DeepCopy() *Layer2Election {
+	if in == nil {
+		return nil
+	}
+	out := new(Layer2Election)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Layer2Interface) DeepCopyInto(out *Layer2Interface) {
+	*out = *in
+	if in.SourceAddresses != nil {
+		in, out := &in.SourceAddresses, &out.SourceAddresses
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Layer2Interface.
+func (in *Layer2Interface) DeepCopy() *Layer2Interface {
+	if in == nil {
+		return nil
+	}
+	out := new(Layer2Interface)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Message) DeepCopyInto(out *Message) {
 	*out = *in
@@ -609,6 +861,66 @@ func (in *NodeConfStatus) DeepCopy() *NodeConfStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeInterface) DeepCopyInto(out *NodeInterface) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeInterface.
+func (in *NodeInterface) DeepCopy() *NodeInterface {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeInterface)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeInterfaceStatus) DeepCopyInto(out *NodeInterfaceStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeInterfaceStatus.
+func (in *NodeInterfaceStatus) DeepCopy() *NodeInterfaceStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeInterfaceStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *NodeMesh) DeepCopyInto(out *NodeMesh) {
+	*out = *in
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RouteReflectorSelector != nil {
+		in, out := &in.RouteReflectorSelector, &out.RouteReflectorSelector
+		*out = new(v1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new NodeMesh.
+func (in *NodeMesh) DeepCopy() *NodeMesh {
+	if in == nil {
+		return nil
+	}
+	out := new(NodeMesh)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *NodePeerStatus) DeepCopyInto(out *NodePeerStatus) {
 	*out = *in
@@ -681,6 +993,21 @@ func (in *Queues) DeepCopy() *Queues {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RouteReflector) DeepCopyInto(out *RouteReflector) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RouteReflector.
+func (in *RouteReflector) DeepCopy() *RouteReflector {
+	if in == nil {
+		return nil
+	}
+	out := new(RouteReflector)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Timers) DeepCopyInto(out *Timers) {
 	*out = *in
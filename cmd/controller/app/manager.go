@@ -7,6 +7,7 @@ import (
 
 	networkv1alpha2 "github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/cmd/controller/app/options"
+	"github.com/openelb/openelb/pkg/controllers/bgp"
 	"github.com/openelb/openelb/pkg/controllers/ipam"
 	"github.com/openelb/openelb/pkg/controllers/lb"
 	"github.com/openelb/openelb/pkg/manager"
@@ -87,11 +88,17 @@ func Run(c *options.OpenELBManagerOptions) error {
 		klog.Fatalf("unable to setup ipam: %v", err)
 	}
 	networkv1alpha2.Eip{}.SetupWebhookWithManager(mgr)
+	networkv1alpha2.BgpConf{}.SetupWebhookWithManager(mgr)
+	networkv1alpha2.BgpPeer{}.SetupWebhookWithManager(mgr)
 
 	if err = lb.SetupServiceReconciler(mgr); err != nil {
 		klog.Fatalf("unable to setup lb controller: %v", err)
 	}
 
+	if err = bgp.SetupBgpConfReconciler(mgr); err != nil {
+		klog.Fatalf("unable to setup bgp conf controller: %v", err)
+	}
+
 	stopCh := ctrl.SetupSignalHandler()
 	if err = mgr.Start(stopCh); err != nil {
 		klog.Fatalf("unable to run the manager: %v", err)
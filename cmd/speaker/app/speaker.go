@@ -8,6 +8,7 @@ import (
 	"github.com/openelb/openelb/cmd/speaker/app/options"
 	"github.com/openelb/openelb/pkg/constant"
 	_ "github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/nettool"
 	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/openelb/openelb/pkg/speaker/bgp"
 	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
@@ -18,6 +19,7 @@ import (
 	"github.com/spf13/pflag"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	clientset "k8s.io/client-go/kubernetes"
@@ -37,6 +39,7 @@ func init() {
 	_ = corev1.AddToScheme(scheme)
 	_ = networkv1alpha2.AddToScheme(scheme)
 	_ = appsv1.AddToScheme(scheme)
+	_ = discoveryv1.AddToScheme(scheme)
 }
 
 func NewOpenELBSpeakerCommand() *cobra.Command {
@@ -103,10 +106,31 @@ func Run(opt *options.OpenELBSpeakerOptions) error {
 		klog.Fatalf("unable to setup bgpconf: %v", err)
 	}
 
-	if err := bgp.SetupBgpPeerReconciler(bgpServer, mgr); err != nil {
+	portForwarder, err := nettool.NewPortForwarder(opt.Bgp.PortForward)
+	if err != nil {
+		klog.Fatalf("unable to setup bgp port forward: %v", err)
+	}
+
+	if err := bgp.SetupBgpPeerReconciler(bgpServer, portForwarder, mgr); err != nil {
 		klog.Fatalf("unable to setup bgppeer: %v", err)
 	}
 
+	if err := bgp.SetupBgpVrfReconciler(bgpServer, mgr); err != nil {
+		klog.Fatalf("unable to setup bgpvrf: %v", err)
+	}
+
+	if err := bgp.SetupNodeDrainReconciler(bgpServer, mgr); err != nil {
+		klog.Fatalf("unable to setup bgp drain: %v", err)
+	}
+
+	if err := bgp.SetupNodeMeshReconciler(bgpServer, mgr); err != nil {
+		klog.Fatalf("unable to setup bgp node mesh: %v", err)
+	}
+
+	if err := bgp.SetupRibServer(bgpServer, opt.Bgp, mgr); err != nil {
+		klog.Fatalf("unable to setup bgp rib server: %v", err)
+	}
+
 	if err := spmanager.RegisterSpeaker(ctx, constant.OpenELBProtocolBGP, bgpServer); err != nil {
 		klog.Fatalf("unable to register bgp speaker: %v", err)
 	}
@@ -126,7 +150,7 @@ func Run(opt *options.OpenELBSpeakerOptions) error {
 	// for layer2 mode
 	reloadChan := make(chan event.GenericEvent)
 	if opt.Layer2.EnableLayer2 {
-		layer2speaker, err := layer2.NewSpeaker(k8sClient, opt.Layer2, reloadChan)
+		layer2speaker, err := layer2.NewSpeaker(k8sClient, mgr.GetEventRecorderFor("layer2"), opt.Layer2, reloadChan)
 		if err != nil {
 			klog.Fatalf("unable to new layer2 speaker: %v", err)
 		}
@@ -148,6 +172,7 @@ func Run(opt *options.OpenELBSpeakerOptions) error {
 		Handler:       spmanager.HandleEIP,
 		Reload:        reloadChan,
 		Reloader:      spmanager.ResyncEIPSpeaker,
+		NodeReloader:  spmanager.ResyncNodes,
 		Client:        mgr.GetClient(),
 		EventRecorder: mgr.GetEventRecorderFor("eip"),
 	}).SetupWithManager(mgr); err != nil {
@@ -33,6 +33,25 @@ const (
 	OpenELBProtocolAnnotationKey    string = "protocol.openelb.kubesphere.io/v1alpha1"
 
 	OpenELBNodeRack string = "openelb.kubesphere.io/rack"
+	// Override the bgp next hop of the node, by default the InternalIP of the same family
+	OpenELBNodeNextHopV4 string = "openelb.kubesphere.io/nexthop-ipv4"
+	OpenELBNodeNextHopV6 string = "openelb.kubesphere.io/nexthop-ipv6"
+	// Weight the bgp paths of the node, overriding the per-rack settings of the BgpConf
+	OpenELBNodeAsPathPrepend string = "openelb.kubesphere.io/as-path-prepend"
+	OpenELBNodeLinkBandwidth string = "openelb.kubesphere.io/link-bandwidth"
+	// Withdraw the bgp paths of the speaker on the node and drop the node from the
+	// announcements of all speakers while it stays up, "true" or "false"
+	OpenELBNodeDrain string = "openelb.kubesphere.io/drain"
+	// Exclude the node from the announcements of all speakers, as label or annotation "true"
+	OpenELBNodeExclude string = "openelb.kubesphere.io/exclude"
+	// Router id of the speaker on the node in the annotation router id mode
+	OpenELBNodeRouterId string = "openelb.kubesphere.io/router-id"
+	// Priority of the node in the layer2 election as label, higher values win
+	OpenELBNodeLayer2Priority string = "openelb.kubesphere.io/layer2-priority"
+	// Memberlist members seen by a layer2 speaker, annotation of its lease
+	OpenELBLayer2MembersAnnotation string = "openelb.kubesphere.io/layer2-members"
+	// Well-known label excluding the node from external load balancers
+	KubernetesExcludeLBLabel string = "node.kubernetes.io/exclude-from-external-load-balancers"
 	// TODO: Disable lable modification using webhook
 	OpenELBCNI string = "openelb.kubesphere.io/cni"
 
@@ -45,12 +64,15 @@ const (
 
 	OpenELBControllerLocker = "openelb-controller"
 	OpenELBSpeakerName      = "openelb-speaker"
-	OpenELBNamespace        = "openelb-system"
-	OpenELBBgpName          = "gobgp.conf"
-	EnvOpenELBNamespace     = "OPENELB_NAMESPACE"
-	EnvDaemonsetName        = "OPENELB_DSNAME"
-	EnvNodeName             = "NODE_NAME"
-	EnvSecretName           = "MEMBER_LIST_SECRET"
+	OpenELBSpeakerRibPath   = "/openelb/rib"
+	// audience of the tokens the apiserver presents to the rib endpoint
+	OpenELBSpeakerRibAudience = "openelb-speaker"
+	OpenELBNamespace          = "openelb-system"
+	OpenELBBgpName            = "gobgp.conf"
+	EnvOpenELBNamespace       = "OPENELB_NAMESPACE"
+	EnvDaemonsetName          = "OPENELB_DSNAME"
+	EnvNodeName               = "NODE_NAME"
+	EnvSecretName             = "MEMBER_LIST_SECRET"
 
 	// default images and specify images
 	OpenELBImagesConfigMap         = "openelb-images"
@@ -62,4 +84,6 @@ const (
 	Layer2MemberlistDefaultSecret = "openelb-speakers"
 	Layer2ReloadEIPName           = "reload"
 	Layer2ReloadEIPNamespace      = "openelb-layer2-eip-reload"
+	NodeReloadEIPName             = "reload"
+	NodeReloadEIPNamespace        = "openelb-node-eip-reload"
 )
@@ -0,0 +1,116 @@
+/*
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+const (
+	controllerName = "BgpConfConflictController"
+
+	ReasonRouterIdConflict = "RouterIdConflict"
+)
+
+// +kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpconfs,verbs=get;list;watch
+// +kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpconfs/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+
+// BgpConfReconciler checks the router ids the speakers report in the
+// BgpConf status for conflicts, which break the iBGP sessions between the
+// nodes.
+type BgpConfReconciler struct {
+	client.Client
+	record.EventRecorder
+}
+
+func (r *BgpConfReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	conf := &v1alpha2.BgpConf{}
+	if err := r.Get(ctx, req.NamespacedName, conf); err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	if conf.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+
+	// the status of deleted nodes is left behind until the speakers restart
+	nodes := &corev1.NodeList{}
+	if err := r.List(ctx, nodes); err != nil {
+		return ctrl.Result{}, err
+	}
+	names := make([]string, 0, len(nodes.Items))
+	for _, node := range nodes.Items {
+		names = append(names, node.Name)
+	}
+
+	clone := conf.DeepCopy()
+	condition := v1alpha2.RouterIdConflictCondition(clone.Status.RouterIdConflicts(names))
+	condition.ObservedGeneration = clone.Generation
+	old := meta.FindStatusCondition(conf.Status.Conditions, v1alpha2.BgpConfRouterIdConflict)
+	meta.SetStatusCondition(&clone.Status.Conditions, condition)
+	if reflect.DeepEqual(clone.Status, conf.Status) {
+		return ctrl.Result{}, nil
+	}
+
+	if condition.Status == metav1.ConditionTrue && (old == nil || old.Message != condition.Message) {
+		klog.Warningf("bgp conf %s: %s", conf.Name, condition.Message)
+		r.Event(conf, corev1.EventTypeWarning, ReasonRouterIdConflict, condition.Message)
+	}
+
+	err := r.Status().Update(ctx, clone)
+	if errors.IsConflict(err) {
+		return ctrl.Result{Requeue: true}, nil
+	}
+	return ctrl.Result{}, err
+}
+
+func (r *BgpConfReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	p := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			old := e.ObjectOld.(*v1alpha2.BgpConf)
+			new := e.ObjectNew.(*v1alpha2.BgpConf)
+			return !reflect.DeepEqual(old.Status.NodesConfStatus, new.Status.NodesConfStatus) ||
+				old.Generation != new.Generation
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha2.BgpConf{}, builder.WithPredicates(p)).
+		Named(controllerName).
+		Complete(r)
+}
+
+func SetupBgpConfReconciler(mgr ctrl.Manager) error {
+	r := &BgpConfReconciler{
+		Client:        mgr.GetClient(),
+		EventRecorder: mgr.GetEventRecorderFor(controllerName),
+	}
+	return r.SetupWithManager(mgr)
+}
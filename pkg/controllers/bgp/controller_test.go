@@ -0,0 +1,108 @@
+package bgp
+
+import (
+	"context"
+	"testing"
+
+	networkv1alpha2 "github.com/openelb/openelb/api/v1alpha2"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var (
+	scheme = runtime.NewScheme()
+)
+
+func init() {
+	_ = v1.AddToScheme(scheme)
+	_ = networkv1alpha2.AddToScheme(scheme)
+}
+
+func TestBgpConfReconciler_RouterIdConflict(t *testing.T) {
+	tests := []struct {
+		name       string
+		nodes      []string
+		status     map[string]networkv1alpha2.NodeConfStatus
+		wantStatus metav1.ConditionStatus
+		wantEvent  bool
+	}{
+		{
+			name:  "unique router ids",
+			nodes: []string{"node1", "node2"},
+			status: map[string]networkv1alpha2.NodeConfStatus{
+				"node1": {RouterId: "10.0.0.1", As: 65000},
+				"node2": {RouterId: "10.0.0.2", As: 65000},
+			},
+			wantStatus: metav1.ConditionFalse,
+		},
+		{
+			name:  "duplicate router ids",
+			nodes: []string{"node1", "node2"},
+			status: map[string]networkv1alpha2.NodeConfStatus{
+				"node1": {RouterId: "10.0.0.1", As: 65000},
+				"node2": {RouterId: "10.0.0.1", As: 65000},
+			},
+			wantStatus: metav1.ConditionTrue,
+			wantEvent:  true,
+		},
+		{
+			name:  "status of a deleted node",
+			nodes: []string{"node1"},
+			status: map[string]networkv1alpha2.NodeConfStatus{
+				"node1": {RouterId: "10.0.0.1", As: 65000},
+				"node2": {RouterId: "10.0.0.1", As: 65000},
+			},
+			wantStatus: metav1.ConditionFalse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objs := []client.Object{&networkv1alpha2.BgpConf{
+				ObjectMeta: metav1.ObjectMeta{Name: networkv1alpha2.DefaultBgpConfName},
+				Status:     networkv1alpha2.BgpConfStatus{NodesConfStatus: tt.status},
+			}}
+			for _, name := range tt.nodes {
+				objs = append(objs, &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}})
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			r := &BgpConfReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).
+					WithStatusSubresource(&networkv1alpha2.BgpConf{}).Build(),
+				EventRecorder: recorder,
+			}
+
+			key := types.NamespacedName{Name: networkv1alpha2.DefaultBgpConfName}
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+
+			conf := &networkv1alpha2.BgpConf{}
+			if err := r.Get(context.Background(), key, conf); err != nil {
+				t.Fatalf("Get() error = %v", err)
+			}
+			condition := meta.FindStatusCondition(conf.Status.Conditions, networkv1alpha2.BgpConfRouterIdConflict)
+			if condition == nil || condition.Status != tt.wantStatus {
+				t.Errorf("condition = %v, want status %v", condition, tt.wantStatus)
+			}
+			if got := len(recorder.Events) > 0; got != tt.wantEvent {
+				t.Errorf("event recorded = %v, want %v", got, tt.wantEvent)
+			}
+
+			// the conflict is reported once
+			if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: key}); err != nil {
+				t.Fatalf("Reconcile() error = %v", err)
+			}
+			if len(recorder.Events) > 1 {
+				t.Errorf("events = %d, want at most 1", len(recorder.Events))
+			}
+		})
+	}
+}
@@ -9,6 +9,7 @@ import (
 	admissionv1beta1 "k8s.io/api/admission/v1beta1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -74,4 +75,5 @@ func init() {
 	_ = admissionv1beta1.AddToScheme(scheme)
 	_ = networkv1alpha2.AddToScheme(scheme)
 	_ = appsv1.AddToScheme(scheme)
+	_ = discoveryv1.AddToScheme(scheme)
 }
@@ -1,6 +1,8 @@
 package metrics
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 	"sigs.k8s.io/controller-runtime/pkg/metrics"
 )
@@ -57,17 +59,47 @@ var (
 		[]string{
 			"ip",
 		})
+	layer2Conflicts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "layer2_conflicts",
+			Help: "The number of ARP/NDP packets of other hosts claiming an announced ip.",
+		},
+		[]string{
+			"ip",
+		})
+	layer2Failover = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "layer2_failover_seconds",
+			Help:    "The time from a memberlist event until the layer2 announcements are moved.",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 14),
+		})
 
 	// BGP
 	sessionUp = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "session_up",
-			Help: "The status of BGP Sessions.",
+			Help: "Whether the BGP session is established.",
 		},
 		[]string{
 			"peerIP",
 			"nodeName",
 		})
+	sessionState = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "session_state",
+			Help: "The FSM state of BGP Sessions, from 0 (idle) to 5 (established).",
+		},
+		[]string{
+			"peerIP",
+			"nodeName",
+		})
+	sessionUptime = newUptimeCollector(
+		"session_uptime_seconds",
+		"The number of seconds the BGP session has been established.",
+		[]string{
+			"peerIP",
+			"nodeName",
+		})
 	updatesTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "updates_total",
@@ -77,19 +109,38 @@ var (
 			"peerIP",
 			"nodeName",
 		})
+	updatesSentTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "updates_sent_total",
+			Help: "The total number of update packets sent.",
+		},
+		[]string{
+			"peerIP",
+			"nodeName",
+		})
 	announcedPrefixesTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "announced_prefixes_total",
-			Help: "The total number of prefixes waiting to be announced.",
+			Help: "The number of prefixes currently announced to the peer.",
 		},
 		[]string{
 			"peerIP",
 			"nodeName",
 		})
+	withdrawnPrefixesTotal = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "withdrawn_prefixes_total",
+			Help: "The total number of prefixes withdrawn from the peer.",
+		},
+		[]string{
+			"peerIP",
+			"nodeName",
+		})
+	// kept registered for existing dashboards, it is no longer updated
 	pendingPrefixesTotal = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "pending_prefixes_total",
-			Help: "The total number of prefixes waiting to be deleted.",
+			Help: "Deprecated: use withdrawn_prefixes_total instead.",
 		},
 		[]string{
 			"peerIP",
@@ -97,6 +148,9 @@ var (
 		})
 )
 
+// sessionEstablished is the session_state of an established BGP session.
+const sessionEstablished = 5
+
 func init() {
 	// eip
 	metrics.Registry.MustRegister(addressesTotal)
@@ -107,11 +161,17 @@ func init() {
 	metrics.Registry.MustRegister(requestsReceived)
 	metrics.Registry.MustRegister(responsesSent)
 	metrics.Registry.MustRegister(gratuitousSent)
+	metrics.Registry.MustRegister(layer2Conflicts)
+	metrics.Registry.MustRegister(layer2Failover)
 
 	// BGP
 	metrics.Registry.MustRegister(sessionUp)
+	metrics.Registry.MustRegister(sessionState)
+	metrics.Registry.MustRegister(sessionUptime)
 	metrics.Registry.MustRegister(updatesTotal)
+	metrics.Registry.MustRegister(updatesSentTotal)
 	metrics.Registry.MustRegister(announcedPrefixesTotal)
+	metrics.Registry.MustRegister(withdrawnPrefixesTotal)
 	metrics.Registry.MustRegister(pendingPrefixesTotal)
 }
 
@@ -137,6 +197,14 @@ func UpdateGratuitousSentMetrics(ip string) {
 	gratuitousSent.WithLabelValues(ip).Inc()
 }
 
+func UpdateLayer2ConflictMetrics(ip string) {
+	layer2Conflicts.WithLabelValues(ip).Inc()
+}
+
+func UpdateLayer2FailoverMetrics(d time.Duration) {
+	layer2Failover.Observe(d.Seconds())
+}
+
 func UpdateResponsesSentMetrics(ip string) {
 	responsesSent.WithLabelValues(ip).Inc()
 }
@@ -148,28 +216,46 @@ func DeleteLayer2Metrics(ip string) {
 	gratuitousSent.DeleteLabelValues(ip)
 	responsesSent.DeleteLabelValues(ip)
 	requestsReceived.DeleteLabelValues(ip)
+	layer2Conflicts.DeleteLabelValues(ip)
 }
 
 func InitBGPPeerMetrics(peerIP, node string) {
 	sessionUp.WithLabelValues(peerIP, node).Add(0)
+	sessionState.WithLabelValues(peerIP, node).Add(0)
+	sessionUptime.set(time.Time{}, peerIP, node)
 	updatesTotal.WithLabelValues(peerIP, node).Add(0)
+	updatesSentTotal.WithLabelValues(peerIP, node).Add(0)
 	announcedPrefixesTotal.WithLabelValues(peerIP, node).Add(0)
+	withdrawnPrefixesTotal.WithLabelValues(peerIP, node).Add(0)
 	pendingPrefixesTotal.WithLabelValues(peerIP, node).Add(0)
 }
 
-func UpdateBGPSessionMetrics(peerIP, node string, state, updateTotal float64) {
-	sessionUp.WithLabelValues(peerIP, node).Set(state)
-	updatesTotal.WithLabelValues(peerIP, node).Set(updateTotal)
+// UpdateBGPSessionMetrics records the state of a BGP session. establishedAt is
+// the time the session came up, zero if it is unknown or not established.
+func UpdateBGPSessionMetrics(peerIP, node string, state float64, establishedAt time.Time, updatesReceived, updatesSent float64) {
+	up := float64(0)
+	if state == sessionEstablished {
+		up = 1
+	}
+	sessionUp.WithLabelValues(peerIP, node).Set(up)
+	sessionState.WithLabelValues(peerIP, node).Set(state)
+	sessionUptime.set(establishedAt, peerIP, node)
+	updatesTotal.WithLabelValues(peerIP, node).Set(updatesReceived)
+	updatesSentTotal.WithLabelValues(peerIP, node).Set(updatesSent)
 }
 
-func UpdateBGPPathMetrics(peerIP, node string, announcedTotal, pendingTotal float64) {
-	announcedPrefixesTotal.WithLabelValues(peerIP, node).Add(announcedTotal)
-	pendingPrefixesTotal.WithLabelValues(peerIP, node).Add(pendingTotal)
+func UpdateBGPPathMetrics(peerIP, node string, announced, withdrawn float64) {
+	announcedPrefixesTotal.WithLabelValues(peerIP, node).Set(announced)
+	withdrawnPrefixesTotal.WithLabelValues(peerIP, node).Set(withdrawn)
 }
 
 func DeleteBGPPeerMetrics(peerIP, node string) {
 	sessionUp.DeleteLabelValues(peerIP, node)
+	sessionState.DeleteLabelValues(peerIP, node)
+	sessionUptime.delete(peerIP, node)
 	updatesTotal.DeleteLabelValues(peerIP, node)
+	updatesSentTotal.DeleteLabelValues(peerIP, node)
 	announcedPrefixesTotal.DeleteLabelValues(peerIP, node)
+	withdrawnPrefixesTotal.DeleteLabelValues(peerIP, node)
 	pendingPrefixesTotal.DeleteLabelValues(peerIP, node)
 }
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const labelSeparator = "\xff"
+
+// uptimeCollector reports how long ago something started, computed at scrape
+// time so that the value does not go stale between updates.
+type uptimeCollector struct {
+	desc *prometheus.Desc
+
+	lock  sync.Mutex
+	since map[string]time.Time
+}
+
+func newUptimeCollector(name, help string, labels []string) *uptimeCollector {
+	return &uptimeCollector{
+		desc:  prometheus.NewDesc(name, help, labels, nil),
+		since: make(map[string]time.Time),
+	}
+}
+
+// set records the start time for the given label values, a zero time reports
+// an uptime of 0.
+func (c *uptimeCollector) set(since time.Time, labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.since[strings.Join(labelValues, labelSeparator)] = since
+}
+
+func (c *uptimeCollector) delete(labelValues ...string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.since, strings.Join(labelValues, labelSeparator))
+}
+
+func (c *uptimeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+func (c *uptimeCollector) Collect(ch chan<- prometheus.Metric) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+	for key, since := range c.since {
+		uptime := float64(0)
+		if !since.IsZero() {
+			uptime = now.Sub(since).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, uptime, strings.Split(key, labelSeparator)...)
+	}
+}
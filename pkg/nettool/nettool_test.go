@@ -1,13 +1,85 @@
 package nettool_test
 
 import (
+	"net"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/expr"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	. "github.com/openelb/openelb/pkg/nettool"
+	"github.com/openelb/openelb/pkg/nettool/iptables"
 )
 
 var _ = Describe("Nettool", func() {
 	It("Should generate right iptables rule", func() {
 		Expect(GenerateCretiriaAndAction("10.10.12.1", "10.10.12.2", 17900)).To(ConsistOf("-s", "10.10.12.1", "-p", "tcp", "--dport", "179", "-j", "DNAT", "--to-destination", "10.10.12.2:17900"))
 	})
+
+	It("Should forward bgp port with iptables", func() {
+		ipt := iptables.NewFakeIPTables()
+		forwarder := NewIptablesForwarder(ipt)
+
+		Expect(forwarder.AddPortForward("10.10.12.1", "10.10.12.2", 17900)).Should(Succeed())
+		Expect(ipt.Exists("nat", "PREROUTING", "-j", BgpNatChain)).To(BeTrue())
+		Expect(ipt.Exists("nat", BgpNatChain, GenerateCretiriaAndAction("10.10.12.1", "10.10.12.2", 17900)...)).To(BeTrue())
+
+		By("replacing the forward of the router")
+		Expect(forwarder.AddPortForward("10.10.12.1", "10.10.12.2", 17901)).Should(Succeed())
+		Expect(ipt.Data["nat"][BgpNatChain]).To(HaveLen(1))
+		Expect(ipt.Exists("nat", BgpNatChain, GenerateCretiriaAndAction("10.10.12.1", "10.10.12.2", 17901)...)).To(BeTrue())
+
+		Expect(forwarder.AddPortForward("10.10.12.3", "10.10.12.2", 17901)).Should(Succeed())
+		Expect(forwarder.DeletePortForward("10.10.12.1")).Should(Succeed())
+		Expect(ipt.Data["nat"][BgpNatChain]).To(HaveLen(1))
+		Expect(forwarder.DeletePortForward("10.10.12.1")).Should(Succeed())
+
+		Expect(forwarder.AddPortForward("fd00::1", "fd00::2", 17901)).ShouldNot(Succeed())
+
+		By("cleaning up the chain")
+		Expect(forwarder.Cleanup()).Should(Succeed())
+		Expect(ipt.Exists("nat", "PREROUTING", "-j", BgpNatChain)).To(BeFalse())
+		Expect(ipt.Data["nat"]).NotTo(HaveKey(BgpNatChain))
+	})
+
+	It("Should forward only if the bgp port cannot be bound", func() {
+		forwarder, err := NewPortForwarder(PortForwardNone)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(forwarder).To(BeNil())
+
+		_, err = NewPortForwarder("unknown")
+		Expect(err).Should(HaveOccurred())
+
+		l, err := net.Listen("tcp", ":"+BGPPort)
+		if err != nil {
+			Skip("bgp port cannot be bound: " + err.Error())
+		}
+		Expect(l.Close()).Should(Succeed())
+
+		forwarder, err = NewPortForwarder("")
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(forwarder).To(BeNil())
+	})
+
+	It("Should generate right nftables rule", func() {
+		family, exprs, err := NftablesForwardExprs("10.10.12.1", "10.10.12.2", 17900)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(family).To(Equal(nftables.TableFamilyIPv4))
+		Expect(exprs[0]).To(Equal(&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 12, Len: 4}))
+		Expect(exprs[1].(*expr.Cmp).Data).To(Equal([]byte{10, 10, 12, 1}))
+		Expect(exprs[5].(*expr.Cmp).Data).To(Equal([]byte{0, 179}))
+		Expect(exprs[6].(*expr.Immediate).Data).To(Equal([]byte{10, 10, 12, 2}))
+		Expect(exprs[7].(*expr.Immediate).Data).To(Equal([]byte{0x45, 0xEC}))
+		Expect(exprs[8].(*expr.NAT).Type).To(Equal(expr.NATTypeDestNAT))
+
+		family, exprs, err = NftablesForwardExprs("fd00::1", "fd00::2", 17900)
+		Expect(err).ShouldNot(HaveOccurred())
+		Expect(family).To(Equal(nftables.TableFamilyIPv6))
+		Expect(exprs[0]).To(Equal(&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: 8, Len: 16}))
+
+		_, _, err = NftablesForwardExprs("fd00::1", "10.10.12.2", 17900)
+		Expect(err).Should(HaveOccurred())
+		_, _, err = NftablesForwardExprs("10.10.12.1", "fd00::2", 17900)
+		Expect(err).Should(HaveOccurred())
+	})
 })
@@ -0,0 +1,177 @@
+package nettool
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/google/nftables"
+	"github.com/google/nftables/binaryutil"
+	"github.com/google/nftables/expr"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	NftablesTable = "openelb"
+	NftablesChain = "prerouting"
+)
+
+type nftablesForwarder struct {
+	lock sync.Mutex
+	conn *nftables.Conn
+}
+
+// NewNftablesForwarder forwards through the prerouting nat chain of the
+// openelb tables, which are owned by the speaker and removed on Cleanup.
+func NewNftablesForwarder() (PortForwarder, error) {
+	conn, err := nftables.New()
+	if err != nil {
+		return nil, err
+	}
+	return &nftablesForwarder{conn: conn}, nil
+}
+
+// NftablesForwardExprs returns the family and the expressions of the rule
+// forwarding routerIP:179 to localIP:port.
+func NftablesForwardExprs(routerIP, localIP string, port int32) (nftables.TableFamily, []expr.Any, error) {
+	router, local := net.ParseIP(routerIP), net.ParseIP(localIP)
+	if router == nil || local == nil {
+		return 0, nil, fmt.Errorf("invalid port forward %s -> %s", routerIP, localIP)
+	}
+
+	family, natFamily := nftables.TableFamilyIPv6, uint32(unix.NFPROTO_IPV6)
+	saddrOffset, addrLen := uint32(8), uint32(net.IPv6len)
+	if router.To4() != nil {
+		family, natFamily = nftables.TableFamilyIPv4, uint32(unix.NFPROTO_IPV4)
+		saddrOffset, addrLen = 12, net.IPv4len
+		router, local = router.To4(), local.To4()
+	}
+	if local == nil || (family == nftables.TableFamilyIPv6 && local.To4() != nil) {
+		return 0, nil, fmt.Errorf("port forward %s -> %s mixes address families", routerIP, localIP)
+	}
+
+	bgpPort, _ := strconv.Atoi(BGPPort)
+	return family, []expr.Any{
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseNetworkHeader, Offset: saddrOffset, Len: addrLen},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: router},
+		&expr.Meta{Key: expr.MetaKeyL4PROTO, Register: 1},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: []byte{unix.IPPROTO_TCP}},
+		&expr.Payload{DestRegister: 1, Base: expr.PayloadBaseTransportHeader, Offset: 2, Len: 2},
+		&expr.Cmp{Op: expr.CmpOpEq, Register: 1, Data: binaryutil.BigEndian.PutUint16(uint16(bgpPort))},
+		&expr.Immediate{Register: 1, Data: local},
+		&expr.Immediate{Register: 2, Data: binaryutil.BigEndian.PutUint16(uint16(port))},
+		&expr.NAT{Type: expr.NATTypeDestNAT, Family: natFamily, RegAddrMin: 1, RegProtoMin: 2},
+	}, nil
+}
+
+func nftablesUserData(routerIP string) []byte {
+	return []byte("openelb-bgp:" + routerIP)
+}
+
+func (f *nftablesForwarder) ensureChain(family nftables.TableFamily) (*nftables.Table, *nftables.Chain) {
+	table := f.conn.AddTable(&nftables.Table{Name: NftablesTable, Family: family})
+	chain := f.conn.AddChain(&nftables.Chain{
+		Name:     NftablesChain,
+		Table:    table,
+		Type:     nftables.ChainTypeNAT,
+		Hooknum:  nftables.ChainHookPrerouting,
+		Priority: nftables.ChainPriorityNATDest,
+	})
+	return table, chain
+}
+
+// deleteOwned queues the deletion of the rules owned by routerIP.
+func (f *nftablesForwarder) deleteOwned(table *nftables.Table, chain *nftables.Chain, routerIP string) error {
+	rules, err := f.conn.GetRules(table, chain)
+	if err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		if bytes.Equal(rule.UserData, nftablesUserData(routerIP)) {
+			if err := f.conn.DelRule(rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (f *nftablesForwarder) AddPortForward(routerIP, localIP string, port int32) error {
+	family, exprs, err := NftablesForwardExprs(routerIP, localIP, port)
+	if err != nil {
+		return err
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	table, chain := f.ensureChain(family)
+	if err := f.conn.Flush(); err != nil {
+		return err
+	}
+	if err := f.deleteOwned(table, chain, routerIP); err != nil {
+		return err
+	}
+	f.conn.AddRule(&nftables.Rule{
+		Table:    table,
+		Chain:    chain,
+		Exprs:    exprs,
+		UserData: nftablesUserData(routerIP),
+	})
+	return f.conn.Flush()
+}
+
+func (f *nftablesForwarder) DeletePortForward(routerIP string) error {
+	ip := net.ParseIP(routerIP)
+	if ip == nil {
+		return fmt.Errorf("invalid router ip %q", routerIP)
+	}
+	family := nftables.TableFamilyIPv6
+	if ip.To4() != nil {
+		family = nftables.TableFamilyIPv4
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	table, err := f.ownedTable(family)
+	if err != nil || table == nil {
+		return err
+	}
+	chain := &nftables.Chain{Name: NftablesChain, Table: table}
+	if err := f.deleteOwned(table, chain, routerIP); err != nil {
+		return err
+	}
+	return f.conn.Flush()
+}
+
+func (f *nftablesForwarder) ownedTable(family nftables.TableFamily) (*nftables.Table, error) {
+	tables, err := f.conn.ListTablesOfFamily(family)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		if table.Name == NftablesTable {
+			return table, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *nftablesForwarder) Cleanup() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, family := range []nftables.TableFamily{nftables.TableFamilyIPv4, nftables.TableFamilyIPv6} {
+		table, err := f.ownedTable(family)
+		if err != nil {
+			return err
+		}
+		if table != nil {
+			f.conn.DelTable(table)
+		}
+	}
+	return f.conn.Flush()
+}
@@ -0,0 +1,203 @@
+package nettool
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"sync"
+
+	coreosiptables "github.com/coreos/go-iptables/iptables"
+	"github.com/openelb/openelb/pkg/nettool/iptables"
+	"k8s.io/klog/v2"
+)
+
+const (
+	PortForwardIptables = "iptables"
+	PortForwardNftables = "nftables"
+	PortForwardAuto     = "auto"
+	PortForwardNone     = "none"
+)
+
+// PortForwarder redirects the bgp sessions of routers that only dial port 179
+// to the port gobgp listens on, when the speaker cannot bind 179 itself.
+type PortForwarder interface {
+	// AddPortForward forwards routerIP:179 to localIP:port, replacing a forward
+	// previously added for the same router.
+	AddPortForward(routerIP, localIP string, port int32) error
+	// DeletePortForward removes the forward added for the router, if any.
+	DeletePortForward(routerIP string) error
+	// Cleanup removes every rule owned by the forwarder.
+	Cleanup() error
+}
+
+// NewPortForwarder returns the forwarder for mode, nil if mode is none. An
+// empty mode falls back to the auto mode if the speaker cannot bind the bgp
+// port itself, which uses iptables when the binary is available and nftables
+// otherwise.
+func NewPortForwarder(mode string) (PortForwarder, error) {
+	switch mode {
+	case "":
+		err := bindBGPPort()
+		if err == nil {
+			return nil, nil
+		}
+		klog.Infof("forwarding bgp port %s, it cannot be bound: %v", BGPPort, err)
+		return NewPortForwarder(PortForwardAuto)
+	case PortForwardNone:
+		return nil, nil
+	case PortForwardAuto:
+		if _, err := exec.LookPath("iptables"); err == nil {
+			return NewPortForwarder(PortForwardIptables)
+		}
+		return NewPortForwarder(PortForwardNftables)
+	case PortForwardIptables:
+		ipt, err := coreosiptables.New()
+		if err != nil {
+			return nil, err
+		}
+		return NewIptablesForwarder(ipt), nil
+	case PortForwardNftables:
+		return NewNftablesForwarder()
+	}
+	return nil, fmt.Errorf("unknown port forward mode %q", mode)
+}
+
+// bindBGPPort checks whether the bgp port can be bound, before gobgp listens
+// on it.
+func bindBGPPort() error {
+	l, err := net.Listen("tcp", ":"+BGPPort)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+type forward struct {
+	localIP string
+	port    int32
+}
+
+type iptablesForwarder struct {
+	lock sync.Mutex
+	ipt  iptables.IptablesIface
+	// forwards owned by the forwarder, keyed by router ip
+	forwards map[string]forward
+}
+
+// NewIptablesForwarder forwards through the nat chain PREROUTING-OPENELB, ipv4 only.
+func NewIptablesForwarder(ipt iptables.IptablesIface) PortForwarder {
+	return &iptablesForwarder{
+		ipt:      ipt,
+		forwards: make(map[string]forward),
+	}
+}
+
+var bgpNatJump = []string{"-j", BgpNatChain}
+
+func (f *iptablesForwarder) ensureChain() error {
+	chains, err := f.ipt.ListChains("nat")
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, chain := range chains {
+		if chain == BgpNatChain {
+			found = true
+			break
+		}
+	}
+	if !found {
+		if err := f.ipt.NewChain("nat", BgpNatChain); err != nil {
+			return err
+		}
+	}
+
+	ok, err := f.ipt.Exists("nat", "PREROUTING", bgpNatJump...)
+	if err != nil || ok {
+		return err
+	}
+	return f.ipt.Insert("nat", "PREROUTING", 1, bgpNatJump...)
+}
+
+func (f *iptablesForwarder) AddPortForward(routerIP, localIP string, port int32) error {
+	ip := net.ParseIP(routerIP)
+	if ip == nil || ip.To4() == nil {
+		return fmt.Errorf("iptables port forward only supports ipv4 routers, got %q", routerIP)
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if err := f.ensureChain(); err != nil {
+		return err
+	}
+	if old, ok := f.forwards[routerIP]; ok && old != (forward{localIP, port}) {
+		if err := f.delete(routerIP, old); err != nil {
+			return err
+		}
+	}
+	if err := AddPortForwardOfBGP(f.ipt, routerIP, localIP, port); err != nil {
+		return err
+	}
+	f.forwards[routerIP] = forward{localIP, port}
+	return nil
+}
+
+func (f *iptablesForwarder) delete(routerIP string, fw forward) error {
+	ok, err := f.ipt.Exists("nat", BgpNatChain, GenerateCretiriaAndAction(routerIP, fw.localIP, fw.port)...)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := DeletePortForwardOfBGP(f.ipt, routerIP, fw.localIP, fw.port); err != nil {
+			return err
+		}
+	}
+	delete(f.forwards, routerIP)
+	return nil
+}
+
+func (f *iptablesForwarder) DeletePortForward(routerIP string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	fw, ok := f.forwards[routerIP]
+	if !ok {
+		return nil
+	}
+	return f.delete(routerIP, fw)
+}
+
+func (f *iptablesForwarder) Cleanup() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ok, err := f.ipt.Exists("nat", "PREROUTING", bgpNatJump...)
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := f.ipt.Delete("nat", "PREROUTING", bgpNatJump...); err != nil {
+			return err
+		}
+	}
+
+	chains, err := f.ipt.ListChains("nat")
+	if err != nil {
+		return err
+	}
+	for _, chain := range chains {
+		if chain != BgpNatChain {
+			continue
+		}
+		if err := f.ipt.ClearChain("nat", BgpNatChain); err != nil {
+			return err
+		}
+		if err := f.ipt.DeleteChain("nat", BgpNatChain); err != nil {
+			return err
+		}
+	}
+
+	f.forwards = make(map[string]forward)
+	return nil
+}
@@ -0,0 +1,235 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/server/options"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	"github.com/openelb/openelb/pkg/util"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NodeRib is the RIB of the speaker running on a node.
+type NodeRib struct {
+	Node string    `json:"node"`
+	Rib  *bgpd.Rib `json:"rib,omitempty"`
+	// set if the speaker could not be queried
+	Error string `json:"error,omitempty"`
+}
+
+// RibHandler is an interface that is used to manage http requests related to
+// the RIB of the speakers.
+type RibHandler interface {
+	// List returns the RIB of the speakers on every node.
+	List(ctx context.Context) ([]NodeRib, error)
+	// Get returns the RIB of the speaker on the node.
+	Get(ctx context.Context, node string) (*NodeRib, error)
+}
+
+// lifetime of the tokens presented to the speakers
+const ribTokenExpiration = 10 * time.Minute
+
+// ribHandler is an implementation of the RibHandler.
+type ribHandler struct {
+	client         client.Client
+	httpClient     *http.Client
+	port           int
+	selector       string
+	namespace      string
+	daemonSet      string
+	serviceAccount string
+
+	lock    sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// NewRibHandler returns a new instance of ribHandler which implements
+// the RibHandler interface. The RIBs are fetched over tls from the rib
+// endpoint of the speaker pods of the speaker daemonset, with short-lived
+// tokens bound to the speaker audience.
+func NewRibHandler(client client.Client, opts *options.Options) (*ribHandler, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if opts.SpeakerCAFile != "" {
+		ca, err := os.ReadFile(opts.SpeakerCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", opts.SpeakerCAFile)
+		}
+		tlsConfig.RootCAs = pool
+		// the speakers run in the host network, their certificates are issued
+		// for the speaker name instead of the node addresses
+		tlsConfig.ServerName = constant.OpenELBSpeakerName
+	} else {
+		klog.Warningf("the certificates of the speakers are not verified, set a ca to verify them")
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	return &ribHandler{
+		client: client,
+		httpClient: &http.Client{
+			Timeout:   5 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+		port:           opts.SpeakerRibPort,
+		selector:       opts.SpeakerSelector,
+		namespace:      util.EnvNamespace(),
+		daemonSet:      opts.SpeakerDaemonSet,
+		serviceAccount: opts.ServiceAccount,
+	}, nil
+}
+
+// speakers returns the running pods of the speaker daemonset, pods merely
+// carrying the speaker labels are never sent a token.
+func (h *ribHandler) speakers(ctx context.Context) ([]corev1.Pod, error) {
+	selector, err := labels.Parse(h.selector)
+	if err != nil {
+		return nil, err
+	}
+
+	pods := &corev1.PodList{}
+	if err := h.client.List(ctx, pods, client.InNamespace(h.namespace),
+		client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	var result []corev1.Pod
+	for _, pod := range pods.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "DaemonSet" || owner.Name != h.daemonSet {
+			continue
+		}
+		if pod.Status.Phase == corev1.PodRunning && pod.Status.PodIP != "" && pod.Spec.NodeName != "" {
+			result = append(result, pod)
+		}
+	}
+	return result, nil
+}
+
+// getToken returns a token of the service account of the apiserver bound to
+// the speaker audience, it is renewed before it expires.
+func (h *ribHandler) getToken(ctx context.Context) (string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.token != "" && time.Until(h.expires) > ribTokenExpiration/5 {
+		return h.token, nil
+	}
+
+	seconds := int64(ribTokenExpiration.Seconds())
+	sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Namespace: h.namespace, Name: h.serviceAccount}}
+	request := &authenticationv1.TokenRequest{Spec: authenticationv1.TokenRequestSpec{
+		Audiences:         []string{constant.OpenELBSpeakerRibAudience},
+		ExpirationSeconds: &seconds,
+	}}
+	if err := h.client.SubResource("token").Create(ctx, sa, request); err != nil {
+		return "", fmt.Errorf("request token of service account %s/%s: %v", h.namespace, h.serviceAccount, err)
+	}
+
+	h.token = request.Status.Token
+	h.expires = request.Status.ExpirationTimestamp.Time
+	return h.token, nil
+}
+
+func (h *ribHandler) fetch(ctx context.Context, pod corev1.Pod) NodeRib {
+	nodeRib := NodeRib{Node: pod.Spec.NodeName}
+	rib, err := h.fetchRib(ctx, pod.Status.PodIP)
+	if err != nil {
+		nodeRib.Error = err.Error()
+		return nodeRib
+	}
+	nodeRib.Rib = rib
+	return nodeRib
+}
+
+func (h *ribHandler) fetchRib(ctx context.Context, ip string) (*bgpd.Rib, error) {
+	token, err := h.getToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	url := fmt.Sprintf("https://%s%s", net.JoinHostPort(ip, strconv.Itoa(h.port)), constant.OpenELBSpeakerRibPath)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("speaker returned %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	rib := &bgpd.Rib{}
+	return rib, json.NewDecoder(resp.Body).Decode(rib)
+}
+
+// List returns the RIB of the speakers on every node.
+func (h *ribHandler) List(ctx context.Context) ([]NodeRib, error) {
+	pods, err := h.speakers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ribs := make([]NodeRib, len(pods))
+	var wg sync.WaitGroup
+	for i := range pods {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ribs[i] = h.fetch(ctx, pods[i])
+		}(i)
+	}
+	wg.Wait()
+
+	sort.Slice(ribs, func(i, j int) bool {
+		return ribs[i].Node < ribs[j].Node
+	})
+	return ribs, nil
+}
+
+// Get returns the RIB of the speaker on the node.
+func (h *ribHandler) Get(ctx context.Context, node string) (*NodeRib, error) {
+	pods, err := h.speakers(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pod := range pods {
+		if pod.Spec.NodeName == node {
+			nodeRib := h.fetch(ctx, pod)
+			return &nodeRib, nil
+		}
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{Resource: "speakers"}, node)
+}
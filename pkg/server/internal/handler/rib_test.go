@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/server/options"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	certutil "k8s.io/client-go/util/cert"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func speakerPod(namespace, name, node, ip, daemonSet string) *corev1.Pod {
+	controller := true
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      name,
+			Labels:    map[string]string{"app": "openelb", "component": "speaker"},
+			OwnerReferences: []metav1.OwnerReference{{
+				APIVersion: "apps/v1",
+				Kind:       "DaemonSet",
+				Name:       daemonSet,
+				UID:        "uid",
+				Controller: &controller,
+			}},
+		},
+		Spec:   corev1.PodSpec{NodeName: node},
+		Status: corev1.PodStatus{Phase: corev1.PodRunning, PodIP: ip},
+	}
+}
+
+// startSpeaker serves a rib on 127.0.0.1 with a certificate issued for the
+// speaker name, it returns the port and the file of the ca.
+func startSpeaker(t *testing.T, token string, rib *bgpd.Rib) (int, string) {
+	certPEM, keyPEM, err := certutil.GenerateSelfSignedCertKey(constant.OpenELBSpeakerName, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(t.TempDir(), "ca.crt")
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != constant.OpenELBSpeakerRibPath || r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(rib)
+	}))
+	server.TLS = &tls.Config{Certificates: []tls.Certificate{cert}}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	_, port, err := net.SplitHostPort(server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := strconv.Atoi(port)
+	return p, caFile
+}
+
+func TestRibHandler(t *testing.T) {
+	t.Setenv(constant.EnvOpenELBNamespace, "openelb-system")
+
+	rib := &bgpd.Rib{
+		AdjRibOut: []bgpd.PeerRib{{Peer: "192.168.0.2", Routes: []bgpd.RibRoute{}}},
+		Global:    []bgpd.RibRoute{{Prefix: "10.0.0.1/32", NextHop: "192.168.0.10", Best: true}},
+	}
+	port, caFile := startSpeaker(t, "token-1", rib)
+
+	requests := 0
+	c := fake.NewClientBuilder().WithObjects(
+		speakerPod("openelb-system", "speaker-1", "node1", "127.0.0.1", constant.OpenELBSpeakerName),
+		// nothing listens on 127.0.0.2
+		speakerPod("openelb-system", "speaker-2", "node2", "127.0.0.2", constant.OpenELBSpeakerName),
+		// pods with the speaker labels outside of the daemonset
+		speakerPod("openelb-system", "impostor", "node3", "127.0.0.1", "other"),
+		speakerPod("default", "impostor", "node4", "127.0.0.1", constant.OpenELBSpeakerName),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, subResourceObj client.Object, opts ...client.SubResourceCreateOption) error {
+			request, ok := subResourceObj.(*authenticationv1.TokenRequest)
+			if subResource != "token" || !ok || obj.GetName() != "openelb-apiserver" || obj.GetNamespace() != "openelb-system" {
+				t.Errorf("unexpected %s request for %s/%s", subResource, obj.GetNamespace(), obj.GetName())
+				return errors.NewBadRequest("unexpected request")
+			}
+			if len(request.Spec.Audiences) != 1 || request.Spec.Audiences[0] != constant.OpenELBSpeakerRibAudience {
+				t.Errorf("token requested for audiences %v", request.Spec.Audiences)
+			}
+			requests++
+			request.Status.Token = "token-1"
+			request.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(ribTokenExpiration))
+			return nil
+		},
+	}).Build()
+
+	opts := options.NewOptions()
+	opts.SpeakerRibPort = port
+	opts.SpeakerCAFile = caFile
+	h, err := NewRibHandler(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ribs, err := h.List(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ribs) != 2 || ribs[0].Node != "node1" || ribs[1].Node != "node2" {
+		t.Fatalf("List() = %+v, want the speakers of node1 and node2", ribs)
+	}
+	if ribs[0].Error != "" || ribs[0].Rib == nil || len(ribs[0].Rib.Global) != 1 {
+		t.Errorf("rib of node1 = %+v", ribs[0])
+	}
+	if ribs[1].Error == "" || ribs[1].Rib != nil {
+		t.Errorf("rib of node2 = %+v, want an error", ribs[1])
+	}
+
+	nodeRib, err := h.Get(context.Background(), "node1")
+	if err != nil || nodeRib.Rib == nil {
+		t.Errorf("Get(node1) = %+v, %v", nodeRib, err)
+	}
+	if _, err := h.Get(context.Background(), "node4"); !errors.IsNotFound(err) {
+		t.Errorf("Get(node4) error = %v, want not found", err)
+	}
+	if requests != 1 {
+		t.Errorf("%d tokens requested, want 1", requests)
+	}
+}
+
+func TestRibHandlerUntrustedSpeaker(t *testing.T) {
+	t.Setenv(constant.EnvOpenELBNamespace, "openelb-system")
+
+	port, _ := startSpeaker(t, "token-1", &bgpd.Rib{})
+	_, otherCA := startSpeaker(t, "token-1", &bgpd.Rib{})
+
+	c := fake.NewClientBuilder().WithObjects(
+		speakerPod("openelb-system", "speaker-1", "node1", "127.0.0.1", constant.OpenELBSpeakerName),
+	).WithInterceptorFuncs(interceptor.Funcs{
+		SubResourceCreate: func(ctx context.Context, c client.Client, subResource string, obj client.Object, subResourceObj client.Object, opts ...client.SubResourceCreateOption) error {
+			request := subResourceObj.(*authenticationv1.TokenRequest)
+			request.Status.Token = "token-1"
+			request.Status.ExpirationTimestamp = metav1.NewTime(time.Now().Add(ribTokenExpiration))
+			return nil
+		},
+	}).Build()
+
+	opts := options.NewOptions()
+	opts.SpeakerRibPort = port
+	opts.SpeakerCAFile = otherCA
+	h, err := NewRibHandler(c, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nodeRib, err := h.Get(context.Background(), "node1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nodeRib.Error == "" {
+		t.Errorf("rib fetched from a speaker with an untrusted certificate")
+	}
+}
@@ -72,12 +72,10 @@ func writeResponse(w http.ResponseWriter, statusCode int, resp interface{}) erro
 		statusCode = http.StatusOK
 	}
 	w.Header().Set("Content-Type", "application/json")
+	// the status is sent with the first write of the body
+	w.WriteHeader(statusCode)
 	if resp != nil {
-		if err := json.NewEncoder(w).Encode(resp); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			return err
-		}
+		return json.NewEncoder(w).Encode(resp)
 	}
-	w.WriteHeader(statusCode)
 	return nil
 }
@@ -0,0 +1,50 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openelb/openelb/pkg/server/internal/handler"
+	"github.com/openelb/openelb/pkg/server/internal/lib"
+)
+
+type ribRouter struct {
+	handler handler.RibHandler
+}
+
+func (b *ribRouter) Register(r chi.Router) {
+	r.Get("/apis/v1/bgp/rib", b.list)
+	r.Get("/apis/v1/bgp/rib/{node}", b.get)
+}
+
+// NewRibRouter returns a new instance of ribRouter which implements the
+// Router interface. This is used to register the endpoints to the http
+// router.
+func NewRibRouter(handler handler.RibHandler) *ribRouter {
+	return &ribRouter{
+		handler: handler,
+	}
+}
+
+func (b *ribRouter) list(w http.ResponseWriter, r *http.Request) {
+	lib.ServeRequest(lib.InboundRequest{
+		W: w,
+		R: r,
+		EndpointLogic: func() (interface{}, error) {
+			return b.handler.List(r.Context())
+		},
+		StatusCode: http.StatusOK,
+	})
+}
+
+func (b *ribRouter) get(w http.ResponseWriter, r *http.Request) {
+	node := chi.URLParam(r, "node")
+	lib.ServeRequest(lib.InboundRequest{
+		W: w,
+		R: r,
+		EndpointLogic: func() (interface{}, error) {
+			return b.handler.Get(r.Context(), node)
+		},
+		StatusCode: http.StatusOK,
+	})
+}
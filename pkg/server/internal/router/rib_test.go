@@ -0,0 +1,71 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/openelb/openelb/pkg/server/internal/handler"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+type fakeRibHandler struct {
+	ribs []handler.NodeRib
+}
+
+func (f fakeRibHandler) List(ctx context.Context) ([]handler.NodeRib, error) {
+	return f.ribs, nil
+}
+
+func (f fakeRibHandler) Get(ctx context.Context, node string) (*handler.NodeRib, error) {
+	for i := range f.ribs {
+		if f.ribs[i].Node == node {
+			return &f.ribs[i], nil
+		}
+	}
+	return nil, errors.NewNotFound(schema.GroupResource{Resource: "speakers"}, node)
+}
+
+func TestRibRouter(t *testing.T) {
+	ribs := []handler.NodeRib{
+		{Node: "node1", Rib: &bgpd.Rib{Global: []bgpd.RibRoute{{Prefix: "10.0.0.1/32", NextHop: "192.168.0.10"}}}},
+		{Node: "node2", Error: "connection refused"},
+	}
+	r := chi.NewRouter()
+	NewRibRouter(fakeRibHandler{ribs: ribs}).Register(r)
+
+	get := func(path string, code int, result interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != code {
+			t.Fatalf("GET %s = %d, want %d", path, w.Code, code)
+		}
+		if err := json.NewDecoder(w.Body).Decode(result); err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+	}
+
+	var list []handler.NodeRib
+	get("/apis/v1/bgp/rib", http.StatusOK, &list)
+	if len(list) != 2 || list[0].Rib == nil || list[1].Error == "" {
+		t.Errorf("GET /apis/v1/bgp/rib = %+v", list)
+	}
+
+	var nodeRib handler.NodeRib
+	get("/apis/v1/bgp/rib/node1", http.StatusOK, &nodeRib)
+	if nodeRib.Node != "node1" || nodeRib.Rib == nil || nodeRib.Rib.Global[0].Prefix != "10.0.0.1/32" {
+		t.Errorf("GET /apis/v1/bgp/rib/node1 = %+v", nodeRib)
+	}
+
+	var status errors.StatusError
+	get("/apis/v1/bgp/rib/node3", http.StatusNotFound, &status)
+	if !errors.IsNotFound(&status) {
+		t.Errorf("GET /apis/v1/bgp/rib/node3 = %+v, want not found", status)
+	}
+}
@@ -1,17 +1,41 @@
 package options
 
-import "github.com/spf13/pflag"
+import (
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/spf13/pflag"
+)
 
 type Options struct {
 	Port int
+	// port of the rib endpoint of the speakers
+	SpeakerRibPort int
+	// label selector of the speaker pods
+	SpeakerSelector string
+	// daemonset the speaker pods have to be owned by
+	SpeakerDaemonSet string
+	// ca verifying the certificates of the speakers, they are not verified if
+	// empty
+	SpeakerCAFile string
+	// service account of the apiserver, the tokens presented to the speakers
+	// are requested for it
+	ServiceAccount string
 }
 
 func NewOptions() *Options {
 	return &Options{
-		Port: 8080,
+		Port:             8080,
+		SpeakerRibPort:   50054,
+		SpeakerSelector:  "app=openelb,component=speaker",
+		SpeakerDaemonSet: constant.OpenELBSpeakerName,
+		ServiceAccount:   "openelb-apiserver",
 	}
 }
 
 func (options *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.IntVar(&options.Port, "http-port", options.Port, "The port that the http server serves at")
+	fs.IntVar(&options.SpeakerRibPort, "speaker-rib-port", options.SpeakerRibPort, "The port of the rib endpoint of the speakers")
+	fs.StringVar(&options.SpeakerSelector, "speaker-selector", options.SpeakerSelector, "The label selector of the speaker pods")
+	fs.StringVar(&options.SpeakerDaemonSet, "speaker-daemonset", options.SpeakerDaemonSet, "The daemonset owning the speaker pods")
+	fs.StringVar(&options.SpeakerCAFile, "speaker-ca-file", options.SpeakerCAFile, "The ca verifying the rib endpoint certificates of the speakers, issued for the name openelb-speaker. The certificates are not verified if empty")
+	fs.StringVar(&options.ServiceAccount, "service-account", options.ServiceAccount, "The service account of the apiserver, short-lived tokens for the speakers are requested for it")
 }
@@ -12,11 +12,16 @@ func SetupHTTPServer(stopCh <-chan struct{}, opts *options.Options) error {
 	bgpConfService := handler.NewBgpConfHandler(client.Client)
 	bgpPeerService := handler.NewBgpPeerHandler(client.Client)
 	eipService := handler.NewEipHandler(client.Client)
+	ribService, err := handler.NewRibHandler(client.Client, opts)
+	if err != nil {
+		return err
+	}
 
 	server := lib.NewHTTPServer([]lib.Router{
 		router.NewBgpConfRouter(bgpConfService),
 		router.NewBgpPeerRouter(bgpPeerService),
 		router.NewEipRouter(eipService),
+		router.NewRibRouter(ribService),
 	}, *opts)
 	return server.ListenAndServe(stopCh)
 }
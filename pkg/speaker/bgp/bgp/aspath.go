@@ -0,0 +1,116 @@
+package bgp
+
+import (
+	"sort"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	api "github.com/osrg/gobgp/api"
+	"k8s.io/klog/v2"
+)
+
+const asPathPolicyPrefix = "openelb-aspath-"
+
+// isExternalPeer reports whether the peer is in another AS than the speaker.
+func isExternalPeer(peer *bgpapi.BgpPeer, as uint32) bool {
+	local := as
+	if peer.Spec.Conf.LocalAs != 0 {
+		local = peer.Spec.Conf.LocalAs
+	}
+	return peer.Spec.Conf.PeerAs != local
+}
+
+// asPathStatements returns the export policy statements prepending the AS to
+// the paths with a weighted node as next hop. gobgp matches the next hop of
+// the path before the next hop policies rewrite it.
+func asPathStatements(name string, as uint32, attrs map[string]pathAttrs) []*api.Statement {
+	var nexthops []string
+	for nexthop, a := range attrs {
+		if a.prepend > 0 {
+			nexthops = append(nexthops, nexthop)
+		}
+	}
+	sort.Strings(nexthops)
+
+	var statements []*api.Statement
+	for _, nexthop := range nexthops {
+		statements = append(statements, &api.Statement{
+			Name: name + "-" + nexthop,
+			Conditions: &api.Conditions{
+				NeighborSet: &api.MatchSet{
+					MatchType: api.MatchType_ANY,
+					Name:      name,
+				},
+				NextHopInList: []string{nexthop},
+			},
+			Actions: &api.Actions{
+				AsPrepend: &api.AsPrependAction{Asn: as, Repeat: attrs[nexthop].prepend},
+			},
+		})
+	}
+	return statements
+}
+
+// updateAsPathPolicy installs the global export policy that prepends the AS
+// to the paths sent to the peer, or removes it if the peer is deleted. An
+// iBGP peer drops paths with its own AS, so only eBGP peers get one.
+func (b *Bgp) updateAsPathPolicy(peer *bgpapi.BgpPeer, delete bool) error {
+	b.asPathLock.Lock()
+	defer b.asPathLock.Unlock()
+
+	return b.syncAsPathPolicy(peer, delete)
+}
+
+func (b *Bgp) syncAsPathPolicy(peer *bgpapi.BgpPeer, remove bool) error {
+	name, neighbors := peerNeighbors(asPathPolicyPrefix, peer)
+
+	b.lock.Lock()
+	as := b.conf.As
+	// policies cannot match the zoned link-local address of interface peers
+	external := !remove && !peer.IsUnnumbered() && isExternalPeer(peer, as)
+	if external {
+		b.asPathPeers[name] = peer
+	} else {
+		delete(b.asPathPeers, name)
+	}
+	var statements []*api.Statement
+	if external {
+		statements = asPathStatements(name, as, b.pathAttrs)
+	}
+	b.lock.Unlock()
+
+	existed := b.deletePeerPolicy(name)
+	if len(statements) == 0 {
+		if existed {
+			b.softResetPeers(peer)
+		}
+		return nil
+	}
+
+	if err := b.addPeerPolicy(name, neighbors, statements); err != nil {
+		return err
+	}
+
+	b.softResetPeers(peer)
+	return nil
+}
+
+// updateAsPathPolicies reinstalls the as path policies of the eBGP peers
+// after the prepends of the next hops changed.
+func (b *Bgp) updateAsPathPolicies() {
+	b.asPathLock.Lock()
+	defer b.asPathLock.Unlock()
+
+	b.lock.RLock()
+	peers := make([]*bgpapi.BgpPeer, 0, len(b.asPathPeers))
+	for _, peer := range b.asPathPeers {
+		peers = append(peers, peer)
+	}
+	b.lock.RUnlock()
+
+	for _, peer := range peers {
+		if err := b.syncAsPathPolicy(peer, false); err != nil {
+			name, _ := peerNeighbors(asPathPolicyPrefix, peer)
+			klog.Errorf("failed to update bgp policy %s: %v", name, err)
+		}
+	}
+}
@@ -1,13 +1,27 @@
 package bgp
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	"github.com/golang/protobuf/ptypes"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/speaker"
+	"github.com/openelb/openelb/pkg/speaker/bgp/bgp/table"
+	"github.com/openelb/openelb/pkg/util"
+	"github.com/openelb/openelb/pkg/util/iprange"
+	api "github.com/osrg/gobgp/api"
+	"github.com/osrg/gobgp/pkg/server"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 )
 
 var (
@@ -138,7 +152,7 @@ var _ = Describe("BGP test", func() {
 				nexthops := []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}
 
 				By("Init bgp should be empty")
-				err, toAdd, toDelete := b.retriveRoutes(ip, 32, nexthops)
+				err, toAdd, toDelete := b.retriveRoutes(pathTable{}, ip, 32, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(len(toAdd)).Should(Equal(3))
 				Expect(len(toDelete)).Should(Equal(0))
@@ -146,7 +160,7 @@ var _ = Describe("BGP test", func() {
 				By("Add nexthops to bgp")
 				err = b.setBalancer(ip, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
-				err, toAdd, toDelete = b.retriveRoutes(ip, 32, nexthops)
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, ip, 32, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(len(toAdd)).Should(Equal(0))
 				Expect(len(toDelete)).Should(Equal(0))
@@ -156,7 +170,7 @@ var _ = Describe("BGP test", func() {
 				Expect(len(nexthops)).Should(Equal(4))
 				err = b.setBalancer(ip, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
-				err, toAdd, toDelete = b.retriveRoutes(ip, 32, nexthops)
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, ip, 32, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(len(toAdd)).Should(Equal(0))
 				Expect(len(toDelete)).Should(Equal(0))
@@ -166,18 +180,627 @@ var _ = Describe("BGP test", func() {
 				Expect(len(nexthops)).Should(Equal(2))
 				err = b.setBalancer(ip, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
-				err, toAdd, toDelete = b.retriveRoutes(ip, 32, nexthops)
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, ip, 32, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(len(toAdd)).Should(Equal(0))
 				Expect(len(toDelete)).Should(Equal(0))
 
 				By("Delete all nexthops from bgp")
 				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
-				err, toAdd, toDelete = b.retriveRoutes(ip, 32, nexthops)
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, ip, 32, nexthops)
 				Expect(err).ShouldNot(HaveOccurred())
 				Expect(len(toAdd)).Should(Equal(2))
 				Expect(len(toDelete)).Should(Equal(0))
 			})
+
+			It("Should keep aggregated routes apart from host routes", func() {
+				aggregate := "100.100.0.0/16"
+				ip := "100.100.100.100"
+				nexthops := []string{"1.1.1.1", "2.2.2.2"}
+
+				By("Add aggregated route and host route")
+				Expect(b.setBalancer(aggregate, nexthops)).ShouldNot(HaveOccurred())
+				Expect(b.setBalancer(ip, nexthops[:1])).ShouldNot(HaveOccurred())
+				err, toAdd, toDelete := b.retriveRoutes(pathTable{}, "100.100.0.0", 16, nexthops)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(toAdd)).Should(Equal(0))
+				Expect(len(toDelete)).Should(Equal(0))
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, ip, 32, nexthops[:1])
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(toAdd)).Should(Equal(0))
+				Expect(len(toDelete)).Should(Equal(0))
+
+				By("Delete host route should keep aggregated route")
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, "100.100.0.0", 16, nexthops)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(toAdd)).Should(Equal(0))
+				Expect(len(toDelete)).Should(Equal(0))
+
+				By("Delete aggregated route")
+				Expect(b.DelBalancer(aggregate)).ShouldNot(HaveOccurred())
+				err, toAdd, toDelete = b.retriveRoutes(pathTable{}, "100.100.0.0", 16, nexthops)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(len(toAdd)).Should(Equal(2))
+				Expect(len(toDelete)).Should(Equal(0))
+			})
+		})
+
+		Context("Next Hop", func() {
+			It("Should select the next hop of the path family", func() {
+				node := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+					Status: corev1.NodeStatus{
+						Addresses: []corev1.NodeAddress{
+							{Type: corev1.NodeInternalIP, Address: "fd00::1"},
+							{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+						},
+					},
+				}
+
+				nexthop, err := getNodeNextHop(node, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(nexthop).Should(Equal("10.0.0.1"))
+				nexthop, err = getNodeNextHop(node, false)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(nexthop).Should(Equal("fd00::1"))
+
+				By("Annotations override the node addresses")
+				node.Annotations = map[string]string{
+					constant.OpenELBNodeNextHopV4: "172.16.0.1",
+					constant.OpenELBNodeNextHopV6: "10.0.0.2",
+				}
+				nexthop, err = getNodeNextHop(node, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(nexthop).Should(Equal("172.16.0.1"))
+				nexthop, err = getNodeNextHop(node, false)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(nexthop).Should(Equal("fd00::1"))
+
+				By("IPv4 paths fall back to an IPv6 next hop")
+				node.Annotations = nil
+				node.Status.Addresses = node.Status.Addresses[:1]
+				nexthop, err = getNodeNextHop(node, true)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(nexthop).Should(Equal("fd00::1"))
+
+				By("IPv6 paths never use an IPv4 next hop")
+				node.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}}
+				_, err = getNodeNextHop(node, false)
+				Expect(err).Should(HaveOccurred())
+			})
+
+			It("Should add/delete routes with IPv6 next hops", func() {
+				for _, item := range []struct {
+					ip     string
+					prefix uint32
+				}{{"2001:db8::100", 128}, {"100.100.100.101", 32}} {
+					nexthops := []string{"fd00::1", "fd00::2"}
+					Expect(b.setBalancer(item.ip, nexthops)).ShouldNot(HaveOccurred())
+					err, toAdd, toDelete := b.retriveRoutes(pathTable{}, item.ip, item.prefix, nexthops)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(len(toAdd)).Should(Equal(0))
+					Expect(len(toDelete)).Should(Equal(0))
+
+					Expect(b.DelBalancer(item.ip)).ShouldNot(HaveOccurred())
+					err, toAdd, _ = b.retriveRoutes(pathTable{}, item.ip, item.prefix, nexthops)
+					Expect(err).ShouldNot(HaveOccurred())
+					Expect(len(toAdd)).Should(Equal(2))
+				}
+			})
+
+			It("Should install the next hop policy of the peer", func() {
+				peer := &bgpapi.BgpPeer{
+					Spec: bgpapi.BgpPeerSpec{
+						Conf: &bgpapi.PeerConf{
+							PeerAs:          65001,
+							NeighborAddress: "192.168.0.4",
+						},
+						NextHopSelf: true,
+					},
+				}
+				policies := func() []string {
+					var names []string
+					Expect(b.bgpServer.ListPolicyAssignment(context.Background(), &api.ListPolicyAssignmentRequest{
+						Name:      table.GLOBAL_RIB_NAME,
+						Direction: api.PolicyDirection_EXPORT,
+					}, func(a *api.PolicyAssignment) {
+						for _, p := range a.Policies {
+							names = append(names, p.Name)
+						}
+					})).ShouldNot(HaveOccurred())
+					return names
+				}
+
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(policies()).Should(ContainElement(nextHopPolicyPrefix + "192.168.0.4"))
+
+				By("Updating the peer should not duplicate the policy")
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(policies()).Should(ContainElement(nextHopPolicyPrefix + "192.168.0.4"))
+
+				peer.Spec.NextHopSelf = false
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(policies()).ShouldNot(ContainElement(nextHopPolicyPrefix + "192.168.0.4"))
+
+				peer.Spec.NextHopSelf = true
+				peer.Spec.NextHopInterface = "lo"
+				Expect(b.HandleBgpPeer(peer, false)).Should(HaveOccurred())
+
+				peer.Spec.NextHopSelf = false
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(policies()).Should(ContainElement(nextHopPolicyPrefix + "192.168.0.4"))
+
+				Expect(b.HandleBgpPeer(peer, true)).ShouldNot(HaveOccurred())
+				Expect(policies()).ShouldNot(ContainElement(nextHopPolicyPrefix + "192.168.0.4"))
+			})
+		})
+
+		Context("Path Attributes", func() {
+			It("Should weight the paths of the nodes", func() {
+				ip := "100.100.100.102"
+				node := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{
+						Name: "node1",
+						Annotations: map[string]string{
+							constant.OpenELBNodeAsPathPrepend: "2",
+							constant.OpenELBNodeLinkBandwidth: "8M",
+						},
+					},
+					Status: corev1.NodeStatus{
+						Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+					},
+				}
+				attrs := func() []pathAttrs {
+					var result []pathAttrs
+					Expect(b.bgpServer.ListPath(context.Background(), &api.ListPathRequest{
+						TableType: api.TableType_GLOBAL,
+						Family:    getFamily(ip),
+						Prefixes:  []*api.TableLookupPrefix{{Prefix: ip + "/32"}},
+					}, func(d *api.Destination) {
+						for _, path := range d.Paths {
+							result = append(result, pathAttrsFromAPIPath(path))
+						}
+					})).ShouldNot(HaveOccurred())
+					return result
+				}
+
+				prepends := func(address string) map[string]uint32 {
+					result := make(map[string]uint32)
+					Expect(b.bgpServer.ListPolicy(context.Background(), &api.ListPolicyRequest{
+						Name: asPathPolicyPrefix + address,
+					}, func(p *api.Policy) {
+						for _, s := range p.Statements {
+							Expect(s.Actions.AsPrepend.Asn).Should(Equal(uint32(65003)))
+							result[s.Conditions.NextHopInList[0]] = s.Actions.AsPrepend.Repeat
+						}
+					})).ShouldNot(HaveOccurred())
+					return result
+				}
+
+				ibgp := &bgpapi.BgpPeer{
+					Spec: bgpapi.BgpPeerSpec{
+						Conf: &bgpapi.PeerConf{
+							PeerAs:          65003,
+							NeighborAddress: "192.168.0.5",
+						},
+					},
+				}
+				Expect(b.HandleBgpPeer(ibgp, false)).ShouldNot(HaveOccurred())
+
+				Expect(b.SetBalancer(ip, []corev1.Node{node})).ShouldNot(HaveOccurred())
+				Expect(attrs()).Should(Equal([]pathAttrs{{as: 65003, bandwidth: 1e6}}))
+				Expect(prepends("192.168.0.2")).Should(Equal(map[string]uint32{"10.0.0.1/32": 2}))
+
+				By("The AS should not be prepended towards iBGP peers")
+				Expect(prepends("192.168.0.5")).Should(BeEmpty())
+
+				By("Changed annotations should replace the path")
+				node.Annotations[constant.OpenELBNodeAsPathPrepend] = "1"
+				delete(node.Annotations, constant.OpenELBNodeLinkBandwidth)
+				Expect(b.SetBalancer(ip, []corev1.Node{node})).ShouldNot(HaveOccurred())
+				Expect(attrs()).Should(Equal([]pathAttrs{{}}))
+				Expect(prepends("192.168.0.2")).Should(Equal(map[string]uint32{"10.0.0.1/32": 1}))
+
+				By("Withdrawn next hops should be dropped")
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				Expect(attrs()).Should(BeEmpty())
+				Expect(prepends("192.168.0.2")).Should(BeEmpty())
+				Expect(b.pathAttrs).ShouldNot(HaveKey("10.0.0.1"))
+
+				Expect(b.HandleBgpPeer(ibgp, true)).ShouldNot(HaveOccurred())
+			})
+		})
+
+		Context("RIB", func() {
+			It("Should report the paths of the balancers", func() {
+				ip := "100.100.100.104"
+				node := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+					Status: corev1.NodeStatus{
+						Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+					},
+				}
+
+				Expect(b.SetBalancer(ip, []corev1.Node{node})).ShouldNot(HaveOccurred())
+				rib, err := b.GetRib(context.Background())
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(rib.Global).Should(ContainElement(RibRoute{Prefix: ip + "/32", NextHop: "10.0.0.1", Best: true}))
+				Expect(rib.AdjRibOut).ShouldNot(BeEmpty())
+
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				rib, err = b.GetRib(context.Background())
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(rib.Global).ShouldNot(ContainElement(HaveField("Prefix", ip+"/32")))
+			})
+		})
+
+		Context("Dynamic Neighbors", func() {
+			It("Should accept sessions from the dynamic neighbors", func() {
+				peer := &bgpapi.BgpPeer{
+					ObjectMeta: metav1.ObjectMeta{Name: "tor"},
+					Spec: bgpapi.BgpPeerSpec{
+						Conf: &bgpapi.PeerConf{
+							PeerAs: 65010,
+						},
+						DynamicNeighbors: []string{"127.0.0.0/24"},
+					},
+				}
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+
+				remote := server.NewBgpServer()
+				go remote.Serve()
+				defer remote.StopBgp(context.Background(), &api.StopBgpRequest{})
+				Expect(remote.StartBgp(context.Background(), &api.StartBgpRequest{
+					Global: &api.Global{As: 65010, RouterId: "10.0.255.1", ListenPort: -1},
+				})).ShouldNot(HaveOccurred())
+				Expect(remote.AddPeer(context.Background(), &api.AddPeerRequest{
+					Peer: &api.Peer{
+						Conf: &api.PeerConf{NeighborAddress: "127.0.0.1", PeerAs: 65003},
+						Transport: &api.Transport{
+							LocalAddress: "127.0.0.2",
+							RemotePort:   17900,
+						},
+					},
+				})).ShouldNot(HaveOccurred())
+
+				sessions := func() []bgpapi.NodePeerStatus {
+					for _, status := range b.HandleBgpPeerStatus([]bgpapi.BgpPeer{*peer}) {
+						for _, sessions := range status.Status.NodesDynamicPeerStatus {
+							return sessions
+						}
+					}
+					return nil
+				}
+				Eventually(sessions, "30s").Should(ContainElement(HaveField("PeerState.SessionState", "ESTABLISHED")))
+				Expect(sessions()[0].PeerState.NeighborAddress).Should(Equal("127.0.0.2"))
+
+				// the session is kept while the prefix is, and closed once it is dropped
+				peer.Spec.DynamicNeighbors = []string{"127.0.0.0/24", "127.0.1.0/24"}
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(sessions()).Should(HaveLen(1))
+
+				peer.Spec.DynamicNeighbors = []string{"127.0.1.0/24"}
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Expect(sessions()).Should(BeEmpty())
+
+				Expect(b.HandleBgpPeer(peer, true)).ShouldNot(HaveOccurred())
+				Expect(b.groupPeers("tor")).Should(BeEmpty())
+			})
+		})
+
+		Context("Unnumbered Peers", func() {
+			It("Should peer over the resolved neighbor interface", func() {
+				peer := &bgpapi.BgpPeer{
+					ObjectMeta: metav1.ObjectMeta{Name: "unnumbered"},
+					Spec: bgpapi.BgpPeerSpec{
+						Conf: &bgpapi.PeerConf{
+							PeerAs:            65010,
+							NeighborInterface: "lo",
+						},
+					},
+				}
+				request, err := goBgpPeer(peer.DeepCopy())
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(b.resolveNeighborInterface(request)).ShouldNot(HaveOccurred())
+				Expect(request.Conf.NeighborInterface).Should(Equal("lo"))
+				Expect(request.Conf.NeighborAddress).Should(BeEmpty())
+				Expect(request.Conf.PeerAs).Should(Equal(uint32(65010)))
+				Expect(request.State).Should(BeNil())
+
+				var families []*api.Family
+				for _, afiSafi := range request.AfiSafis {
+					Expect(afiSafi.Config.Enabled).Should(BeTrue())
+					Expect(afiSafi.AddPaths.Config.SendMax).Should(Equal(uint32(10)))
+					families = append(families, afiSafi.Config.Family)
+				}
+				Expect(families).Should(Equal([]*api.Family{
+					{Afi: api.Family_AFI_IP, Safi: api.Family_SAFI_UNICAST},
+					{Afi: api.Family_AFI_IP6, Safi: api.Family_SAFI_UNICAST},
+				}))
+
+				peer.Spec.Conf.NeighborInterface = "openelb-none"
+				Expect(b.HandleBgpPeer(peer.DeepCopy(), false)).Should(MatchError(ContainSubstring("openelb-none")))
+				Expect(b.HandleBgpPeer(peer.DeepCopy(), true)).ShouldNot(HaveOccurred())
+
+				peer.Spec.NextHopSelf = true
+				_, err = nextHopStatements("unnumbered", peer)
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		Context("VRF", func() {
+			It("Should advertise the eip paths in the vrf", func() {
+				vrf := &bgpapi.BgpVrf{
+					ObjectMeta: metav1.ObjectMeta{Name: "tenant"},
+					Spec: bgpapi.BgpVrfSpec{
+						Rd:        "65000:100",
+						ImportRts: []string{"65000:100"},
+						ExportRts: []string{"65000:100"},
+					},
+				}
+				r, err := iprange.ParseRange("172.22.0.0/24")
+				Expect(err).ShouldNot(HaveOccurred())
+				config := speaker.Config{Name: "eip-vrf", IPRange: r, Vrf: vrf.Name}
+				Expect(b.ConfigureWithEIP(config, false)).ShouldNot(HaveOccurred())
+				defer b.ConfigureWithEIP(config, true)
+
+				ip := "172.22.0.10"
+				node1 := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+					Status: corev1.NodeStatus{
+						Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+					},
+				}
+				Expect(b.SetBalancer(ip, []corev1.Node{node1})).Should(HaveOccurred())
+
+				Expect(b.HandleBgpVrf(vrf, false)).ShouldNot(HaveOccurred())
+				Expect(b.SetBalancer(ip, []corev1.Node{node1})).ShouldNot(HaveOccurred())
+
+				t, err := b.getPathTable(ip)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(t.String()).Should(Equal("vrf tenant"))
+				countPaths := func() int {
+					count := 0
+					Expect(b.listPaths(t, ip, 32, func(d *api.Destination) {
+						count += len(d.Paths)
+					})).ShouldNot(HaveOccurred())
+					return count
+				}
+				Expect(countPaths()).Should(Equal(1))
+
+				// the paths are kept when the vrf is recreated
+				vrf.Spec.ExportRts = []string{"65000:200"}
+				Expect(b.HandleBgpVrf(vrf, false)).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(1))
+
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(0))
+
+				Expect(b.HandleBgpVrf(vrf, true)).ShouldNot(HaveOccurred())
+				_, err = b.getPathTable(ip)
+				Expect(err).Should(HaveOccurred())
+			})
 		})
+
+		Context("Drain", func() {
+			It("Should withdraw the paths while draining", func() {
+				ip := "100.100.100.103"
+				node := corev1.Node{
+					ObjectMeta: metav1.ObjectMeta{Name: "node1"},
+					Status: corev1.NodeStatus{
+						Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.1"}},
+					},
+				}
+				countPaths := func() int {
+					count := 0
+					Expect(b.listPaths(pathTable{}, ip, 32, func(d *api.Destination) {
+						count += len(d.Paths)
+					})).ShouldNot(HaveOccurred())
+					return count
+				}
+
+				Expect(b.NodeDraining(&node)).Should(BeFalse())
+				node.Spec.Unschedulable = true
+				Expect(b.NodeDraining(&node)).Should(BeFalse())
+				node.Annotations = map[string]string{constant.OpenELBNodeDrain: "true"}
+				Expect(b.NodeDraining(&node)).Should(BeTrue())
+
+				Expect(b.SetBalancer(ip, []corev1.Node{node})).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(1))
+
+				Expect(b.Drain(true)).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(0))
+
+				By("Balancers set while draining are advertised once the drain ends")
+				node2 := *node.DeepCopy()
+				node2.Name = "node2"
+				node2.Status.Addresses = []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}}
+				Expect(b.SetBalancer(ip, []corev1.Node{node, node2})).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(0))
+
+				Expect(b.Drain(false)).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(2))
+
+				Expect(b.DelBalancer(ip)).ShouldNot(HaveOccurred())
+				Expect(countPaths()).Should(Equal(0))
+				Expect(b.balancers).ShouldNot(HaveKey(ip))
+			})
+		})
+
+		Context("Node Mesh", func() {
+			It("Should peer route reflectors with their clients", func() {
+				newNode := func(name, ip string, reflector bool) corev1.Node {
+					node := corev1.Node{
+						ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"mesh": "true"}},
+						Status: corev1.NodeStatus{
+							Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: ip}},
+						},
+					}
+					if reflector {
+						node.Labels["reflector"] = "true"
+					}
+					return node
+				}
+				rr1 := newNode("rr1", "10.0.1.1", true)
+				rr2 := newNode("rr2", "10.0.1.2", true)
+				client1 := newNode("client1", "10.0.1.3", false)
+				other := newNode("other", "10.0.1.4", false)
+				delete(other.Labels, "mesh")
+				nodes := []corev1.Node{rr1, rr2, client1, other}
+
+				conf := bgpapi.BgpConfSpec{As: 65003, ListenPort: 17900, NodeMesh: &bgpapi.NodeMesh{
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"mesh": "true"}},
+				}}
+				peers, err := meshPeers(conf, &client1, nodes)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(peers).Should(HaveLen(2))
+
+				conf.NodeMesh.RouteReflectorSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"reflector": "true"}}
+				peers, err = meshPeers(conf, &client1, nodes)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(peers).Should(HaveLen(2))
+				Expect(peers["10.0.1.1"].Spec.RouteReflector).Should(BeNil())
+				Expect(peers["10.0.1.1"].Spec.Transport.RemotePort).Should(Equal(uint32(17900)))
+
+				peers, err = meshPeers(conf, &rr1, nodes)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(peers).Should(HaveLen(2))
+				Expect(peers["10.0.1.2"].Spec.RouteReflector).Should(BeNil())
+				Expect(peers["10.0.1.3"].Spec.RouteReflector.RouteReflectorClient).Should(BeTrue())
+
+				peers, err = meshPeers(conf, &other, nodes)
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(peers).Should(BeEmpty())
+
+				By("The mesh peers are kept by the status sync and removed with the nodes")
+				b.lock.Lock()
+				old := b.conf
+				b.conf.NodeMesh = conf.NodeMesh
+				b.lock.Unlock()
+				defer func() {
+					b.lock.Lock()
+					b.conf = old
+					b.lock.Unlock()
+				}()
+
+				Expect(b.HandleNodeMesh(&rr1, nodes)).ShouldNot(HaveOccurred())
+				listPeers := func() map[string]*api.Peer {
+					result := make(map[string]*api.Peer)
+					Expect(b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{}, func(p *api.Peer) {
+						result[p.Conf.NeighborAddress] = p
+					})).ShouldNot(HaveOccurred())
+					return result
+				}
+				Expect(listPeers()).Should(HaveKey("10.0.1.3"))
+				Expect(listPeers()["10.0.1.3"].RouteReflector.RouteReflectorClient).Should(BeTrue())
+
+				b.HandleBgpPeerStatus(nil)
+				Expect(listPeers()).Should(And(HaveKey("10.0.1.2"), HaveKey("10.0.1.3")))
+
+				Expect(b.HandleNodeMesh(&rr1, []corev1.Node{rr1, rr2})).ShouldNot(HaveOccurred())
+				Expect(listPeers()).ShouldNot(HaveKey("10.0.1.3"))
+
+				Expect(b.HandleNodeMesh(&other, nodes)).ShouldNot(HaveOccurred())
+				Expect(listPeers()).ShouldNot(HaveKey("10.0.1.2"))
+				Expect(b.meshPeers).Should(BeEmpty())
+			})
+		})
+
+		Context("Monitor BgpPeer", func() {
+			It("Should notify when peer changed", func() {
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+
+				addresses := make(chan string, 16)
+				done := make(chan error, 1)
+				go func() {
+					done <- b.MonitorPeers(ctx, func(address string) {
+						addresses <- address
+					})
+				}()
+				Eventually(func() bool {
+					b.lock.RLock()
+					defer b.lock.RUnlock()
+					return b.peerNotify != nil
+				}).Should(BeTrue())
+
+				peer := &bgpapi.BgpPeer{
+					Spec: bgpapi.BgpPeerSpec{
+						Conf: &bgpapi.PeerConf{
+							PeerAs:          65001,
+							NeighborAddress: "192.168.0.3",
+						},
+					},
+				}
+				Expect(b.HandleBgpPeer(peer, false)).ShouldNot(HaveOccurred())
+				Eventually(addresses).Should(Receive(Equal("192.168.0.3")))
+
+				Expect(b.HandleBgpPeer(peer, true)).ShouldNot(HaveOccurred())
+				Eventually(addresses).Should(Receive(Equal("192.168.0.3")))
+
+				By("The monitor should block until the context is done")
+				Consistently(done).ShouldNot(Receive())
+				cancel()
+				Eventually(done).Should(Receive(Equal(context.Canceled)))
+			})
+		})
+	})
+})
+
+// peerMetric returns the value of the metric of the peer, or -1 if it is not
+// reported.
+func peerMetric(name, peerIP string) float64 {
+	families, err := ctrlmetrics.Registry.Gather()
+	Expect(err).ShouldNot(HaveOccurred())
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "peerIP" && l.GetValue() == peerIP {
+					return m.GetGauge().GetValue()
+				}
+			}
+		}
+	}
+	return -1
+}
+
+var _ = Describe("BGP peer metrics", func() {
+	It("Should map the peer state to the metrics", func() {
+		uptime, err := ptypes.TimestampProto(time.Now().Add(-time.Minute))
+		Expect(err).ShouldNot(HaveOccurred())
+		peer := &api.Peer{
+			State: &api.PeerState{
+				NeighborAddress: "192.168.10.1",
+				SessionState:    api.PeerState_ESTABLISHED,
+				Messages: &api.Messages{
+					Received: &api.Message{Update: 3},
+					Sent:     &api.Message{Update: 7, WithdrawPrefix: 2},
+				},
+			},
+			Timers:   &api.Timers{State: &api.TimersState{Uptime: uptime}},
+			AfiSafis: []*api.AfiSafi{{State: &api.AfiSafiState{Advertised: 4}}},
+		}
+
+		metrics.InitBGPPeerMetrics("192.168.10.1", util.GetNodeName())
+		setPeerMetrics(peer)
+		Expect(peerMetric("session_state", "192.168.10.1")).Should(Equal(float64(5)))
+		Expect(peerMetric("session_uptime_seconds", "192.168.10.1")).Should(BeNumerically("~", 60, 5))
+		Expect(peerMetric("updates_total", "192.168.10.1")).Should(Equal(float64(3)))
+		Expect(peerMetric("updates_sent_total", "192.168.10.1")).Should(Equal(float64(7)))
+		Expect(peerMetric("withdrawn_prefixes_total", "192.168.10.1")).Should(Equal(float64(2)))
+		Expect(peerMetric("announced_prefixes_total", "192.168.10.1")).Should(Equal(float64(4)))
+		Expect(peerMetric("pending_prefixes_total", "192.168.10.1")).Should(Equal(float64(0)))
+
+		By("The uptime of a session that is not established is 0")
+		peer.State.SessionState = api.PeerState_IDLE
+		setPeerMetrics(peer)
+		Expect(peerMetric("session_state", "192.168.10.1")).Should(Equal(float64(0)))
+		Expect(peerMetric("session_uptime_seconds", "192.168.10.1")).Should(Equal(float64(0)))
 	})
 })
@@ -10,6 +10,17 @@ import (
 
 func (b *Bgp) HandleBgpGlobalConfig(global *bgpapi.BgpConf, rack string, delete bool, cm *corev1.ConfigMap) error {
 	b.rack = rack
+	b.lock.Lock()
+	b.conf = global.Spec
+	// the vrfs are dropped with the global configuration
+	b.vrfs = make(map[string]string)
+	// and so are the peers of the node mesh
+	b.meshPeers = make(map[string]*bgpapi.BgpPeer)
+	// and so are the policies of the peers
+	b.asPathPeers = make(map[string]*bgpapi.BgpPeer)
+	b.lock.Unlock()
+	// Restarting or stopping gobgp drops every configured peer.
+	defer b.notifyPeer("")
 
 	if delete {
 		return b.bgpServer.StopBgp(context.Background(), nil)
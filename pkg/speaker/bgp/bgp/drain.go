@@ -0,0 +1,69 @@
+package bgp
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+// NodeDraining reports whether the paths should be withdrawn while the node
+// stays up, because it is annotated or, if enabled, cordoned.
+func (b *Bgp) NodeDraining(node *corev1.Node) bool {
+	if value, ok := node.Annotations[constant.OpenELBNodeDrain]; ok {
+		drain, err := strconv.ParseBool(value)
+		if err != nil {
+			klog.Warningf("node %s has invalid %s annotation %q", node.Name, constant.OpenELBNodeDrain, value)
+		}
+		return drain
+	}
+
+	return b.drainOnCordon && node.Spec.Unschedulable
+}
+
+// Drain withdraws the paths of all balancers, so that upstream routers move
+// the traffic away before the sessions are closed, or advertises them again
+// once the drain ends. The balancers set while draining are only recorded.
+func (b *Bgp) Drain(drain bool) error {
+	b.drainLock.Lock()
+	defer b.drainLock.Unlock()
+
+	if b.draining != drain {
+		klog.Infof("bgp draining:%v balancers:%d", drain, len(b.balancers))
+	}
+	b.draining = drain
+
+	var errs []error
+	for ip, nodes := range b.balancers {
+		var err error
+		if drain {
+			err = b.delBalancer(ip)
+		} else {
+			err = b.setNodeBalancer(ip, nodes)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", ip, err))
+		}
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// shutdown withdraws the paths and waits the drain period before the
+// sessions are closed, instead of leaving upstream routers to blackhole the
+// traffic until their hold timers expire.
+func (b *Bgp) shutdown() {
+	if b.drainPeriod <= 0 || b.ready() != nil {
+		return
+	}
+
+	if err := b.Drain(true); err != nil {
+		klog.Warningf("failed to withdraw bgp paths: %v", err)
+	}
+	klog.Infof("bgp paths withdrawn, closing sessions in %s", b.drainPeriod)
+	time.Sleep(b.drainPeriod)
+}
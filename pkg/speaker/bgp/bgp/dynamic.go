@@ -0,0 +1,144 @@
+package bgp
+
+import (
+	"fmt"
+	"net"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/util"
+	api "github.com/osrg/gobgp/api"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// dynamicNeighborFamily returns the address family of the first dynamic
+// neighbor prefix, used when the BgpPeer has no afiSafis configured.
+func dynamicNeighborFamily(peer *bgpapi.BgpPeer) (net.IP, error) {
+	for _, prefix := range peer.Spec.DynamicNeighbors {
+		ip, _, err := net.ParseCIDR(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("field Spec.DynamicNeighbors invalid: %v", err)
+		}
+		return ip, nil
+	}
+	return nil, fmt.Errorf("field Spec.DynamicNeighbors is empty")
+}
+
+// toPeerGroup converts the peer into the peer group the dynamic neighbors are
+// created from.
+func toPeerGroup(name string, peer *api.Peer) *api.PeerGroup {
+	group := &api.PeerGroup{
+		ApplyPolicy:     peer.ApplyPolicy,
+		EbgpMultihop:    peer.EbgpMultihop,
+		RouteReflector:  peer.RouteReflector,
+		Timers:          peer.Timers,
+		Transport:       peer.Transport,
+		RouteServer:     peer.RouteServer,
+		GracefulRestart: peer.GracefulRestart,
+		AfiSafis:        peer.AfiSafis,
+		Conf:            &api.PeerGroupConf{PeerGroupName: name},
+	}
+	if conf := peer.Conf; conf != nil {
+		group.Conf.AuthPassword = conf.AuthPassword
+		group.Conf.Description = conf.Description
+		group.Conf.LocalAs = conf.LocalAs
+		group.Conf.PeerAs = conf.PeerAs
+		group.Conf.PeerType = conf.PeerType
+		group.Conf.RemovePrivateAs = api.PeerGroupConf_RemovePrivateAs(conf.RemovePrivateAs)
+		group.Conf.RouteFlapDamping = conf.RouteFlapDamping
+		group.Conf.SendCommunity = conf.SendCommunity
+	}
+	return group
+}
+
+// handleDynamicNeighbors adds, updates or deletes the peer group of the
+// BgpPeer and the prefixes its neighbors are accepted from. gobgp cannot
+// remove a single prefix from a peer group, so the group and its sessions
+// are recreated when a prefix is dropped.
+func (b *Bgp) handleDynamicNeighbors(peer *bgpapi.BgpPeer, request *api.Peer, del bool) error {
+	name := peer.PeerGroupName()
+	prefixes := peer.Spec.DynamicNeighbors
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	old, exist := b.dynamicNeighbors[name]
+	if exist && (del || !containsAll(prefixes, old)) {
+		b.deletePeerGroup(name)
+		delete(b.dynamicNeighbors, name)
+	}
+	if del {
+		return nil
+	}
+
+	ctx := context.Background()
+	group := toPeerGroup(name, request)
+	_, err := b.bgpServer.UpdatePeerGroup(ctx, &api.UpdatePeerGroupRequest{PeerGroup: group})
+	if err != nil {
+		err = b.bgpServer.AddPeerGroup(ctx, &api.AddPeerGroupRequest{PeerGroup: group})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, prefix := range prefixes {
+		err = b.bgpServer.AddDynamicNeighbor(ctx, &api.AddDynamicNeighborRequest{
+			DynamicNeighbor: &api.DynamicNeighbor{Prefix: prefix, PeerGroup: name},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	b.dynamicNeighbors[name] = append([]string(nil), prefixes...)
+
+	return nil
+}
+
+// deletePeerGroup closes the sessions of the dynamic neighbors in the peer
+// group, which gobgp requires before the group can be deleted.
+func (b *Bgp) deletePeerGroup(name string) {
+	ctx := context.Background()
+	for _, peer := range b.groupPeers(name) {
+		address := peer.State.NeighborAddress
+		klog.Infof("delete dynamic bgp peer %s of peer group %s", address, name)
+		err := b.bgpServer.DeletePeer(ctx, &api.DeletePeerRequest{Address: address})
+		if err != nil {
+			klog.Warningf("failed to delete dynamic bgp peer %s: %v", address, err)
+		}
+		metrics.DeleteBGPPeerMetrics(address, util.GetNodeName())
+	}
+
+	err := b.bgpServer.DeletePeerGroup(ctx, &api.DeletePeerGroupRequest{Name: name})
+	if err != nil {
+		klog.Warningf("failed to delete bgp peer group %s: %v", name, err)
+	}
+}
+
+// groupPeers returns the dynamic neighbors accepted into the peer group.
+func (b *Bgp) groupPeers(name string) []*api.Peer {
+	var peers []*api.Peer
+	err := b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{}, func(p *api.Peer) {
+		// dynamic neighbors only have their address in the state
+		if p.Conf != nil && p.Conf.PeerGroup == name && p.State != nil {
+			peers = append(peers, p)
+		}
+	})
+	if err != nil {
+		klog.Errorf("failed to list bgp peers of peer group %s: %v", name, err)
+	}
+	return peers
+}
+
+func containsAll(s, sub []string) bool {
+	set := make(map[string]bool, len(s))
+	for _, v := range s {
+		set[v] = true
+	}
+	for _, v := range sub {
+		if !set[v] {
+			return false
+		}
+	}
+	return true
+}
@@ -3,11 +3,13 @@ package bgp
 import (
 	"sync"
 
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/speaker"
 	api "github.com/osrg/gobgp/api"
 	"github.com/osrg/gobgp/pkg/server"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 )
 
@@ -20,7 +22,16 @@ func NewGoBgpd(bgpOptions *BgpOptions) *Bgp {
 	bgpServer := server.NewBgpServer(server.GrpcListenAddress(bgpOptions.GrpcHosts), server.GrpcOption(grpcOpts))
 
 	return &Bgp{
-		bgpServer: bgpServer,
+		bgpServer:        bgpServer,
+		pathAttrs:        make(map[string]pathAttrs),
+		asPathPeers:      make(map[string]*bgpapi.BgpPeer),
+		dynamicNeighbors: make(map[string][]string),
+		vrfs:             make(map[string]string),
+		eips:             make(map[string]speaker.Config),
+		meshPeers:        make(map[string]*bgpapi.BgpPeer),
+		drainPeriod:      bgpOptions.DrainPeriod,
+		drainOnCordon:    bgpOptions.DrainOnCordon,
+		balancers:        make(map[string][]corev1.Node),
 	}
 }
 
@@ -30,6 +41,7 @@ func (b *Bgp) Start(stopCh <-chan struct{}) error {
 
 	<-stopCh
 	klog.Info("gobgpd ending")
+	b.shutdown()
 	err := b.bgpServer.StopBgp(context.Background(), &api.StopBgpRequest{})
 	if err != nil {
 		klog.Errorf("failed to stop gobgpd: %v", err)
@@ -0,0 +1,130 @@
+package bgp
+
+import (
+	"fmt"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+)
+
+const meshPeerPrefix = "node-mesh-"
+
+// meshPeers returns the peers of the node in the node mesh, keyed by
+// neighbor address. Route reflectors peer with every node of the mesh and
+// reflect the paths of their clients, which only peer with the reflectors.
+func meshPeers(conf bgpapi.BgpConfSpec, self *corev1.Node, nodes []corev1.Node) (map[string]*bgpapi.BgpPeer, error) {
+	peers := make(map[string]*bgpapi.BgpPeer)
+	mesh := conf.NodeMesh
+	if mesh == nil {
+		return peers, nil
+	}
+
+	selected, err := mesh.NodeSelected(self)
+	if err != nil || !selected {
+		return peers, err
+	}
+	selfReflector, err := mesh.RouteReflector(self)
+	if err != nil {
+		return nil, err
+	}
+	fullMesh := mesh.RouteReflectorSelector == nil
+
+	for i := range nodes {
+		node := &nodes[i]
+		if node.Name == self.Name {
+			continue
+		}
+		if selected, _ := mesh.NodeSelected(node); !selected {
+			continue
+		}
+		reflector, _ := mesh.RouteReflector(node)
+		if !fullMesh && !selfReflector && !reflector {
+			continue
+		}
+		ip := util.GetNodeIP(*node)
+		if ip == nil {
+			klog.Warningf("node %s of the bgp node mesh has no internal ip", node.Name)
+			continue
+		}
+
+		peer := &bgpapi.BgpPeer{
+			ObjectMeta: metav1.ObjectMeta{Name: meshPeerPrefix + node.Name},
+			Spec: bgpapi.BgpPeerSpec{
+				Conf: &bgpapi.PeerConf{
+					NeighborAddress: ip.String(),
+					PeerAs:          conf.NodeAs(node),
+					Description:     fmt.Sprintf("node mesh peer %s", node.Name),
+				},
+			},
+		}
+		if conf.ListenPort > 0 {
+			peer.Spec.Transport = &bgpapi.Transport{RemotePort: uint32(conf.ListenPort)}
+		}
+		// gobgp only reflects the paths of iBGP clients
+		if selfReflector && !reflector && conf.NodeAs(self) == conf.NodeAs(node) {
+			peer.Spec.RouteReflector = &bgpapi.RouteReflector{
+				RouteReflectorClient:    true,
+				RouteReflectorClusterId: mesh.ClusterId,
+			}
+		}
+		peers[ip.String()] = peer
+	}
+
+	return peers, nil
+}
+
+// HandleNodeMesh peers the speaker with the other nodes of the node mesh,
+// and closes the sessions with the nodes that left it.
+func (b *Bgp) HandleNodeMesh(self *corev1.Node, nodes []corev1.Node) error {
+	b.lock.RLock()
+	conf := b.conf
+	b.lock.RUnlock()
+
+	peers, err := meshPeers(conf, self, nodes)
+	if err != nil {
+		return err
+	}
+
+	b.meshLock.Lock()
+	defer b.meshLock.Unlock()
+
+	var errs []error
+	for address, peer := range b.meshPeers {
+		if _, ok := peers[address]; ok {
+			continue
+		}
+		klog.Infof("delete bgp node mesh peer %s", address)
+		if err := b.HandleBgpPeer(peer, true); err != nil {
+			errs = append(errs, err)
+		}
+		b.lock.Lock()
+		delete(b.meshPeers, address)
+		b.lock.Unlock()
+	}
+
+	for address, peer := range peers {
+		if err := b.HandleBgpPeer(peer, false); err != nil {
+			errs = append(errs, fmt.Errorf("failed to add bgp node mesh peer %s: %v", address, err))
+			continue
+		}
+		b.lock.Lock()
+		b.meshPeers[address] = peer
+		b.lock.Unlock()
+	}
+
+	return utilerrors.NewAggregate(errs)
+}
+
+// isMeshPeer reports whether the neighbor is a peer of the node mesh, which
+// has no BgpPeer.
+func (b *Bgp) isMeshPeer(address string) bool {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	_, ok := b.meshPeers[address]
+	return ok
+}
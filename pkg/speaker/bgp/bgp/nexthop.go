@@ -0,0 +1,318 @@
+package bgp
+
+import (
+	"fmt"
+	"net"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/speaker"
+	"github.com/openelb/openelb/pkg/speaker/bgp/bgp/table"
+	api "github.com/osrg/gobgp/api"
+	"golang.org/x/net/context"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+const nextHopPolicyPrefix = "openelb-nexthop-"
+
+// getNodeNextHop returns the address of the node used as next hop for paths
+// of the given family. The per-node annotations take precedence over the node
+// InternalIP, and IPv4 paths fall back to an IPv6 next hop (RFC 5549) if the
+// node has no IPv4 address.
+func getNodeNextHop(node corev1.Node, v4 bool) (string, error) {
+	annotated := func(key string, v4 bool) net.IP {
+		value, ok := node.Annotations[key]
+		if !ok {
+			return nil
+		}
+		ip := net.ParseIP(value)
+		if ip == nil || (ip.To4() != nil) != v4 {
+			klog.Warningf("node %s has invalid %s annotation %q", node.Name, key, value)
+			return nil
+		}
+		return ip
+	}
+	internal := func(v4 bool) net.IP {
+		for _, addr := range node.Status.Addresses {
+			if addr.Type != corev1.NodeInternalIP {
+				continue
+			}
+			if ip := net.ParseIP(addr.Address); ip != nil && (ip.To4() != nil) == v4 {
+				return ip
+			}
+		}
+		return nil
+	}
+
+	candidates := []func() net.IP{
+		func() net.IP { return annotated(constant.OpenELBNodeNextHopV6, false) },
+		func() net.IP { return internal(false) },
+	}
+	if v4 {
+		candidates = append([]func() net.IP{
+			func() net.IP { return annotated(constant.OpenELBNodeNextHopV4, true) },
+			func() net.IP { return internal(true) },
+		}, candidates...)
+	}
+
+	for _, candidate := range candidates {
+		if ip := candidate(); ip != nil {
+			return ip.String(), nil
+		}
+	}
+
+	if v4 {
+		return "", fmt.Errorf("node %s has no ipv4 or ipv6 next hop", node.Name)
+	}
+	return "", fmt.Errorf("node %s has no ipv6 next hop", node.Name)
+}
+
+// interfaceNextHops returns the addresses of the local interface used as next
+// hop for the ipv4 and ipv6 families, an IPv6 address is used for both if the
+// interface has no IPv4 address.
+func interfaceNextHops(name string) (v4, v6 string, err error) {
+	iface, err := speaker.ParseInterface(name)
+	if err != nil {
+		return "", "", err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", "", err
+	}
+
+	for _, addr := range addrs {
+		ip, _, err := net.ParseCIDR(addr.String())
+		if err != nil || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip.To4() != nil && v4 == "" {
+			v4 = ip.String()
+		}
+		if ip.To4() == nil && v6 == "" {
+			v6 = ip.String()
+		}
+	}
+
+	if v4 == "" {
+		v4 = v6
+	}
+	if v4 == "" {
+		return "", "", fmt.Errorf("interface %s has no usable address", iface.Name)
+	}
+	return v4, v6, nil
+}
+
+// nextHopStatements returns the export policy statements rewriting the next
+// hop of paths sent to the peer, or nil if the peer gets the node next hops.
+func nextHopStatements(name string, peer *bgpapi.BgpPeer) ([]*api.Statement, error) {
+	spec := peer.Spec
+	if spec.NextHopSelf && spec.NextHopInterface != "" {
+		return nil, fmt.Errorf("nextHopSelf and nextHopInterface are mutually exclusive")
+	}
+	if (spec.NextHopSelf || spec.NextHopInterface != "") && peer.IsUnnumbered() {
+		// policies cannot match the zoned link-local address of the neighbor
+		return nil, fmt.Errorf("nextHopSelf and nextHopInterface are not supported for interface peers")
+	}
+
+	neighbor := &api.MatchSet{
+		MatchType: api.MatchType_ANY,
+		Name:      name,
+	}
+
+	if spec.NextHopSelf {
+		return []*api.Statement{{
+			Name:       name,
+			Conditions: &api.Conditions{NeighborSet: neighbor},
+			Actions:    &api.Actions{Nexthop: &api.NexthopAction{Self: true}},
+		}}, nil
+	}
+
+	if spec.NextHopInterface == "" {
+		return nil, nil
+	}
+
+	v4, v6, err := interfaceNextHops(spec.NextHopInterface)
+	if err != nil {
+		return nil, err
+	}
+
+	statements := []*api.Statement{{
+		Name: name + "-ipv4",
+		Conditions: &api.Conditions{
+			NeighborSet: neighbor,
+			AfiSafiIn:   []*api.Family{{Afi: api.Family_AFI_IP, Safi: api.Family_SAFI_UNICAST}},
+		},
+		Actions: &api.Actions{Nexthop: &api.NexthopAction{Address: v4}},
+	}}
+	if v6 != "" {
+		statements = append(statements, &api.Statement{
+			Name: name + "-ipv6",
+			Conditions: &api.Conditions{
+				NeighborSet: neighbor,
+				AfiSafiIn:   []*api.Family{{Afi: api.Family_AFI_IP6, Safi: api.Family_SAFI_UNICAST}},
+			},
+			Actions: &api.Actions{Nexthop: &api.NexthopAction{Address: v6}},
+		})
+	}
+	return statements, nil
+}
+
+// updateNextHopPolicy installs the global export policy that rewrites the next
+// hop for the peer in front of the policies configured by the user, or removes
+// it if the peer is deleted or keeps the node next hops.
+func (b *Bgp) updateNextHopPolicy(peer *bgpapi.BgpPeer, delete bool) error {
+	name, neighbors := peerNeighbors(nextHopPolicyPrefix, peer)
+
+	var statements []*api.Statement
+	if !delete {
+		var err error
+		statements, err = nextHopStatements(name, peer)
+		if err != nil {
+			return err
+		}
+	}
+
+	existed := b.deletePeerPolicy(name)
+	if len(statements) == 0 {
+		if existed {
+			b.softResetPeers(peer)
+		}
+		return nil
+	}
+
+	if err := b.addPeerPolicy(name, neighbors, statements); err != nil {
+		return err
+	}
+
+	b.softResetPeers(peer)
+	return nil
+}
+
+// addPeerPolicy installs a global export policy matching the neighbors in
+// front of the policies configured by the user.
+func (b *Bgp) addPeerPolicy(name string, neighbors []string, statements []*api.Statement) error {
+	ctx := context.Background()
+	err := b.bgpServer.AddDefinedSet(ctx, &api.AddDefinedSetRequest{
+		DefinedSet: &api.DefinedSet{
+			DefinedType: api.DefinedType_NEIGHBOR,
+			Name:        name,
+			List:        neighbors,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = b.bgpServer.AddPolicy(ctx, &api.AddPolicyRequest{
+		Policy: &api.Policy{Name: name, Statements: statements},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Statements without a route action fall through, so the user policies
+	// behind still decide whether the path is accepted.
+	assignment := &api.PolicyAssignment{
+		Name:      table.GLOBAL_RIB_NAME,
+		Direction: api.PolicyDirection_EXPORT,
+		Policies:  []*api.Policy{{Name: name}},
+	}
+	err = b.bgpServer.ListPolicyAssignment(ctx, &api.ListPolicyAssignmentRequest{
+		Name:      table.GLOBAL_RIB_NAME,
+		Direction: api.PolicyDirection_EXPORT,
+	}, func(a *api.PolicyAssignment) {
+		assignment.DefaultAction = a.DefaultAction
+		for _, p := range a.Policies {
+			assignment.Policies = append(assignment.Policies, &api.Policy{Name: p.Name})
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.bgpServer.SetPolicyAssignment(ctx, &api.SetPolicyAssignmentRequest{
+		Assignment: assignment,
+	})
+}
+
+// peerNeighbors returns the name of the policy of the peer with the given
+// prefix and the neighbor set it applies to, dynamic neighbors share the
+// policy of their peer group.
+func peerNeighbors(prefix string, peer *bgpapi.BgpPeer) (string, []string) {
+	if peer.IsDynamic() {
+		return prefix + peer.PeerGroupName(), peer.Spec.DynamicNeighbors
+	}
+	if peer.IsUnnumbered() {
+		return prefix + peer.Spec.Conf.NeighborInterface, nil
+	}
+
+	address := peer.Spec.Conf.NeighborAddress
+	if net.ParseIP(address).To4() == nil {
+		return prefix + address, []string{address + "/128"}
+	}
+	return prefix + address, []string{address + "/32"}
+}
+
+// softResetPeers soft resets the peer, or the sessions accepted from its
+// dynamic neighbors.
+func (b *Bgp) softResetPeers(peer *bgpapi.BgpPeer) {
+	if !peer.IsDynamic() {
+		b.softResetPeer(peer.Spec.Conf.NeighborAddress)
+		return
+	}
+
+	for _, p := range b.groupPeers(peer.PeerGroupName()) {
+		b.softResetPeer(p.State.NeighborAddress)
+	}
+}
+
+// deletePeerPolicy removes the policy of a peer and reports whether it existed.
+func (b *Bgp) deletePeerPolicy(name string) bool {
+	ctx := context.Background()
+	policy := &api.Policy{Name: name}
+
+	err := b.bgpServer.DeletePolicyAssignment(ctx, &api.DeletePolicyAssignmentRequest{
+		Assignment: &api.PolicyAssignment{
+			Name:      table.GLOBAL_RIB_NAME,
+			Direction: api.PolicyDirection_EXPORT,
+			Policies:  []*api.Policy{policy},
+		},
+	})
+	if err != nil {
+		klog.V(4).Infof("failed to unassign bgp policy %s: %v", name, err)
+		return false
+	}
+
+	err = b.bgpServer.DeletePolicy(ctx, &api.DeletePolicyRequest{
+		Policy:             policy,
+		PreserveStatements: false,
+		All:                true,
+	})
+	if err != nil {
+		klog.Warningf("failed to delete bgp policy %s: %v", name, err)
+	}
+
+	err = b.bgpServer.DeleteDefinedSet(ctx, &api.DeleteDefinedSetRequest{
+		DefinedSet: &api.DefinedSet{DefinedType: api.DefinedType_NEIGHBOR, Name: name},
+		All:        true,
+	})
+	if err != nil {
+		klog.Warningf("failed to delete bgp neighbor set %s: %v", name, err)
+	}
+	return true
+}
+
+// softResetPeer re-advertises the paths to the peer so that a changed export
+// policy takes effect on an established session.
+func (b *Bgp) softResetPeer(address string) {
+	err := b.bgpServer.ResetPeer(context.Background(), &api.ResetPeerRequest{
+		Address:   address,
+		Soft:      true,
+		Direction: api.ResetPeerRequest_OUT,
+	})
+	if err != nil {
+		klog.V(4).Infof("failed to soft reset bgp peer %s: %v", address, err)
+	}
+}
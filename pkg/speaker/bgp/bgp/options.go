@@ -1,25 +1,75 @@
 package bgp
 
 import (
+	"sync"
+	"time"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/osrg/gobgp/pkg/server"
 	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
 )
 
 type BgpOptions struct {
-	GrpcHosts string `long:"api-hosts" description:"specify the hosts that gobgpd listens on" default:":50051"`
+	GrpcHosts     string `long:"api-hosts" description:"specify the hosts that gobgpd listens on" default:":50051"`
+	DrainPeriod   time.Duration
+	DrainOnCordon bool
+	PortForward   string
+	RibAddr       string
+	// certificate of the rib endpoint, a self-signed one is generated if empty
+	RibCertFile string
+	RibKeyFile  string
 }
 
 func NewBgpOptions() *BgpOptions {
 	return &BgpOptions{
-		GrpcHosts: ":50051",
+		GrpcHosts:   ":50051",
+		DrainPeriod: 5 * time.Second,
+		RibAddr:     ":50054",
 	}
 }
 
 func (options *BgpOptions) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&options.GrpcHosts, "api-hosts", options.GrpcHosts, "specify the hosts that gobgpd listens on")
+	fs.DurationVar(&options.DrainPeriod, "drain-period", options.DrainPeriod, "specify how long the paths are withdrawn before the bgp sessions are closed on shutdown, 0 closes them at once")
+	fs.BoolVar(&options.DrainOnCordon, "drain-on-cordon", options.DrainOnCordon, "specify whether to withdraw the bgp paths while the node is cordoned")
+	fs.StringVar(&options.PortForward, "port-forward", options.PortForward, "specify how to forward port 179 to the listen port of the BgpConf: iptables, nftables, auto or none, empty uses auto if the speaker cannot bind port 179")
+	fs.StringVar(&options.RibAddr, "rib-addr", options.RibAddr, "specify the address the rib endpoint queried by the openelb apiserver binds to, empty disables it")
+	fs.StringVar(&options.RibCertFile, "rib-cert-file", options.RibCertFile, "specify the tls certificate of the rib endpoint, a self-signed one is used if empty")
+	fs.StringVar(&options.RibKeyFile, "rib-key-file", options.RibKeyFile, "specify the tls key of the rib endpoint")
 }
 
 type Bgp struct {
 	bgpServer *server.BgpServer
 	rack      string
+
+	lock       sync.RWMutex
+	peerNotify func(address string)
+	// global configuration used to weight the paths of the nodes
+	conf bgpapi.BgpConfSpec
+	// attributes of the paths, keyed by next hop
+	pathAttrs map[string]pathAttrs
+	// eBGP peers with an as path policy, keyed by policy name
+	asPathPeers map[string]*bgpapi.BgpPeer
+	// serializes the as path policy updates
+	asPathLock sync.Mutex
+	// prefixes of the dynamic neighbors, keyed by peer group
+	dynamicNeighbors map[string][]string
+	// route distinguishers of the configured vrfs, keyed by name
+	vrfs map[string]string
+	// eips advertised in a vrf, keyed by name
+	eips map[string]speaker.Config
+	// peers of the node mesh, keyed by neighbor address
+	meshPeers map[string]*bgpapi.BgpPeer
+	// serializes the node mesh updates
+	meshLock sync.Mutex
+
+	drainPeriod   time.Duration
+	drainOnCordon bool
+	// serializes the balancer changes with draining
+	drainLock sync.Mutex
+	draining  bool
+	// nodes of the balancers, keyed by ip, to advertise them again once the drain ends
+	balancers map[string][]corev1.Node
 }
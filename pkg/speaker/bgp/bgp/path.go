@@ -4,17 +4,17 @@ import (
 	"context"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"net"
+	"strconv"
 
 	"github.com/golang/protobuf/ptypes"
 	"github.com/golang/protobuf/ptypes/any"
 	"github.com/openelb/openelb/pkg/constant"
-	"github.com/openelb/openelb/pkg/metrics"
-	"github.com/openelb/openelb/pkg/speaker"
-	"github.com/openelb/openelb/pkg/util"
 	api "github.com/osrg/gobgp/api"
 	bgppacket "github.com/osrg/gobgp/pkg/packet/bgp"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/klog/v2"
 )
 
@@ -39,7 +39,77 @@ func getFamily(ip string) *api.Family {
 	return family
 }
 
-func toAPIPath(ip string, prefix uint32, nexthop string) *api.Path {
+// parsePrefix accepts a host address or, for aggregated routes, a CIDR.
+func parsePrefix(s string) (string, uint32) {
+	if _, ipNet, err := net.ParseCIDR(s); err == nil {
+		ones, _ := ipNet.Mask.Size()
+		return ipNet.IP.String(), uint32(ones)
+	}
+
+	if net.ParseIP(s).To4() == nil {
+		return s, 128
+	}
+	return s, 32
+}
+
+// pathAttrs are the attributes of the paths with a node as next hop, they
+// let upstream routers prefer some nodes over others.
+type pathAttrs struct {
+	// times the AS is prepended to the AS_PATH by the as path policies of
+	// the eBGP peers, it is not carried by the path itself
+	prepend uint32
+	// AS of the link bandwidth community
+	as uint32
+	// link bandwidth in bytes per second, 0 if not advertised
+	bandwidth float32
+}
+
+// getNodePathAttrs returns the attributes of the paths with the node as next
+// hop, from the node annotations or else the per-rack BgpConf settings.
+func (b *Bgp) getNodePathAttrs(node corev1.Node) pathAttrs {
+	b.lock.RLock()
+	conf := b.conf
+	b.lock.RUnlock()
+
+	rack := node.Labels[constant.OpenELBNodeRack]
+	prepend := conf.AsPathPrependPerRack[rack]
+	if value, ok := node.Annotations[constant.OpenELBNodeAsPathPrepend]; ok {
+		count, err := strconv.ParseUint(value, 10, 8)
+		if err != nil {
+			klog.Warningf("node %s has invalid %s annotation %q", node.Name, constant.OpenELBNodeAsPathPrepend, value)
+		} else {
+			prepend = uint32(count)
+		}
+	}
+
+	bandwidth := conf.LinkBandwidthPerRack[rack]
+	if value, ok := node.Annotations[constant.OpenELBNodeLinkBandwidth]; ok {
+		bandwidth = value
+	}
+
+	attrs := pathAttrs{as: conf.As}
+	if conf.As != 0 {
+		attrs.prepend = prepend
+	}
+	if bandwidth != "" {
+		bps, err := resource.ParseQuantity(bandwidth)
+		if err != nil || bps.Sign() < 0 {
+			klog.Warningf("node %s has invalid link bandwidth %q", node.Name, bandwidth)
+		} else {
+			attrs.bandwidth = float32(bps.AsApproximateFloat64() / 8)
+		}
+	}
+	return attrs
+}
+
+func (b *Bgp) getPathAttrs(nexthop string) pathAttrs {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	return b.pathAttrs[nexthop]
+}
+
+func toAPIPath(ip string, prefix uint32, nexthop string, attrs pathAttrs) *api.Path {
 	nlri, _ := ptypes.MarshalAny(&api.IPAddressPrefix{
 		Prefix:    ip,
 		PrefixLen: prefix,
@@ -47,19 +117,86 @@ func toAPIPath(ip string, prefix uint32, nexthop string) *api.Path {
 	a1, _ := ptypes.MarshalAny(&api.OriginAttribute{
 		Origin: uint32(bgppacket.BGP_ORIGIN_ATTR_TYPE_IGP),
 	})
+	// gobgp moves the next hop into MP_REACH_NLRI for ipv6 paths and ipv4
+	// paths with an ipv6 next hop (RFC 5549)
 	a2, _ := ptypes.MarshalAny(&api.NextHopAttribute{
 		NextHop: nexthop,
 	})
-	attrs := []*any.Any{a1, a2}
+	pattrs := []*any.Any{a1, a2}
+
+	if attrs.bandwidth > 0 {
+		pattrs = append(pattrs, toLinkBandwidth(attrs))
+	}
 
 	return &api.Path{
 		Family:     getFamily(ip),
 		Nlri:       nlri,
-		Pattrs:     attrs,
+		Pattrs:     pattrs,
 		Identifier: getPathIdentifier(nexthop),
 	}
 }
 
+// toLinkBandwidth returns the non-transitive link bandwidth extended community,
+// carrying the bandwidth as IEEE float in the local administrator field.
+func toLinkBandwidth(attrs pathAttrs) *any.Any {
+	as := uint32(bgppacket.AS_TRANS)
+	if attrs.as != 0 && attrs.as <= math.MaxUint16 {
+		as = attrs.as
+	}
+	community, _ := ptypes.MarshalAny(&api.TwoOctetAsSpecificExtended{
+		IsTransitive: false,
+		SubType:      uint32(bgppacket.EC_SUBTYPE_LINK_BANDWIDTH),
+		As:           as,
+		LocalAdmin:   math.Float32bits(attrs.bandwidth),
+	})
+	a, _ := ptypes.MarshalAny(&api.ExtendedCommunitiesAttribute{
+		Communities: []*any.Any{community},
+	})
+	return a
+}
+
+// pathAttrsFromAPIPath returns the attributes carried by the path.
+func pathAttrsFromAPIPath(path *api.Path) pathAttrs {
+	var attrs pathAttrs
+	for _, attr := range path.Pattrs {
+		var value ptypes.DynamicAny
+
+		ptypes.UnmarshalAny(attr, &value)
+
+		switch a := value.Message.(type) {
+		case *api.ExtendedCommunitiesAttribute:
+			for _, c := range a.Communities {
+				var community ptypes.DynamicAny
+				ptypes.UnmarshalAny(c, &community)
+				if ec, ok := community.Message.(*api.TwoOctetAsSpecificExtended); ok && !ec.IsTransitive &&
+					ec.SubType == uint32(bgppacket.EC_SUBTYPE_LINK_BANDWIDTH) {
+					attrs.as = ec.As
+					attrs.bandwidth = math.Float32frombits(ec.LocalAdmin)
+				}
+			}
+		}
+	}
+
+	return attrs
+}
+
+func asPathFromAPIPath(path *api.Path) []uint32 {
+	var asPath []uint32
+	for _, attr := range path.Pattrs {
+		var value ptypes.DynamicAny
+
+		ptypes.UnmarshalAny(attr, &value)
+
+		if a, ok := value.Message.(*api.AsPathAttribute); ok {
+			for _, segment := range a.Segments {
+				asPath = append(asPath, segment.Numbers...)
+			}
+		}
+	}
+
+	return asPath
+}
+
 func fromAPIPath(path *api.Path) net.IP {
 	for _, attr := range path.Pattrs {
 		var value ptypes.DynamicAny
@@ -69,23 +206,44 @@ func fromAPIPath(path *api.Path) net.IP {
 		switch a := value.Message.(type) {
 		case *api.NextHopAttribute:
 			return net.ParseIP(a.NextHop)
+		case *api.MpReachNLRIAttribute:
+			if len(a.NextHops) > 0 {
+				return net.ParseIP(a.NextHops[0])
+			}
 		}
 	}
 
 	return nil
 }
 
-func (b *Bgp) retriveRoutes(ip string, prefix uint32, nexthops []string) (err error, toAdd, toDelete []string) {
-	listPathRequest := &api.ListPathRequest{
-		TableType: api.TableType_GLOBAL,
-		Family:    getFamily(ip),
-		Prefixes: []*api.TableLookupPrefix{
-			{
-				Prefix: ip,
+// listPaths calls fn with the destination of the prefix in the table.
+func (b *Bgp) listPaths(t pathTable, ip string, prefix uint32, fn func(*api.Destination)) error {
+	if t.vrf == "" {
+		return b.bgpServer.ListPath(context.Background(), &api.ListPathRequest{
+			TableType: api.TableType_GLOBAL,
+			Family:    getFamily(ip),
+			Prefixes: []*api.TableLookupPrefix{
+				{
+					// a bare address would match the covering aggregate route
+					Prefix: fmt.Sprintf("%s/%d", ip, prefix),
+				},
 			},
-		},
+		}, fn)
 	}
 
+	// gobgp does not look up prefixes of VPN paths
+	key := fmt.Sprintf("%s:%s/%d", t.rd, ip, prefix)
+	return b.bgpServer.ListPath(context.Background(), &api.ListPathRequest{
+		TableType: api.TableType_GLOBAL,
+		Family:    getVPNFamily(ip),
+	}, func(d *api.Destination) {
+		if d.Prefix == key {
+			fn(d)
+		}
+	})
+}
+
+func (b *Bgp) retriveRoutes(t pathTable, ip string, prefix uint32, nexthops []string) (err error, toAdd, toDelete []string) {
 	origins := make(map[string]bool)
 	news := make(map[string]bool)
 	for _, item := range nexthops {
@@ -95,8 +253,13 @@ func (b *Bgp) retriveRoutes(ip string, prefix uint32, nexthops []string) (err er
 	fn := func(d *api.Destination) {
 		found = true
 		for _, path := range d.Paths {
-			nexthop := fromAPIPath(path)
-			origins[nexthop.String()] = true
+			nexthop := fromAPIPath(path).String()
+			// re-adding the path replaces the one with an outdated bandwidth,
+			// the prepends are applied by the as path policies
+			if news[nexthop] && pathAttrsFromAPIPath(path).bandwidth != b.getPathAttrs(nexthop).bandwidth {
+				continue
+			}
+			origins[nexthop] = true
 		}
 		//compare
 		for key := range origins {
@@ -111,7 +274,7 @@ func (b *Bgp) retriveRoutes(ip string, prefix uint32, nexthops []string) (err er
 		}
 	}
 
-	err = b.bgpServer.ListPath(context.Background(), listPathRequest, fn)
+	err = b.listPaths(t, ip, prefix, fn)
 	if err != nil {
 		return
 	}
@@ -136,88 +299,98 @@ func (b *Bgp) ready() error {
 }
 
 func (b *Bgp) setBalancer(ip string, nexthops []string) error {
-	prefix := uint32(32)
-	if net.ParseIP(ip).To4() == nil {
-		prefix = 128
+	t, err := b.getPathTable(ip)
+	if err != nil {
+		return err
 	}
+	ip, prefix := parsePrefix(ip)
 
-	err, toAdd, toDelete := b.retriveRoutes(ip, prefix, nexthops)
+	err, toAdd, toDelete := b.retriveRoutes(t, ip, prefix, nexthops)
 	if err != nil {
 		return err
 	}
 
-	err = b.addMultiRoutes(ip, prefix, toAdd)
+	err = b.addMultiRoutes(t, ip, prefix, toAdd)
 	if err != nil {
 		return err
 	}
-	err = b.deleteMultiRoutes(ip, prefix, toDelete)
+	err = b.deleteMultiRoutes(t, ip, prefix, toDelete)
 	if err != nil {
 		return err
 	}
 
-	peerList := b.getPeers()
-	if peerList != nil {
-		for _, peer := range peerList {
-			if len(toAdd) != 0 {
-				metrics.UpdateBGPPathMetrics(peer.Conf.NeighborAddress, util.GetNodeName(), 1, 0)
-			}
-		}
+	if len(toAdd) != 0 || len(toDelete) != 0 {
+		b.updatePeerMetrics("")
 	}
 	return nil
 }
 
-func (b *Bgp) getPeers() []*api.Peer {
-	peerList := []*api.Peer{}
-	fn := func(p *api.Peer) {
-		peerList = append(peerList, p)
-	}
-	err := b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{}, fn)
-	if err != nil {
+func (b *Bgp) SetBalancer(ip string, nodes []corev1.Node) error {
+	b.drainLock.Lock()
+	defer b.drainLock.Unlock()
+
+	b.balancers[ip] = nodes
+	if b.draining {
+		klog.Infof("bgp setBalancer ip:%s deferred while draining", ip)
 		return nil
 	}
-	return peerList
+	defer b.prunePathAttrs()
+	return b.setNodeBalancer(ip, nodes)
 }
 
-func (b *Bgp) SetBalancer(ip string, nodes []corev1.Node) error {
+func (b *Bgp) setNodeBalancer(ip string, nodes []corev1.Node) error {
 	err := b.ready()
 	if err != nil {
 		return err
 	}
 
+	addr, _ := parsePrefix(ip)
+	v4 := net.ParseIP(addr).To4() != nil
+
 	var nexthops []string
+	candidates := 0
+	prepended := false
 	for _, node := range nodes {
 		rack := ""
 		if node.Labels != nil {
 			rack = node.Labels[constant.OpenELBNodeRack]
 		}
 		if rack == b.rack || b.rack == "" {
-			nexthop, err := b.getNodeNextHop(node)
+			candidates++
+			nexthop, err := getNodeNextHop(node, v4)
 			if err != nil {
-				return err
+				klog.Warningf("bgp setBalancer ip:%s skip node: %v", ip, err)
+				continue
 			}
 			nexthops = append(nexthops, nexthop)
+
+			attrs := b.getNodePathAttrs(node)
+			b.lock.Lock()
+			if b.pathAttrs[nexthop].prepend != attrs.prepend {
+				prepended = true
+			}
+			b.pathAttrs[nexthop] = attrs
+			b.lock.Unlock()
 		}
 	}
+	if prepended {
+		// before the paths are added, so they are never sent unweighted
+		b.updateAsPathPolicies()
+	}
+	if candidates > 0 && len(nexthops) == 0 {
+		return fmt.Errorf("no next hop available for %s", ip)
+	}
 
 	klog.Infof("bgp setBalancer ip:%s nexthops:%s", ip, nexthops)
 	return b.setBalancer(ip, nexthops)
 }
 
-func (b *Bgp) getNodeNextHop(node corev1.Node) (string, error) {
-	for _, addr := range node.Status.Addresses {
-		if addr.Type == corev1.NodeInternalIP {
-			return addr.Address, nil
-		}
-	}
-
-	return "", fmt.Errorf("node has no internal ip")
-}
-
-func (b *Bgp) addMultiRoutes(ip string, prefix uint32, nexthops []string) error {
+func (b *Bgp) addMultiRoutes(t pathTable, ip string, prefix uint32, nexthops []string) error {
 	for _, nexthop := range nexthops {
-		apipath := toAPIPath(ip, prefix, nexthop)
+		apipath := toAPIPath(ip, prefix, nexthop, b.getPathAttrs(nexthop))
 		_, err := b.bgpServer.AddPath(context.Background(), &api.AddPathRequest{
-			Path: apipath,
+			VrfId: t.vrf,
+			Path:  apipath,
 		})
 		if err != nil {
 			return err
@@ -226,11 +399,12 @@ func (b *Bgp) addMultiRoutes(ip string, prefix uint32, nexthops []string) error
 	return nil
 }
 
-func (b *Bgp) deleteMultiRoutes(ip string, prefix uint32, nexthops []string) error {
+func (b *Bgp) deleteMultiRoutes(t pathTable, ip string, prefix uint32, nexthops []string) error {
 	for _, nexthop := range nexthops {
-		apipath := toAPIPath(ip, prefix, nexthop)
+		apipath := toAPIPath(ip, prefix, nexthop, pathAttrs{})
 		err := b.bgpServer.DeletePath(context.Background(), &api.DeletePathRequest{
-			Path: apipath,
+			VrfId: t.vrf,
+			Path:  apipath,
 		})
 		if err != nil {
 			return err
@@ -240,36 +414,80 @@ func (b *Bgp) deleteMultiRoutes(ip string, prefix uint32, nexthops []string) err
 }
 
 func (b *Bgp) DelBalancer(ip string) error {
+	b.drainLock.Lock()
+	defer b.drainLock.Unlock()
+
+	delete(b.balancers, ip)
+	defer b.prunePathAttrs()
+	return b.delBalancer(ip)
+}
+
+// prunePathAttrs drops the attributes of the next hops no longer used by any
+// balancer, the caller holds drainLock.
+func (b *Bgp) prunePathAttrs() {
+	used := make(map[string]bool)
+	for ip, nodes := range b.balancers {
+		addr, _ := parsePrefix(ip)
+		v4 := net.ParseIP(addr).To4() != nil
+		for _, node := range nodes {
+			if nexthop, err := getNodeNextHop(node, v4); err == nil {
+				used[nexthop] = true
+			}
+		}
+	}
+
+	prepended := false
+	b.lock.Lock()
+	for nexthop, attrs := range b.pathAttrs {
+		if used[nexthop] {
+			continue
+		}
+		if attrs.prepend > 0 {
+			prepended = true
+		}
+		delete(b.pathAttrs, nexthop)
+	}
+	b.lock.Unlock()
+
+	if prepended {
+		b.updateAsPathPolicies()
+	}
+}
+
+func (b *Bgp) delBalancer(ip string) error {
 	err := b.ready()
 	if err != nil {
 		klog.Warning(err)
 		return nil
 	}
 
-	lookup := &api.TableLookupPrefix{
-		Prefix: ip,
-	}
-	listPathRequest := &api.ListPathRequest{
-		TableType: api.TableType_GLOBAL,
-		Family:    getFamily(ip),
-		Prefixes:  []*api.TableLookupPrefix{lookup},
+	t, err := b.getPathTable(ip)
+	if err != nil {
+		// the paths were dropped with the vrf
+		klog.Warning(err)
+		return nil
 	}
+
+	ip, prefix := parsePrefix(ip)
 	var errDelete error
-	existPath := true
+	existPath := false
 	fn := func(d *api.Destination) {
-		if len(d.Paths) == 0 {
-			existPath = false
+		if len(d.Paths) != 0 {
+			existPath = true
 		}
 		for _, path := range d.Paths {
+			// rebuild the path, gobgp drops the path identifier of listed
+			// paths carrying MP_REACH_NLRI
 			errDelete = b.bgpServer.DeletePath(context.Background(), &api.DeletePathRequest{
-				Path: path,
+				VrfId: t.vrf,
+				Path:  toAPIPath(ip, prefix, fromAPIPath(path).String(), pathAttrs{}),
 			})
 			if errDelete != nil {
 				return
 			}
 		}
 	}
-	err = b.bgpServer.ListPath(context.Background(), listPathRequest, fn)
+	err = b.listPaths(t, ip, prefix, fn)
 	if err != nil {
 		return err
 	}
@@ -277,26 +495,9 @@ func (b *Bgp) DelBalancer(ip string) error {
 		return errDelete
 	}
 
-	klog.Infof("bgp delBalancer ip:%s", ip)
-	peerList := b.getPeers()
-	if peerList != nil && existPath {
-		for _, peer := range peerList {
-			metrics.UpdateBGPPathMetrics(peer.Conf.NeighborAddress, util.GetNodeName(), 0, 1)
-		}
+	klog.Infof("bgp delBalancer ip:%s/%d table:%s", ip, prefix, t)
+	if existPath {
+		b.updatePeerMetrics("")
 	}
 	return nil
 }
-
-func (b *Bgp) MonitorPeers(ctx context.Context, ip string) error {
-	b.bgpServer.MonitorPeer(ctx, &api.MonitorPeerRequest{
-		Address: "xxx",
-	}, func(p *api.Peer) {
-
-	})
-	return nil
-}
-
-// TODO:
-func (l *Bgp) ConfigureWithEIP(config speaker.Config, deleted bool) error {
-	return nil
-}
@@ -3,7 +3,10 @@ package bgp
 import (
 	"fmt"
 	"net"
-	"strconv"
+	"sort"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
 
 	bgpapi "github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/metrics"
@@ -34,6 +37,22 @@ func (b *Bgp) HandleBgpPeerStatus(bgpPeers []bgpapi.BgpPeer) []*bgpapi.BgpPeer {
 		dels   []*api.Peer
 	)
 
+	nodeName := util.GetNodeName()
+	ifaces := resolveNeighborInterfaces(bgpPeers)
+	clones := make(map[string]*bgpapi.BgpPeer)
+	getClone := func(bgpPeer *bgpapi.BgpPeer) *bgpapi.BgpPeer {
+		clone, ok := clones[bgpPeer.Name]
+		if !ok {
+			clone = bgpPeer.DeepCopy()
+			delete(clone.Status.NodesPeerStatus, nodeName)
+			delete(clone.Status.NodesDynamicPeerStatus, nodeName)
+			clone.Status.SetNodeRack(nodeName, b.rack)
+			clones[bgpPeer.Name] = clone
+			result = append(result, clone)
+		}
+		return clone
+	}
+
 	fn := func(peer *api.Peer) {
 		tmp, err := bgpapi.GetStatusFromGoBgpPeer(peer)
 		if err != nil {
@@ -41,38 +60,82 @@ func (b *Bgp) HandleBgpPeerStatus(bgpPeers []bgpapi.BgpPeer) []*bgpapi.BgpPeer {
 			return
 		}
 
-		var found *bgpapi.BgpPeer
-
-		for _, bgpPeer := range bgpPeers {
-			if bgpPeer.Spec.Conf.NeighborAddress == tmp.PeerState.NeighborAddress {
-				found = &bgpPeer
-				break
+		for i := range bgpPeers {
+			bgpPeer := &bgpPeers[i]
+			if bgpPeer.Spec.Conf != nil && bgpPeer.Spec.Conf.NeighborAddress == tmp.PeerState.NeighborAddress {
+				clone := getClone(bgpPeer)
+				if clone.Status.NodesPeerStatus == nil {
+					clone.Status.NodesPeerStatus = make(map[string]bgpapi.NodePeerStatus)
+				}
+				clone.Status.NodesPeerStatus[nodeName] = tmp
+				return
 			}
 		}
 
-		if found == nil {
-			dels = append(dels, peer)
-		} else {
-			clone := found.DeepCopy()
-			if clone.Status.NodesPeerStatus == nil {
-				clone.Status.NodesPeerStatus = make(map[string]bgpapi.NodePeerStatus)
+		// interface peers are resolved to the local interface on each node
+		if iface := peer.Conf.NeighborInterface; iface != "" {
+			for i := range bgpPeers {
+				bgpPeer := &bgpPeers[i]
+				if name, ok := ifaces[bgpPeer.Name]; !ok || name != iface {
+					continue
+				}
+
+				clone := getClone(bgpPeer)
+				if clone.Status.NodesPeerStatus == nil {
+					clone.Status.NodesPeerStatus = make(map[string]bgpapi.NodePeerStatus)
+				}
+				clone.Status.NodesPeerStatus[nodeName] = tmp
+				return
 			}
+		}
+
+		// sessions accepted from dynamic neighbors belong to the BgpPeer
+		// of their peer group, gobgp removes them once they are closed.
+		if group := peer.Conf.PeerGroup; group != "" {
+			for i := range bgpPeers {
+				bgpPeer := &bgpPeers[i]
+				if !bgpPeer.IsDynamic() || bgpPeer.PeerGroupName() != group {
+					continue
+				}
 
-			if clone.Spec.Conf.NeighborAddress == tmp.PeerState.NeighborAddress {
-				clone.Status.NodesPeerStatus[util.GetNodeName()] = tmp
+				clone := getClone(bgpPeer)
+				if clone.Status.NodesDynamicPeerStatus == nil {
+					clone.Status.NodesDynamicPeerStatus = make(map[string][]bgpapi.NodePeerStatus)
+				}
+				clone.Status.NodesDynamicPeerStatus[nodeName] = append(clone.Status.NodesDynamicPeerStatus[nodeName], tmp)
+				return
 			}
+		}
 
-			result = append(result, clone)
+		if b.isMeshPeer(peer.Conf.NeighborAddress) {
+			return
 		}
+
+		dels = append(dels, peer)
 	}
 	b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{
 		Address: "",
 	}, fn)
 
+	// gobgp lists the peers in random order
+	for _, clone := range result {
+		sessions := clone.Status.NodesDynamicPeerStatus[nodeName]
+		sort.Slice(sessions, func(i, j int) bool {
+			return sessions[i].PeerState.NeighborAddress < sessions[j].PeerState.NeighborAddress
+		})
+	}
+
 	for _, del := range dels {
-		klog.Infof("delete useless bgp peer: %s", del.Conf.NeighborAddress)
+		address := del.Conf.NeighborAddress
+		if address == "" && del.State != nil {
+			// left over from a deleted peer group
+			address = del.State.NeighborAddress
+		}
+		klog.Infof("delete useless bgp peer: %s", address)
+		b.deletePeerPolicy(nextHopPolicyPrefix + address)
+		b.deletePeerPolicy(asPathPolicyPrefix + address)
 		b.bgpServer.DeletePeer(context.Background(), &api.DeletePeerRequest{
-			Address:   del.Conf.NeighborAddress,
+			Address:   address,
 			Interface: del.Conf.NeighborInterface,
 		})
 	}
@@ -104,95 +167,240 @@ func (b *Bgp) GetBgpConfStatus() bgpapi.BgpConf {
 	}
 }
 
-func (b *Bgp) HandleBgpPeer(neighbor *bgpapi.BgpPeer, delete bool) error {
+// defaultFamilies returns the address families of the peer if none is
+// configured, interface peers exchange both IPv4 and IPv6 unicast routes.
+func defaultFamilies(neighbor *bgpapi.BgpPeer) ([]*bgpapi.Family, error) {
+	if neighbor.IsUnnumbered() {
+		return []*bgpapi.Family{defaultFamily(net.IPv4zero), defaultFamily(net.IPv6zero)}, nil
+	}
+
+	if neighbor.IsDynamic() {
+		ip, err := dynamicNeighborFamily(neighbor)
+		if err != nil {
+			return nil, err
+		}
+		return []*bgpapi.Family{defaultFamily(ip)}, nil
+	}
+
+	ip := net.ParseIP(neighbor.Spec.Conf.NeighborAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("field Spec.Conf.NeighborAddress invalid")
+	}
+	return []*bgpapi.Family{defaultFamily(ip)}, nil
+}
+
+// goBgpPeer returns the gobgp configuration of the neighbor, filling in the
+// default address families.
+func goBgpPeer(neighbor *bgpapi.BgpPeer) (*api.Peer, error) {
 	// set default afisafi
 	if len(neighbor.Spec.AfiSafis) == 0 {
-		ip := net.ParseIP(neighbor.Spec.Conf.NeighborAddress)
-		if ip == nil {
-			return fmt.Errorf("field Spec.Conf.NeighborAddress invalid")
-		}
-		neighbor.Spec.AfiSafis = append(neighbor.Spec.AfiSafis, &bgpapi.AfiSafi{
-			Config: &bgpapi.AfiSafiConfig{
-				Family:  defaultFamily(ip),
-				Enabled: true,
-			},
-			AddPaths: &bgpapi.AddPaths{
-				Config: &bgpapi.AddPathsConfig{
-					SendMax: 10,
+		families, err := defaultFamilies(neighbor)
+		if err != nil {
+			return nil, err
+		}
+		for _, family := range families {
+			neighbor.Spec.AfiSafis = append(neighbor.Spec.AfiSafis, &bgpapi.AfiSafi{
+				Config: &bgpapi.AfiSafiConfig{
+					Family:  family,
+					Enabled: true,
 				},
-			},
-		})
+				AddPaths: &bgpapi.AddPaths{
+					Config: &bgpapi.AddPathsConfig{
+						SendMax: 10,
+					},
+				},
+			})
+		}
 	} else {
 		for i := 0; i < len(neighbor.Spec.AfiSafis); i++ {
 			if neighbor.Spec.AfiSafis[i].Config == nil {
-				ip := net.ParseIP(neighbor.Spec.Conf.NeighborAddress)
-				if ip == nil {
-					return fmt.Errorf("field Spec.Conf.NeighborAddress invalid")
+				families, err := defaultFamilies(neighbor)
+				if err != nil {
+					return nil, err
+				}
+				if len(families) != 1 {
+					return nil, fmt.Errorf("field Spec.AfiSafis[%d].Config is required for interface peers", i)
 				}
 				neighbor.Spec.AfiSafis[i].Config = &bgpapi.AfiSafiConfig{
-					Family:  defaultFamily(ip),
+					Family:  families[0],
 					Enabled: true,
 				}
 			}
 		}
 	}
 
-	request, e := neighbor.Spec.ToGoBgpPeer()
+	return neighbor.Spec.ToGoBgpPeer()
+}
+
+func (b *Bgp) HandleBgpPeer(neighbor *bgpapi.BgpPeer, delete bool) error {
+	request, e := goBgpPeer(neighbor)
 	if e != nil {
 		return e
 	}
 
+	if neighbor.IsDynamic() {
+		defer b.notifyPeer("")
+		if e = b.handleDynamicNeighbors(neighbor, request, delete); e != nil {
+			return e
+		}
+		if e = b.updateAsPathPolicy(neighbor, delete); e != nil {
+			return e
+		}
+		return b.updateNextHopPolicy(neighbor, delete)
+	}
+	// the peer may have accepted dynamic neighbors before
+	b.handleDynamicNeighbors(neighbor, nil, true)
+
+	if neighbor.IsUnnumbered() {
+		if e = b.resolveNeighborInterface(request); e != nil {
+			if delete {
+				// the leftover session is removed with the status sync
+				return nil
+			}
+			return e
+		}
+	}
+
 	b.UpdatePeerMetrics(neighbor, delete)
+	defer b.notifyPeer(request.Conf.NeighborAddress)
 	if delete {
+		address, iface := request.Conf.NeighborAddress, request.Conf.NeighborInterface
+		if request.State != nil {
+			address, iface = request.State.NeighborAddress, ""
+		}
 		b.bgpServer.DeletePeer(context.Background(), &api.DeletePeerRequest{
-			Address:   request.Conf.NeighborAddress,
-			Interface: request.Conf.NeighborInterface,
+			Address:   address,
+			Interface: iface,
 		})
 	} else {
 		_, e = b.bgpServer.UpdatePeer(context.Background(), &api.UpdatePeerRequest{
 			Peer: request,
 		})
 		if e != nil {
-			return b.bgpServer.AddPeer(context.Background(), &api.AddPeerRequest{
+			e = b.bgpServer.AddPeer(context.Background(), &api.AddPeerRequest{
 				Peer: request,
 			})
+			if e != nil {
+				return e
+			}
 		}
 	}
 
-	return nil
+	if e = b.updateAsPathPolicy(neighbor, delete); e != nil {
+		return e
+	}
+	return b.updateNextHopPolicy(neighbor, delete)
 }
 
+// MonitorPeers calls fn with the neighbor address of a peer every time its
+// session state changes, as well as when its configuration is added, updated
+// or removed. An empty address means that every peer may have changed, e.g.
+// after the global configuration was reset. It blocks until the context is
+// done or the monitor fails.
+func (b *Bgp) MonitorPeers(ctx context.Context, fn func(address string)) error {
+	b.lock.Lock()
+	b.peerNotify = fn
+	b.lock.Unlock()
+
+	defer func() {
+		b.lock.Lock()
+		b.peerNotify = nil
+		b.lock.Unlock()
+	}()
+
+	err := b.bgpServer.MonitorPeer(ctx, &api.MonitorPeerRequest{
+		Current: true,
+	}, func(p *api.Peer) {
+		klog.V(4).Infof("bgp peer %s session state changed to %s", p.State.NeighborAddress, p.State.SessionState)
+		fn(p.State.NeighborAddress)
+	})
+	if err != nil {
+		return err
+	}
+
+	// gobgp streams the events until the context is done
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (b *Bgp) notifyPeer(address string) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if b.peerNotify != nil {
+		b.peerNotify(address)
+	}
+}
+
+// UpdatePeerMetrics refreshes the session and prefix metrics of the peer on
+// this node from gobgp, or drops them if the peer was deleted.
 func (b *Bgp) UpdatePeerMetrics(peer *bgpapi.BgpPeer, delete bool) {
-	status := peer.Status
-	for node, peerStatus := range status.NodesPeerStatus {
-		var state float64 = 0
-		peerIP := peer.Spec.Conf.NeighborAddress
-		if node != util.GetNodeName() {
-			continue
-		}
-
-		if delete {
-			metrics.DeleteBGPPeerMetrics(peerIP, node)
-			continue
-		}
-
-		stateStr := peerStatus.PeerState.SessionState
-		switch stateStr {
-		case "IDLE":
-			state = 0
-		case "CONNECT":
-			state = 1
-		case "ACTIVE":
-			state = 2
-		case "OPENSENT":
-			state = 3
-		case "OPENCONFIRM":
-			state = 4
-		case "ESTABLISHED":
-			state = 5
-		}
-
-		updateCount, _ := strconv.ParseFloat(peerStatus.PeerState.Messages.Received.Update, 64)
-		metrics.UpdateBGPSessionMetrics(peerIP, node, state, updateCount)
+	b.UpdateNeighborMetrics(peer.Spec.Conf.NeighborAddress, delete)
+}
+
+// UpdateNeighborMetrics refreshes the metrics of the session with the given
+// neighbor address, or drops them if the session was removed.
+func (b *Bgp) UpdateNeighborMetrics(address string, delete bool) {
+	if address == "" {
+		// interface peers are refreshed by their resolved address
+		return
 	}
+
+	if delete {
+		metrics.DeleteBGPPeerMetrics(address, util.GetNodeName())
+		return
+	}
+
+	b.updatePeerMetrics(address)
+}
+
+// updatePeerMetrics refreshes the metrics of the peer with the given address,
+// or of all peers if address is empty.
+func (b *Bgp) updatePeerMetrics(address string) {
+	err := b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{
+		Address:          address,
+		EnableAdvertised: true,
+	}, setPeerMetrics)
+	if err != nil {
+		klog.Errorf("failed to update metrics of bgp peer %s: %v", address, err)
+	}
+}
+
+func setPeerMetrics(peer *api.Peer) {
+	if peer.State == nil {
+		return
+	}
+
+	var (
+		state                     float64
+		establishedAt             time.Time
+		received, sent, withdrawn float64
+		announced                 uint64
+	)
+
+	// gobgp starts counting at UNKNOWN, the metric at IDLE
+	if peer.State.SessionState > api.PeerState_UNKNOWN {
+		state = float64(peer.State.SessionState - api.PeerState_IDLE)
+	}
+	if peer.State.SessionState == api.PeerState_ESTABLISHED && peer.Timers != nil && peer.Timers.State != nil {
+		establishedAt, _ = ptypes.Timestamp(peer.Timers.State.Uptime)
+	}
+	if msgs := peer.State.Messages; msgs != nil {
+		if msgs.Received != nil {
+			received = float64(msgs.Received.Update)
+		}
+		if msgs.Sent != nil {
+			sent = float64(msgs.Sent.Update)
+			withdrawn = float64(msgs.Sent.WithdrawPrefix)
+		}
+	}
+	for _, afiSafi := range peer.AfiSafis {
+		if afiSafi.State != nil {
+			announced += afiSafi.State.Advertised
+		}
+	}
+
+	node := util.GetNodeName()
+	metrics.UpdateBGPSessionMetrics(peer.State.NeighborAddress, node, state, establishedAt, received, sent)
+	metrics.UpdateBGPPathMetrics(peer.State.NeighborAddress, node, float64(announced), withdrawn)
 }
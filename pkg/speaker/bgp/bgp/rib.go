@@ -0,0 +1,111 @@
+package bgp
+
+import (
+	"context"
+	"sort"
+
+	api "github.com/osrg/gobgp/api"
+)
+
+// RibRoute is a path of a gobgp table.
+type RibRoute struct {
+	Prefix  string   `json:"prefix"`
+	NextHop string   `json:"nextHop"`
+	AsPath  []uint32 `json:"asPath,omitempty"`
+	Best    bool     `json:"best,omitempty"`
+	// vrf of the path, empty for the global table
+	Vrf string `json:"vrf,omitempty"`
+}
+
+// PeerRib is the Adj-RIB-Out of a peer.
+type PeerRib struct {
+	Peer   string     `json:"peer"`
+	Routes []RibRoute `json:"routes"`
+}
+
+// Rib is what the speaker advertises.
+type Rib struct {
+	// Adj-RIB-Out of the peers, sorted by peer address
+	AdjRibOut []PeerRib `json:"adjRibOut"`
+	// paths of the OpenELB prefixes in the RIB
+	Global []RibRoute `json:"global"`
+}
+
+func ribRoutes(d *api.Destination, vrf string) []RibRoute {
+	var routes []RibRoute
+	for _, path := range d.Paths {
+		routes = append(routes, RibRoute{
+			Prefix:  d.Prefix,
+			NextHop: fromAPIPath(path).String(),
+			AsPath:  asPathFromAPIPath(path),
+			Best:    path.Best,
+			Vrf:     vrf,
+		})
+	}
+	return routes
+}
+
+// GetRib returns the Adj-RIB-Out of every peer and the paths of the
+// balancers set on the speaker.
+func (b *Bgp) GetRib(ctx context.Context) (*Rib, error) {
+	if err := b.ready(); err != nil {
+		return nil, err
+	}
+
+	rib := &Rib{AdjRibOut: []PeerRib{}, Global: []RibRoute{}}
+
+	var peers []*api.Peer
+	err := b.bgpServer.ListPeer(ctx, &api.ListPeerRequest{}, func(p *api.Peer) {
+		peers = append(peers, p)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range peers {
+		peerRib := PeerRib{Peer: p.State.NeighborAddress, Routes: []RibRoute{}}
+		for _, afiSafi := range p.AfiSafis {
+			family := afiSafi.GetConfig().GetFamily()
+			if family == nil || family.Safi != api.Family_SAFI_UNICAST {
+				continue
+			}
+			err := b.bgpServer.ListPath(ctx, &api.ListPathRequest{
+				TableType: api.TableType_ADJ_OUT,
+				Name:      p.State.NeighborAddress,
+				Family:    family,
+			}, func(d *api.Destination) {
+				peerRib.Routes = append(peerRib.Routes, ribRoutes(d, "")...)
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		rib.AdjRibOut = append(rib.AdjRibOut, peerRib)
+	}
+	sort.Slice(rib.AdjRibOut, func(i, j int) bool {
+		return rib.AdjRibOut[i].Peer < rib.AdjRibOut[j].Peer
+	})
+
+	b.drainLock.Lock()
+	var balancers []string
+	for ip := range b.balancers {
+		balancers = append(balancers, ip)
+	}
+	b.drainLock.Unlock()
+	sort.Strings(balancers)
+
+	for _, ip := range balancers {
+		t, err := b.getPathTable(ip)
+		if err != nil {
+			return nil, err
+		}
+		addr, prefix := parsePrefix(ip)
+		err = b.listPaths(t, addr, prefix, func(d *api.Destination) {
+			rib.Global = append(rib.Global, ribRoutes(d, t.vrf)...)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rib, nil
+}
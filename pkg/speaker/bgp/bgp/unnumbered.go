@@ -0,0 +1,54 @@
+package bgp
+
+import (
+	"fmt"
+
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/speaker"
+	api "github.com/osrg/gobgp/api"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// resolveNeighborInterface replaces the interface of an unnumbered peer with
+// the local interface it refers to on this node. gobgp keys the peer by the
+// link-local address of the neighbor found on the interface, which is set as
+// the peer state if the session already exists so that it can be updated.
+func (b *Bgp) resolveNeighborInterface(request *api.Peer) error {
+	iface, err := speaker.ParseInterface(request.Conf.NeighborInterface)
+	if err != nil {
+		return fmt.Errorf("failed to resolve neighbor interface %s: %v", request.Conf.NeighborInterface, err)
+	}
+	request.Conf.NeighborInterface = iface.Name
+
+	err = b.bgpServer.ListPeer(context.Background(), &api.ListPeerRequest{}, func(p *api.Peer) {
+		if p.Conf != nil && p.Conf.NeighborInterface == iface.Name && p.State != nil {
+			request.State = &api.PeerState{NeighborAddress: p.State.NeighborAddress}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// resolveNeighborInterfaces returns the local interface of the unnumbered
+// peers on this node, keyed by BgpPeer name.
+func resolveNeighborInterfaces(bgpPeers []bgpapi.BgpPeer) map[string]string {
+	ifaces := make(map[string]string)
+	for _, bgpPeer := range bgpPeers {
+		if !bgpPeer.IsUnnumbered() {
+			continue
+		}
+
+		iface, err := speaker.ParseInterface(bgpPeer.Spec.Conf.NeighborInterface)
+		if err != nil {
+			klog.V(4).Infof("BgpPeer %s has no neighbor interface on this node: %v", bgpPeer.Name, err)
+			continue
+		}
+		ifaces[bgpPeer.Name] = iface.Name
+	}
+
+	return ifaces
+}
@@ -0,0 +1,183 @@
+package bgp
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	bgpapi "github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/speaker"
+	api "github.com/osrg/gobgp/api"
+	bgppacket "github.com/osrg/gobgp/pkg/packet/bgp"
+	"golang.org/x/net/context"
+	"k8s.io/klog/v2"
+)
+
+// pathTable is the table the paths of an ip are advertised in, the global
+// table or a vrf, whose paths are kept in the global table as VPN paths
+// prefixed with the route distinguisher.
+type pathTable struct {
+	vrf string
+	rd  string
+}
+
+func (t pathTable) String() string {
+	if t.vrf == "" {
+		return "global"
+	}
+	return "vrf " + t.vrf
+}
+
+// HandleBgpVrf adds, updates or deletes the vrf in gobgp. gobgp cannot
+// update a vrf, so it is recreated with the paths advertised in it if the
+// route distinguisher or route targets change.
+func (b *Bgp) HandleBgpVrf(vrf *bgpapi.BgpVrf, del bool) error {
+	ctx := context.Background()
+
+	var current *api.Vrf
+	err := b.bgpServer.ListVrf(ctx, &api.ListVrfRequest{Name: vrf.Name}, func(v *api.Vrf) {
+		if v.Name == vrf.Name {
+			current = v
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if del {
+		b.lock.Lock()
+		delete(b.vrfs, vrf.Name)
+		b.lock.Unlock()
+
+		if current == nil {
+			return nil
+		}
+		return b.bgpServer.DeleteVrf(ctx, &api.DeleteVrfRequest{Name: vrf.Name})
+	}
+
+	request, err := vrf.ToGoBgpVrf()
+	if err != nil {
+		return err
+	}
+	rd, _ := bgppacket.ParseRouteDistinguisher(vrf.Spec.Rd)
+
+	if current != nil && proto.Equal(current, request) {
+		b.lock.Lock()
+		b.vrfs[vrf.Name] = rd.String()
+		b.lock.Unlock()
+		return nil
+	}
+
+	var paths []*api.Path
+	if current != nil {
+		paths, err = b.vrfPaths(vrf.Name)
+		if err != nil {
+			return err
+		}
+
+		klog.Infof("recreate bgp vrf %s with %d paths", vrf.Name, len(paths))
+		err = b.bgpServer.DeleteVrf(ctx, &api.DeleteVrfRequest{Name: vrf.Name})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = b.bgpServer.AddVrf(ctx, &api.AddVrfRequest{Vrf: request})
+	if err != nil {
+		return err
+	}
+
+	b.lock.Lock()
+	b.vrfs[vrf.Name] = rd.String()
+	b.lock.Unlock()
+
+	for _, path := range paths {
+		_, err = b.bgpServer.AddPath(ctx, &api.AddPathRequest{VrfId: vrf.Name, Path: path})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// vrfPaths returns the paths advertised in the vrf, converted back to the
+// unicast paths they were added as.
+func (b *Bgp) vrfPaths(name string) ([]*api.Path, error) {
+	b.lock.RLock()
+	rd, ok := b.vrfs[name]
+	b.lock.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+
+	var paths []*api.Path
+	for _, family := range []*api.Family{getVPNFamily("0.0.0.0"), getVPNFamily("::")} {
+		err := b.bgpServer.ListPath(context.Background(), &api.ListPathRequest{
+			TableType: api.TableType_GLOBAL,
+			Family:    family,
+		}, func(d *api.Destination) {
+			if !strings.HasPrefix(d.Prefix, rd+":") {
+				return
+			}
+			ip, prefix := parsePrefix(strings.TrimPrefix(d.Prefix, rd+":"))
+			for _, path := range d.Paths {
+				nexthop := fromAPIPath(path).String()
+				paths = append(paths, toAPIPath(ip, prefix, nexthop, pathAttrsFromAPIPath(path)))
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+func getVPNFamily(ip string) *api.Family {
+	family := getFamily(ip)
+	family.Safi = api.Family_SAFI_MPLS_VPN
+	return family
+}
+
+// ConfigureWithEIP records the vrf the paths of the eip are advertised in.
+func (b *Bgp) ConfigureWithEIP(config speaker.Config, deleted bool) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if deleted || config.Vrf == "" {
+		delete(b.eips, config.Name)
+		return nil
+	}
+
+	b.eips[config.Name] = config
+	return nil
+}
+
+// getPathTable returns the table the paths of the ip are advertised in.
+func (b *Bgp) getPathTable(ip string) (pathTable, error) {
+	addr, prefix := parsePrefix(ip)
+	_, ipNet, err := net.ParseCIDR(fmt.Sprintf("%s/%d", addr, prefix))
+	if err != nil {
+		return pathTable{}, err
+	}
+
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	for _, config := range b.eips {
+		// aggregated prefixes may start before the range
+		if config.IPRange == nil || !(config.IPRange.Contains(net.ParseIP(addr)) || ipNet.Contains(config.IPRange.Start())) {
+			continue
+		}
+
+		rd, ok := b.vrfs[config.Vrf]
+		if !ok {
+			return pathTable{}, fmt.Errorf("bgp vrf %s of eip %s is not configured", config.Vrf, config.Name)
+		}
+		return pathTable{vrf: config.Vrf, rd: rd}, nil
+	}
+
+	return pathTable{}, nil
+}
@@ -96,15 +96,14 @@ func (r *BgpConfReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 	rack := ""
 	if node.Labels != nil && node.Labels[constant.OpenELBNodeRack] != "" && clone.Spec.AsPerRack != nil {
 		rack = node.Labels[constant.OpenELBNodeRack]
-		as := clone.Spec.AsPerRack[rack]
-		if as > 0 {
-			clone.Spec.As = as
-		}
-		clone.Spec.RouterId = ""
+		clone.Spec.As = clone.Spec.NodeAs(node)
 	}
-	if clone.Spec.RouterId == "" {
-		clone.Spec.RouterId = util.GetNodeIP(*node).String()
+	routerId, err := clone.Spec.NodeRouterId(node)
+	if err != nil {
+		r.Event(instance, corev1.EventTypeWarning, "InvalidRouterId", err.Error())
+		return ctrl.Result{}, err
 	}
+	clone.Spec.RouterId = routerId
 
 	cm, err := r.getPolicyConfigMap(ctx, clone)
 	if err != nil {
@@ -125,6 +124,7 @@ func (r *BgpConfReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ct
 		return ctrl.Result{}, err
 	}
 
+	r.updateConfStatus()
 	return ctrl.Result{}, r.reconfigPeers()
 }
 
@@ -168,10 +168,27 @@ func (r *BgpConfReconciler) Map(ctx context.Context, configMap client.Object) []
 func (r *BgpConfReconciler) reconfigPeers() error {
 	ctx := context.Background()
 
+	//The vrfs were reset with the global configuration too, add them back
+	//before the neighbors bound to them.
+	var vrfs v1alpha2.BgpVrfList
+	err := r.List(ctx, &vrfs)
+	if err != nil {
+		return err
+	}
+	for _, vrf := range vrfs.Items {
+		if vrf.DeletionTimestamp != nil {
+			continue
+		}
+		err = r.BgpServer.HandleBgpVrf(&vrf, false)
+		if err != nil {
+			return err
+		}
+	}
+
 	//Add all the neighbor that exist and match node back in, since
 	//the neighbor was reset when the global configuration was updated earlier.
 	var peers v1alpha2.BgpPeerList
-	err := r.List(ctx, &peers)
+	err = r.List(ctx, &peers)
 	if err != nil {
 		return err
 	}
@@ -193,7 +210,7 @@ func (r *BgpConfReconciler) reconfigPeers() error {
 		}
 	}
 
-	return nil
+	return reconcileNodeMesh(ctx, r.Client, r.BgpServer, node)
 }
 
 func shouldReconcile(obj runtime.Object) bool {
@@ -272,13 +289,14 @@ func (r *BgpConfReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			}
 			newHaveLabel := false
 			if new.Labels != nil {
-				_, newHaveLabel = old.Labels[constant.OpenELBNodeRack]
+				_, newHaveLabel = new.Labels[constant.OpenELBNodeRack]
 			}
 			if oldHaveLabel != newHaveLabel {
 				return true
 			}
 
-			return false
+			// the router id may be derived from the node annotation
+			return old.Annotations[constant.OpenELBNodeRouterId] != new.Annotations[constant.OpenELBNodeRouterId]
 		},
 		CreateFunc: func(e event.CreateEvent) bool {
 			return false
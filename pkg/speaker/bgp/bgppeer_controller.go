@@ -19,26 +19,44 @@ package bgp
 import (
 	"context"
 	"fmt"
+	"math"
+	"net"
 	"reflect"
 	"time"
 
 	"github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/constant"
 	"github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/nettool"
+	"github.com/openelb/openelb/pkg/speaker"
 	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
 	"github.com/openelb/openelb/pkg/util"
+	"golang.org/x/time/rate"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const sessionStateEstablished = "ESTABLISHED"
+
+var (
+	peerStatusMinDelay = 500 * time.Millisecond
+	peerStatusMaxDelay = time.Minute
 )
 
 // BgpPeerReconciler reconciles a BgpPeer object
@@ -46,6 +64,14 @@ type BgpPeerReconciler struct {
 	client.Client
 	BgpServer *bgpd.Bgp
 	record.EventRecorder
+	// redirects port 179 to the listen port of gobgp, nil if disabled
+	PortForwarder nettool.PortForwarder
+
+	// neighbor addresses whose status needs to be synced, "" for all
+	statusQueue workqueue.RateLimitingInterface
+	// neighbor addresses forwarded for the BgpPeers, keyed by name.
+	// Only accessed by Reconcile, which never runs concurrently.
+	forwards map[string]string
 }
 
 func peerMatchNode(peer *v1alpha2.BgpPeer, node *corev1.Node) (bool, error) {
@@ -77,7 +103,7 @@ func (r BgpPeerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 	err := r.Get(context.TODO(), req.NamespacedName, bgpPeer)
 	if err != nil {
 		if errors.IsNotFound(err) {
-			return ctrl.Result{}, nil
+			return ctrl.Result{}, r.deletePortForward(req.Name)
 		}
 		return ctrl.Result{}, err
 	}
@@ -96,6 +122,14 @@ func (r BgpPeerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		}
 	}
 
+	// interface peers only exist on the nodes having the interface
+	if matchNode && bgpPeer.IsUnnumbered() {
+		if _, err := speaker.ParseInterface(bgpPeer.Spec.Conf.NeighborInterface); err != nil {
+			klog.V(4).Infof("BgpPeer %s has no neighbor interface on this node: %v", bgpPeer.Name, err)
+			matchNode = false
+		}
+	}
+
 	clone := bgpPeer.DeepCopy()
 
 	if util.IsDeletionCandidate(clone, constant.FinalizerName) {
@@ -103,6 +137,9 @@ func (r BgpPeerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		if err != nil {
 			klog.Error(err, "cannot delete bgp peer, maybe need to delete manually")
 		}
+		if err := r.deletePortForward(clone.Name); err != nil {
+			return ctrl.Result{}, err
+		}
 
 		controllerutil.RemoveFinalizer(clone, constant.FinalizerName)
 		return ctrl.Result{}, r.Update(context.Background(), clone)
@@ -110,77 +147,292 @@ func (r BgpPeerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 
 	if util.NeedToAddFinalizer(clone, constant.FinalizerName) {
 		controllerutil.AddFinalizer(clone, constant.FinalizerName)
-		metrics.InitBGPPeerMetrics(clone.Spec.Conf.NeighborAddress, util.GetNodeName())
+		if clone.Spec.Conf.NeighborAddress != "" {
+			metrics.InitBGPPeerMetrics(clone.Spec.Conf.NeighborAddress, util.GetNodeName())
+		}
 		err := r.Update(context.Background(), clone)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
 	}
 
+	if err := r.forwardPort(clone, !matchNode); err != nil {
+		r.Eventf(clone, corev1.EventTypeWarning, "PortForwardFailed",
+			"failed to forward bgp port on node %s: %v", util.GetNodeName(), err)
+		return ctrl.Result{}, err
+	}
+
 	return ctrl.Result{}, r.BgpServer.HandleBgpPeer(clone, !matchNode)
 }
 
+// forwardPort redirects the sessions the neighbor opens to port 179 of the
+// node to the port gobgp listens on, if that is another one.
+func (r BgpPeerReconciler) forwardPort(peer *v1alpha2.BgpPeer, remove bool) error {
+	if r.PortForwarder == nil {
+		return nil
+	}
+
+	router := peer.Spec.Conf.NeighborAddress
+	if old, ok := r.forwards[peer.Name]; ok && old != router {
+		if err := r.deletePortForward(peer.Name); err != nil {
+			return err
+		}
+	}
+
+	// dynamic and interface peers have no fixed address to match
+	if router == "" {
+		return nil
+	}
+
+	var port int32
+	if !remove {
+		conf := &v1alpha2.BgpConf{}
+		err := r.Get(context.Background(), types.NamespacedName{Name: v1alpha2.DefaultBgpConfName}, conf)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		port = conf.Spec.ListenPort
+	}
+	if port <= 0 || fmt.Sprint(port) == nettool.BGPPort {
+		return r.deletePortForward(peer.Name)
+	}
+
+	node := &corev1.Node{}
+	err := r.Get(context.Background(), types.NamespacedName{Name: util.GetNodeName()}, node)
+	if err != nil {
+		return err
+	}
+	local := nodeIPOfFamily(node, net.ParseIP(router).To4() != nil)
+	if local == nil {
+		return fmt.Errorf("node %s has no internal ip of the family of %s", node.Name, router)
+	}
+
+	if err := r.PortForwarder.AddPortForward(router, local.String(), port); err != nil {
+		return err
+	}
+	r.forwards[peer.Name] = router
+	klog.V(4).Infof("forwarding bgp port of %s to %s:%d", router, local, port)
+	return nil
+}
+
+func (r BgpPeerReconciler) deletePortForward(name string) error {
+	router, ok := r.forwards[name]
+	if !ok || r.PortForwarder == nil {
+		return nil
+	}
+	if err := r.PortForwarder.DeletePortForward(router); err != nil {
+		return err
+	}
+	delete(r.forwards, name)
+	return nil
+}
+
+func nodeIPOfFamily(node *corev1.Node, ipv4 bool) net.IP {
+	for _, address := range node.Status.Addresses {
+		if address.Type != corev1.NodeInternalIP {
+			continue
+		}
+		ip := net.ParseIP(address.Address)
+		if ip != nil && (ip.To4() != nil) == ipv4 {
+			return ip
+		}
+	}
+	return nil
+}
+
 func (r BgpPeerReconciler) Start(ctx context.Context) error {
 	err := r.CleanBgpPeerStatus()
 	if err != nil {
 		return err
 	}
 
-	go r.run(ctx)
+	// runs until the context is done, so that the forwards are cleaned up
+	// before the manager stops
+	r.run(ctx)
+
+	if r.PortForwarder != nil {
+		if err := r.PortForwarder.Cleanup(); err != nil {
+			return fmt.Errorf("failed to clean up bgp port forwards: %v", err)
+		}
+	}
 
 	return nil
 }
 
-func (r BgpPeerReconciler) updatePeerStatus() {
+// updatePeerStatus syncs the status of the BgpPeers with the given neighbor
+// address, or of all BgpPeers if address is empty, from gobgp. It reports
+// whether the session state of any of them changed on this node.
+func (r BgpPeerReconciler) updatePeerStatus(address string) (bool, error) {
 	peers := &v1alpha2.BgpPeerList{}
 	err := r.List(context.Background(), peers)
 	if err != nil {
-		return
+		return false, err
 	}
 
 	status := r.BgpServer.HandleBgpPeerStatus(peers.Items)
+	nodeName := util.GetNodeName()
+	changed := false
+	var errs []error
 
 	//update status
 	for _, peer := range peers.Items {
+		// interface peers are only known by the resolved link-local address
+		if address != "" && !peer.HasNeighbor(address) && !peer.IsUnnumbered() {
+			continue
+		}
+
 		clone := peer.DeepCopy()
 		found := false
 
 		for _, tmp := range status {
-			if clone.Spec.Conf.NeighborAddress == tmp.Spec.Conf.NeighborAddress {
+			if clone.Name == tmp.Name {
 				clone.Status = tmp.Status
 				found = true
 				break
 			}
 		}
 		if !found {
-			delete(clone.Status.NodesPeerStatus, util.GetNodeName())
+			delete(clone.Status.NodesPeerStatus, nodeName)
+			delete(clone.Status.NodesDynamicPeerStatus, nodeName)
+			clone.Status.SetNodeRack(nodeName, "")
+			if peer.Spec.Conf.NeighborAddress != "" {
+				r.BgpServer.UpdatePeerMetrics(&peer, true)
+			}
+		}
+
+		oldStates := sessionStates(&peer, nodeName)
+		newStates := sessionStates(clone, nodeName)
+		for neighbor, newState := range newStates {
+			if oldState := oldStates[neighbor]; oldState != newState {
+				changed = true
+				r.recordSessionState(clone, neighbor, oldState, newState)
+			}
+			r.BgpServer.UpdateNeighborMetrics(neighbor, false)
+		}
+		for neighbor, oldState := range oldStates {
+			if _, ok := newStates[neighbor]; !ok {
+				changed = true
+				r.recordSessionState(clone, neighbor, oldState, "")
+				r.BgpServer.UpdateNeighborMetrics(neighbor, true)
+			}
 		}
 
 		if !reflect.DeepEqual(clone.Status, peer.Status) {
-			r.Status().Update(context.Background(), clone)
+			err = r.Status().Update(context.Background(), clone)
+			if err != nil && !errors.IsNotFound(err) {
+				// the other peers are still synced
+				klog.Errorf("failed to update status of bgp peer %s: %v", clone.Name, err)
+				errs = append(errs, err)
+			}
 		}
-		r.BgpServer.UpdatePeerMetrics(&peer, !found)
 	}
+
+	return changed, utilerrors.NewAggregate(errs)
+}
+
+// sessionStates returns the session state of the peer and of the dynamic
+// neighbors accepted for it on the node, keyed by neighbor address.
+func sessionStates(peer *v1alpha2.BgpPeer, nodeName string) map[string]string {
+	states := make(map[string]string)
+	if status, ok := peer.Status.NodesPeerStatus[nodeName]; ok {
+		states[status.PeerState.NeighborAddress] = status.PeerState.SessionState
+	}
+	for _, status := range peer.Status.NodesDynamicPeerStatus[nodeName] {
+		states[status.PeerState.NeighborAddress] = status.PeerState.SessionState
+	}
+
+	return states
 }
 
+func (r BgpPeerReconciler) recordSessionState(peer *v1alpha2.BgpPeer, neighbor, oldState, newState string) {
+	nodeName := util.GetNodeName()
+	if newState == "" {
+		r.Eventf(peer, corev1.EventTypeNormal, "SessionRemoved",
+			"bgp session with %s removed from node %s", neighbor, nodeName)
+		return
+	}
+
+	eventType := corev1.EventTypeNormal
+	if oldState == sessionStateEstablished {
+		eventType = corev1.EventTypeWarning
+	}
+	if oldState == "" {
+		oldState = "NONE"
+	}
+	r.Eventf(peer, eventType, "SessionStateChanged", "bgp session with %s on node %s changed from %s to %s",
+		neighbor, nodeName, oldState, newState)
+}
+
+// run watches the session state of the gobgp peers and syncs the BgpPeer
+// status on every change. Updates are rate limited per neighbor, so that a
+// flapping peer backs off instead of hammering the API server.
 func (r BgpPeerReconciler) run(ctx context.Context) {
-	t := time.NewTicker(time.Duration(syncStatusPeriod) * time.Second)
+	go func() {
+		<-ctx.Done()
+		r.statusQueue.ShutDown()
+	}()
 
+	go r.monitorPeers(ctx)
+
+	for r.processNextPeerStatus() {
+	}
+}
+
+// monitorPeers queues the neighbors whose session changed, and monitors the
+// peers again with backoff if the monitor fails.
+func (r BgpPeerReconciler) monitorPeers(ctx context.Context) {
+	backoff := wait.Backoff{
+		Duration: peerStatusMinDelay,
+		Factor:   2,
+		Jitter:   0.1,
+		Steps:    math.MaxInt32,
+		Cap:      peerStatusMaxDelay,
+	}
 	for {
-		select {
-		case <-t.C:
-			r.updatePeerStatus()
+		err := r.BgpServer.MonitorPeers(ctx, func(address string) {
+			r.statusQueue.AddRateLimited(address)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		klog.Errorf("failed to monitor bgp peers: %v", err)
 
+		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(backoff.Step()):
 		}
+		// sync the sessions that changed in the meantime
+		r.statusQueue.AddRateLimited("")
+	}
+}
+
+func (r BgpPeerReconciler) processNextPeerStatus() bool {
+	key, shutdown := r.statusQueue.Get()
+	if shutdown {
+		return false
+	}
+	defer r.statusQueue.Done(key)
+
+	changed, err := r.updatePeerStatus(key.(string))
+	switch {
+	case err != nil:
+		klog.Errorf("failed to update bgp peer status: %v", err)
+		r.statusQueue.AddRateLimited(key)
+	case changed:
+		// check again once the session had time to settle, backing
+		// off further while the peer keeps flapping.
+		r.statusQueue.AddRateLimited(key)
+	default:
+		r.statusQueue.Forget(key)
 	}
+
+	return true
 }
 
 func (r BgpPeerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&v1alpha2.BgpPeer{}).
-		WithEventFilter(predicate.Funcs{
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha2.BgpPeer{}, builder.WithPredicates(predicate.Funcs{
 			CreateFunc: func(e event.CreateEvent) bool {
 				if util.DutyOfCNI(nil, e.Object) {
 					return false
@@ -201,14 +453,57 @@ func (r BgpPeerReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 				return false
 			},
-		}).Complete(r)
+		}))
+
+	if r.PortForwarder != nil {
+		// the forwards follow the listen port of the BgpConf
+		p := predicate.Funcs{
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				return e.ObjectOld.(*v1alpha2.BgpConf).Spec.ListenPort != e.ObjectNew.(*v1alpha2.BgpConf).Spec.ListenPort
+			},
+		}
+		b = b.Watches(&v1alpha2.BgpConf{}, handler.EnqueueRequestsFromMapFunc(r.mapBgpConf), builder.WithPredicates(p))
+	}
+
+	return b.Complete(r)
 }
 
-func SetupBgpPeerReconciler(bgpServer *bgpd.Bgp, mgr ctrl.Manager) error {
+func (r BgpPeerReconciler) mapBgpConf(ctx context.Context, obj client.Object) []reconcile.Request {
+	if obj.GetName() != v1alpha2.DefaultBgpConfName {
+		return nil
+	}
+
+	peers := &v1alpha2.BgpPeerList{}
+	if err := r.List(ctx, peers); err != nil {
+		klog.Errorf("failed to list bgp peers: %v", err)
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, peer := range peers.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: peer.Name}})
+	}
+	return requests
+}
+
+func SetupBgpPeerReconciler(bgpServer *bgpd.Bgp, portForwarder nettool.PortForwarder, mgr ctrl.Manager) error {
+	// drop the forwards left behind by a speaker that did not shut down cleanly
+	if portForwarder != nil {
+		if err := portForwarder.Cleanup(); err != nil {
+			return err
+		}
+	}
+
 	bgpPeer := BgpPeerReconciler{
 		Client:        mgr.GetClient(),
 		BgpServer:     bgpServer,
 		EventRecorder: mgr.GetEventRecorderFor("bgppeer"),
+		PortForwarder: portForwarder,
+		forwards:      make(map[string]string),
+		statusQueue: workqueue.NewNamedRateLimitingQueue(workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(peerStatusMinDelay, peerStatusMaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(10), 100)},
+		), "bgppeer-status"),
 	}
 	if err := bgpPeer.SetupWithManager(mgr); err != nil {
 		return err
@@ -0,0 +1,110 @@
+/*
+Copyright 2020 The Kubesphere Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	"github.com/openelb/openelb/pkg/util"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// BgpVrfReconciler reconciles a BgpVrf object
+type BgpVrfReconciler struct {
+	client.Client
+	BgpServer *bgpd.Bgp
+}
+
+// +kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpvrfs,verbs=get;list;watch;create;update;patch;delete
+
+func (r BgpVrfReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	bgpVrf := &v1alpha2.BgpVrf{}
+	err := r.Get(ctx, req.NamespacedName, bgpVrf)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	clone := bgpVrf.DeepCopy()
+
+	if util.IsDeletionCandidate(clone, constant.FinalizerName) {
+		err := r.BgpServer.HandleBgpVrf(clone, true)
+		if err != nil {
+			klog.Errorf("cannot delete bgp vrf, maybe need to delete manually: %v", err)
+		}
+
+		controllerutil.RemoveFinalizer(clone, constant.FinalizerName)
+		return ctrl.Result{}, r.Update(context.Background(), clone)
+	}
+
+	if util.NeedToAddFinalizer(clone, constant.FinalizerName) {
+		controllerutil.AddFinalizer(clone, constant.FinalizerName)
+		err := r.Update(context.Background(), clone)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, r.BgpServer.HandleBgpVrf(clone, false)
+}
+
+func (r BgpVrfReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1alpha2.BgpVrf{}).
+		WithEventFilter(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool {
+				if util.DutyOfCNI(nil, e.Object) {
+					return false
+				}
+				return true
+			},
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldVrf := e.ObjectOld.(*v1alpha2.BgpVrf)
+				newVrf := e.ObjectNew.(*v1alpha2.BgpVrf)
+				if !util.DutyOfCNI(e.ObjectOld, e.ObjectNew) {
+					if !reflect.DeepEqual(oldVrf.DeletionTimestamp, newVrf.DeletionTimestamp) {
+						return true
+					}
+					if !reflect.DeepEqual(oldVrf.Spec, newVrf.Spec) {
+						return true
+					}
+				}
+
+				return false
+			},
+		}).Complete(r)
+}
+
+func SetupBgpVrfReconciler(bgpServer *bgpd.Bgp, mgr ctrl.Manager) error {
+	bgpVrf := BgpVrfReconciler{
+		Client:    mgr.GetClient(),
+		BgpServer: bgpServer,
+	}
+	return bgpVrf.SetupWithManager(mgr)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2020 The Kubesphere Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	"github.com/openelb/openelb/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// NodeDrainReconciler withdraws the bgp paths while the node of the speaker
+// is drained
+type NodeDrainReconciler struct {
+	client.Client
+	BgpServer *bgpd.Bgp
+}
+
+func (r NodeDrainReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	node := &corev1.Node{}
+	err := r.Get(ctx, req.NamespacedName, node)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, r.BgpServer.Drain(r.BgpServer.NodeDraining(node))
+}
+
+func (r NodeDrainReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	p := predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return e.Object.GetName() == util.GetNodeName()
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectNew.GetName() != util.GetNodeName() {
+				return false
+			}
+
+			oldNode := e.ObjectOld.(*corev1.Node)
+			newNode := e.ObjectNew.(*corev1.Node)
+			return r.BgpServer.NodeDraining(oldNode) != r.BgpServer.NodeDraining(newNode)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return false
+		},
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}, builder.WithPredicates(p)).
+		Named("BgpDrainController").
+		Complete(r)
+}
+
+func SetupNodeDrainReconciler(bgpServer *bgpd.Bgp, mgr ctrl.Manager) error {
+	drain := NodeDrainReconciler{
+		Client:    mgr.GetClient(),
+		BgpServer: bgpServer,
+	}
+	return drain.SetupWithManager(mgr)
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2020 The Kubesphere Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package bgp
+
+import (
+	"context"
+	"reflect"
+
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	"github.com/openelb/openelb/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// NodeMeshReconciler peers the speaker with the other nodes of the node
+// mesh configured in the BgpConf, as the nodes join and leave.
+type NodeMeshReconciler struct {
+	client.Client
+	BgpServer *bgpd.Bgp
+}
+
+func (r NodeMeshReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	node := &corev1.Node{}
+	err := r.Get(ctx, req.NamespacedName, node)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, reconcileNodeMesh(ctx, r.Client, r.BgpServer, node)
+}
+
+func reconcileNodeMesh(ctx context.Context, c client.Client, bgpServer *bgpd.Bgp, node *corev1.Node) error {
+	nodes := &corev1.NodeList{}
+	if err := c.List(ctx, nodes); err != nil {
+		return err
+	}
+
+	return bgpServer.HandleNodeMesh(node, nodes.Items)
+}
+
+func (r NodeMeshReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	p := predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode := e.ObjectOld.(*corev1.Node)
+			newNode := e.ObjectNew.(*corev1.Node)
+			return !reflect.DeepEqual(oldNode.Labels, newNode.Labels) ||
+				!util.GetNodeIP(*oldNode).Equal(util.GetNodeIP(*newNode))
+		},
+	}
+
+	// every node change is reconciled on the node of the speaker
+	mapNode := func(ctx context.Context, obj client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: util.GetNodeName()}}}
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("BgpNodeMeshController").
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(mapNode), builder.WithPredicates(p)).
+		Complete(r)
+}
+
+func SetupNodeMeshReconciler(bgpServer *bgpd.Bgp, mgr ctrl.Manager) error {
+	mesh := NodeMeshReconciler{
+		Client:    mgr.GetClient(),
+		BgpServer: bgpServer,
+	}
+	return mesh.SetupWithManager(mgr)
+}
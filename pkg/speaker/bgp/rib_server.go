@@ -0,0 +1,176 @@
+package bgp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	certutil "k8s.io/client-go/util/cert"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type ribSource interface {
+	GetRib(ctx context.Context) (*bgpd.Rib, error)
+}
+
+// RibServer serves the RIB of the speaker to the openelb apiserver over tls.
+// Callers authenticate with a service account token bound to the speaker
+// audience and need to be allowed to get the non-resource url of the
+// endpoint.
+type RibServer struct {
+	client.Client
+	BgpServer ribSource
+	Addr      string
+	CertFile  string
+	KeyFile   string
+}
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+func (s RibServer) authorize(r *http.Request) (int, error) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return http.StatusUnauthorized, fmt.Errorf("missing bearer token")
+	}
+
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{
+		Token:     token,
+		Audiences: []string{constant.OpenELBSpeakerRibAudience},
+	}}
+	if err := s.Create(r.Context(), review); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !review.Status.Authenticated {
+		return http.StatusUnauthorized, fmt.Errorf("invalid bearer token: %s", review.Status.Error)
+	}
+	// tokens of other audiences, like the default service account tokens,
+	// are rejected
+	if !slices.Contains(review.Status.Audiences, constant.OpenELBSpeakerRibAudience) {
+		return http.StatusUnauthorized, fmt.Errorf("bearer token is not bound to the audience %s", constant.OpenELBSpeakerRibAudience)
+	}
+
+	user := review.Status.User
+	extra := make(map[string]authorizationv1.ExtraValue)
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	access := &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+			NonResourceAttributes: &authorizationv1.NonResourceAttributes{
+				Path: constant.OpenELBSpeakerRibPath,
+				Verb: "get",
+			},
+		},
+	}
+	if err := s.Create(r.Context(), access); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	if !access.Status.Allowed {
+		return http.StatusForbidden, fmt.Errorf("%s is not allowed to get %s", user.Username, constant.OpenELBSpeakerRibPath)
+	}
+
+	return http.StatusOK, nil
+}
+
+func (s RibServer) serveRib(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if code, err := s.authorize(r); err != nil {
+		klog.V(4).Infof("rejected rib request from %s: %v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), code)
+		return
+	}
+
+	rib, err := s.BgpServer.GetRib(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(rib); err != nil {
+		klog.Errorf("failed to write rib: %v", err)
+	}
+}
+
+// tlsConfig returns the configured certificate, or a self-signed one.
+func (s RibServer) tlsConfig() (*tls.Config, error) {
+	var cert tls.Certificate
+	var err error
+	if s.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	} else {
+		var certPEM, keyPEM []byte
+		certPEM, keyPEM, err = certutil.GenerateSelfSignedCertKey(constant.OpenELBSpeakerName, nil, nil)
+		if err == nil {
+			cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}, nil
+}
+
+func (s RibServer) Start(ctx context.Context) error {
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		return fmt.Errorf("rib server certificate: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(constant.OpenELBSpeakerRibPath, s.serveRib)
+	server := &http.Server{
+		Addr:              s.Addr,
+		Handler:           mux,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	klog.Infof("serving bgp rib on %s", s.Addr)
+	if err := server.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// SetupRibServer serves the rib on the rib address of the options, an empty
+// address disables it.
+func SetupRibServer(bgpServer *bgpd.Bgp, opt *bgpd.BgpOptions, mgr ctrl.Manager) error {
+	if opt.RibAddr == "" {
+		return nil
+	}
+
+	return mgr.Add(RibServer{
+		Client:    mgr.GetClient(),
+		BgpServer: bgpServer,
+		Addr:      opt.RibAddr,
+		CertFile:  opt.RibCertFile,
+		KeyFile:   opt.RibKeyFile,
+	})
+}
@@ -0,0 +1,133 @@
+package bgp
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/openelb/openelb/pkg/constant"
+	bgpd "github.com/openelb/openelb/pkg/speaker/bgp/bgp"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+type fakeRibSource struct {
+	rib *bgpd.Rib
+}
+
+func (f fakeRibSource) GetRib(ctx context.Context) (*bgpd.Rib, error) {
+	return f.rib, nil
+}
+
+const ribReader = "system:serviceaccount:openelb-system:openelb-apiserver"
+
+// fakeReviewClient authenticates the token "apiserver" as the apiserver for
+// the speaker audience, and the token "default" without an audience. Only
+// the apiserver is allowed to get the rib.
+func fakeReviewClient() client.Client {
+	return fake.NewClientBuilder().WithInterceptorFuncs(interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			switch review := obj.(type) {
+			case *authenticationv1.TokenReview:
+				switch review.Spec.Token {
+				case "apiserver":
+					review.Status.Authenticated = true
+					review.Status.Audiences = review.Spec.Audiences
+					review.Status.User = authenticationv1.UserInfo{Username: ribReader}
+				case "default":
+					review.Status.Authenticated = true
+					review.Status.User = authenticationv1.UserInfo{Username: ribReader}
+				case "other":
+					review.Status.Authenticated = true
+					review.Status.Audiences = review.Spec.Audiences
+					review.Status.User = authenticationv1.UserInfo{Username: "system:serviceaccount:default:other"}
+				default:
+					review.Status.Error = "invalid token"
+				}
+			case *authorizationv1.SubjectAccessReview:
+				attrs := review.Spec.NonResourceAttributes
+				review.Status.Allowed = review.Spec.User == ribReader && attrs != nil &&
+					attrs.Path == constant.OpenELBSpeakerRibPath && attrs.Verb == "get"
+			}
+			return nil
+		},
+	}).Build()
+}
+
+func TestRibServer(t *testing.T) {
+	rib := &bgpd.Rib{Global: []bgpd.RibRoute{{Prefix: "10.0.0.1/32", NextHop: "192.168.0.10", Best: true}}}
+	s := RibServer{Client: fakeReviewClient(), BgpServer: fakeRibSource{rib: rib}}
+
+	tests := []struct {
+		name  string
+		token string
+		code  int
+	}{
+		{name: "authorized", token: "apiserver", code: http.StatusOK},
+		{name: "unauthenticated", code: http.StatusUnauthorized},
+		{name: "invalid token", token: "invalid", code: http.StatusUnauthorized},
+		{name: "token of another audience", token: "default", code: http.StatusUnauthorized},
+		{name: "forbidden", token: "other", code: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, constant.OpenELBSpeakerRibPath, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			s.serveRib(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.code, w.Body.String())
+			}
+			if tt.code != http.StatusOK {
+				return
+			}
+
+			got := &bgpd.Rib{}
+			if err := json.NewDecoder(w.Body).Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Global, rib.Global) {
+				t.Errorf("rib = %+v, want %+v", got, rib)
+			}
+		})
+	}
+}
+
+func TestRibServerTLS(t *testing.T) {
+	s := RibServer{Client: fakeReviewClient(), BgpServer: fakeRibSource{rib: &bgpd.Rib{}}}
+	tlsConfig, err := s.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(s.serveRib))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	// the self-signed certificate is not verified by the client
+	c := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	req, _ := http.NewRequest(http.MethodGet, server.URL+constant.OpenELBSpeakerRibPath, nil)
+	req.Header.Set("Authorization", "Bearer apiserver")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.TLS == nil {
+		t.Errorf("status = %d, tls = %v", resp.StatusCode, resp.TLS != nil)
+	}
+	if err := resp.TLS.PeerCertificates[0].VerifyHostname(constant.OpenELBSpeakerName); err != nil {
+		t.Error(err)
+	}
+}
@@ -167,7 +167,7 @@ var _ = BeforeSuite(func(done Done) {
 			klog.Errorf("failed to start manager: %v", err)
 		}
 	}()
-	err = SetupBgpPeerReconciler(bgpServer, mgr)
+	err = SetupBgpPeerReconciler(bgpServer, nil, mgr)
 	Expect(err).ToNot(HaveOccurred())
 	err = SetupBgpConfReconciler(bgpServer, mgr)
 	Expect(err).ToNot(HaveOccurred())
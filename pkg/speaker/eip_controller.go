@@ -18,17 +18,24 @@ package speaker
 
 import (
 	"context"
+	"reflect"
 	"time"
 
 	"github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/util"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -39,17 +46,78 @@ type EIPReconciler struct {
 
 	Reload   chan event.GenericEvent
 	Reloader func(context.Context) error
-	Handler  func(context.Context, *v1alpha2.Eip) error
+	// announces the eips again once the nodes allowed to announce them changed
+	NodeReloader func(context.Context) error
+	Handler      func(context.Context, *v1alpha2.Eip) error
 }
 
 func (e *EIPReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	np := predicate.Funcs{
+		UpdateFunc: func(evt event.UpdateEvent) bool {
+			return e.nodeUpdated(evt.ObjectOld.(*corev1.Node), evt.ObjectNew.(*corev1.Node))
+		},
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&v1alpha2.Eip{}).
 		WatchesRawSource(&source.Channel{Source: e.Reload}, &handler.EnqueueRequestForObject{}).
+		Watches(&corev1.Node{}, handler.EnqueueRequestsFromMapFunc(e.mapNode), builder.WithPredicates(np)).
 		Named("EIPController").
 		Complete(e)
 }
 
+// nodeUpdated reports whether the node change affects the announcements.
+func (e *EIPReconciler) nodeUpdated(old, new *corev1.Node) bool {
+	if util.NodeExcluded(old) != util.NodeExcluded(new) ||
+		util.NodeAnnounceable(old) != util.NodeAnnounceable(new) {
+		return true
+	}
+	if reflect.DeepEqual(old.Labels, new.Labels) {
+		return false
+	}
+
+	// the labels of this node may change the interfaces of the eips
+	if new.Name == util.GetNodeName() {
+		return true
+	}
+	return e.electionChanged(old, new)
+}
+
+// electionChanged reports whether the labels ranking the node in the layer2
+// elections changed, the priority label or the match of a node selector.
+func (e *EIPReconciler) electionChanged(old, new *corev1.Node) bool {
+	if old.Labels[constant.OpenELBNodeLayer2Priority] != new.Labels[constant.OpenELBNodeLayer2Priority] {
+		return true
+	}
+
+	eips := &v1alpha2.EipList{}
+	if err := e.Client.List(context.Background(), eips); err != nil {
+		klog.Warningf("list eips error: %v", err)
+		return true
+	}
+	for _, eip := range eips.Items {
+		election := eip.Spec.Layer2Election
+		if eip.GetProtocol() != constant.OpenELBProtocolLayer2 || election == nil || election.NodeSelector == nil {
+			continue
+		}
+		oldPreferred, _ := election.NodePreferred(old)
+		newPreferred, _ := election.NodePreferred(new)
+		if oldPreferred != newPreferred {
+			return true
+		}
+	}
+	return false
+}
+
+// mapNode enqueues a single request for all node changes, the eips are
+// announced again at once.
+func (e *EIPReconciler) mapNode(ctx context.Context, obj client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{
+		Name:      constant.NodeReloadEIPName,
+		Namespace: constant.NodeReloadEIPNamespace,
+	}}}
+}
+
 //+kubebuilder:rbac:groups=network.kubesphere.io,resources=eips,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=network.kubesphere.io,resources=eips/status,verbs=get;update;patch
 
@@ -67,6 +135,13 @@ func (e *EIPReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.R
 		return ctrl.Result{}, e.Reloader(ctx)
 	}
 
+	if req.Name == constant.NodeReloadEIPName && req.Namespace == constant.NodeReloadEIPNamespace {
+		if e.NodeReloader == nil {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, e.NodeReloader(ctx)
+	}
+
 	eip := &v1alpha2.Eip{}
 	if err := e.Client.Get(ctx, req.NamespacedName, eip); err != nil {
 		if errors.IsNotFound(err) {
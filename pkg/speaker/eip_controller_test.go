@@ -0,0 +1,58 @@
+package speaker
+
+import (
+	"testing"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNodeUpdated(t *testing.T) {
+	t.Setenv(constant.EnvNodeName, "node1")
+
+	scheme := runtime.NewScheme()
+	_ = v1alpha2.AddToScheme(scheme)
+	e := &EIPReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		&v1alpha2.Eip{
+			ObjectMeta: metav1.ObjectMeta{Name: "eip"},
+			Spec: v1alpha2.EipSpec{
+				Protocol: constant.OpenELBProtocolLayer2,
+				Layer2Election: &v1alpha2.Layer2Election{
+					NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"edge": "true"}},
+				},
+			},
+		},
+	).Build()}
+
+	node := func(name string, labels map[string]string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	}
+	drained := node("node2", nil)
+	drained.Annotations = map[string]string{constant.OpenELBNodeDrain: "true"}
+
+	tests := []struct {
+		name     string
+		old, new *corev1.Node
+		want     bool
+	}{
+		{"unchanged", node("node2", map[string]string{"edge": "true"}), node("node2", map[string]string{"edge": "true"}), false},
+		{"label of this node", node("node1", nil), node("node1", map[string]string{"rack": "a"}), true},
+		{"unrelated label of another node", node("node2", nil), node("node2", map[string]string{"rack": "a"}), false},
+		{"priority of another node", node("node2", nil), node("node2", map[string]string{constant.OpenELBNodeLayer2Priority: "10"}), true},
+		{"selected label of another node", node("node2", map[string]string{"edge": "false"}), node("node2", map[string]string{"edge": "true"}), true},
+		{"excluded node", node("node2", nil), node("node2", map[string]string{constant.KubernetesExcludeLBLabel: ""}), true},
+		{"drained node", node("node2", nil), drained, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := e.nodeUpdated(tt.old, tt.new); got != tt.want {
+				t.Errorf("nodeUpdated() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,14 +1,45 @@
 package speaker
 
 import (
+	"net"
+	"time"
+
+	"github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/util/iprange"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
 type Config struct {
 	Name    string
+	UID     types.UID
 	IPRange iprange.Range
 	Iface   string
+	// bgp vrf the paths are advertised in
+	Vrf string
+	// gratuitous packets of the layer2 speaker
+	Announce AnnounceConfig
+	// node preference of the layer2 speaker
+	Layer2Election *v1alpha2.Layer2Election
+	// used addresses of the eip, sorted
+	Used []string
+	// vlan sub-interface of Iface the layer2 speaker announces on, 0 for Iface
+	VlanID int
+	// address of this node the layer2 speaker assigns to the interface and
+	// sends the NDP packets from, the interface needs no address in IPRange then
+	SourceAddress net.IP
+}
+
+// AnnounceConfig configures the gratuitous packets sent for an announced ip.
+type AnnounceConfig struct {
+	// packets sent per burst
+	BurstCount int
+	// interval between the packets of a burst
+	BurstInterval time.Duration
+	// interval the bursts are repeated at, 0 sends a single burst
+	RefreshInterval time.Duration
+	// handling of other hosts claiming an announced ip
+	ConflictPolicy string
 }
 
 type Speaker interface {
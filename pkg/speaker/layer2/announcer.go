@@ -2,8 +2,11 @@ package layer2
 
 import (
 	"net"
+	"sync"
 
+	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/openelb/openelb/pkg/util/iprange"
+	"k8s.io/klog/v2"
 )
 
 type Announcer interface {
@@ -12,14 +15,81 @@ type Announcer interface {
 	Start() error
 	Stop() error
 	ContainsIP(net.IP) bool
-	RegisterIPRange(string, iprange.Range)
+	// IsAnnounced reports whether the ip is announced on the interface
+	IsAnnounced(net.IP) bool
+	// Reassert sends the gratuitous packets of an announced ip again
+	Reassert(net.IP) error
+	RegisterIPRange(string, iprange.Range, speaker.AnnounceConfig)
 	UnregisterIPRange(string)
 	Size() int
 }
 
-func newAnnouncer(iface *net.Interface, family iprange.Family) (Announcer, error) {
+// conflictHandler is called when a packet of another host claims an
+// announced ip.
+type conflictHandler func(ip net.IP, mac net.HardwareAddr)
+
+// size of the conflict queue of an announcer
+const conflictQueueSize = 16
+
+type conflictEvent struct {
+	ip  net.IP
+	mac net.HardwareAddr
+}
+
+// conflictQueue passes the conflicts detected by an announcer to the handler
+// in a single goroutine, the conflicts detected while it is full are dropped.
+type conflictQueue struct {
+	handler conflictHandler
+	events  chan conflictEvent
+	stopCh  chan struct{}
+	once    sync.Once
+}
+
+func newConflictQueue(handler conflictHandler) *conflictQueue {
+	return &conflictQueue{
+		handler: handler,
+		events:  make(chan conflictEvent, conflictQueueSize),
+		stopCh:  make(chan struct{}),
+	}
+}
+
+func (q *conflictQueue) start() {
+	if q.handler == nil {
+		return
+	}
+	go func() {
+		for {
+			select {
+			case <-q.stopCh:
+				return
+			case e := <-q.events:
+				q.handler(e.ip, e.mac)
+			}
+		}
+	}()
+}
+
+func (q *conflictQueue) stop() {
+	q.once.Do(func() { close(q.stopCh) })
+}
+
+// add queues the conflict, the packet buffers are copied.
+func (q *conflictQueue) add(ip net.IP, mac net.HardwareAddr) {
+	if q.handler == nil {
+		return
+	}
+	select {
+	case q.events <- conflictEvent{ip: append(net.IP(nil), ip...), mac: append(net.HardwareAddr(nil), mac...)}:
+	default:
+		klog.V(4).Infof("conflict queue full, drop the conflict of %s from %s", ip, mac)
+	}
+}
+
+// newAnnouncer returns the announcer of the interface, the NDP announcer
+// sends from source if set and from the link-local address otherwise.
+func newAnnouncer(iface *net.Interface, family iprange.Family, source net.IP, conflicts conflictHandler) (Announcer, error) {
 	if family == iprange.V4Family {
-		return newARPAnnouncer(iface)
+		return newARPAnnouncer(iface, conflicts)
 	}
-	return newNDPAnnouncer(iface)
+	return newNDPAnnouncer(iface, source, conflicts)
 }
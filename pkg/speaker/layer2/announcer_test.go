@@ -0,0 +1,45 @@
+package layer2
+
+import (
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestConflictQueue(t *testing.T) {
+	var lock sync.Mutex
+	handled := 0
+	entered, block := make(chan struct{}, 1), make(chan struct{})
+	q := newConflictQueue(func(ip net.IP, mac net.HardwareAddr) {
+		select {
+		case entered <- struct{}{}:
+		default:
+		}
+		<-block
+		lock.Lock()
+		defer lock.Unlock()
+		handled++
+	})
+	q.start()
+	defer q.stop()
+
+	ip := net.ParseIP("192.168.0.10")
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	// one conflict in the handler, the queue full, the rest dropped
+	q.add(ip, mac)
+	<-entered
+	for i := 0; i < 10*conflictQueueSize; i++ {
+		q.add(ip, mac)
+	}
+	close(block)
+
+	want := conflictQueueSize + 1
+	err := wait(func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return handled == want
+	})
+	if err != nil {
+		t.Errorf("handled %d conflicts, want %d", handled, want)
+	}
+}
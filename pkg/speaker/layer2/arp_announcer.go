@@ -1,6 +1,7 @@
 package layer2
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"net"
@@ -10,6 +11,7 @@ import (
 	"github.com/mdlayher/ethernet"
 	"github.com/mdlayher/raw"
 	"github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/openelb/openelb/pkg/util/iprange"
 	"github.com/vishvananda/netlink"
 	"k8s.io/klog/v2"
@@ -33,34 +35,50 @@ type arpAnnouncer struct {
 	lock     sync.RWMutex
 	ip2mac   map[string]net.HardwareAddr
 	ipranges map[string]iprange.Range
+	configs  map[string]speaker.AnnounceConfig
+
+	scheduler *gratuitousScheduler
+	conflicts *conflictQueue
 }
 
-func (a *arpAnnouncer) RegisterIPRange(name string, r iprange.Range) {
+func (a *arpAnnouncer) RegisterIPRange(name string, r iprange.Range, config speaker.AnnounceConfig) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	a.ipranges[name] = r
+	a.configs[name] = config
 }
 
 func (a *arpAnnouncer) UnregisterIPRange(name string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
 	r, exist := a.ipranges[name]
 	if !exist {
 		return
 	}
 
-	a.lock.RLock()
-	defer a.lock.RUnlock()
-
 	for ip := range a.ip2mac {
 		if r.Contains(net.ParseIP(ip)) {
+			a.scheduler.stop(ip)
 			delete(a.ip2mac, ip)
 		}
 	}
 	delete(a.ipranges, name)
+	delete(a.configs, name)
 }
 
 func (a *arpAnnouncer) Size() int {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
 	return len(a.ipranges)
 }
 
 func (a *arpAnnouncer) ContainsIP(ip net.IP) bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
 	for _, r := range a.ipranges {
 		if r.Contains(ip) {
 			return true
@@ -69,6 +87,22 @@ func (a *arpAnnouncer) ContainsIP(ip net.IP) bool {
 	return false
 }
 
+func (a *arpAnnouncer) announceConfig(ip net.IP) speaker.AnnounceConfig {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	for name, r := range a.ipranges {
+		if r.Contains(ip) {
+			return a.configs[name]
+		}
+	}
+	return speaker.AnnounceConfig{BurstCount: 1}
+}
+
+func (a *arpAnnouncer) IsAnnounced(ip net.IP) bool {
+	return a.getMac(ip.String()) != nil
+}
+
 func (a *arpAnnouncer) getMac(ip string) *net.HardwareAddr {
 	a.lock.RLock()
 	defer a.lock.RUnlock()
@@ -91,7 +125,7 @@ func (a *arpAnnouncer) setMac(ip string, mac net.HardwareAddr) {
 	a.ip2mac[ip] = mac
 }
 
-func newARPAnnouncer(ifi *net.Interface) (*arpAnnouncer, error) {
+func newARPAnnouncer(ifi *net.Interface, conflicts conflictHandler) (*arpAnnouncer, error) {
 	p, err := raw.ListenPacket(ifi, protocolARP, nil)
 	if err != nil {
 		return nil, err
@@ -104,14 +138,17 @@ func newARPAnnouncer(ifi *net.Interface) (*arpAnnouncer, error) {
 	link, _ := netlink.LinkByIndex(ifi.Index)
 	addrs, _ := netlink.AddrList(link, netlink.FAMILY_V4)
 	ret := &arpAnnouncer{
-		intf:     ifi,
-		addrs:    addrs,
-		conn:     client,
-		p:        p,
-		stopCh:   make(chan struct{}),
-		ip2mac:   make(map[string]net.HardwareAddr),
-		ipranges: make(map[string]iprange.Range),
+		intf:      ifi,
+		addrs:     addrs,
+		conn:      client,
+		p:         p,
+		stopCh:    make(chan struct{}),
+		ip2mac:    make(map[string]net.HardwareAddr),
+		ipranges:  make(map[string]iprange.Range),
+		configs:   make(map[string]speaker.AnnounceConfig),
+		conflicts: newConflictQueue(conflicts),
 	}
+	ret.scheduler = newGratuitousScheduler(ret.gratuitous)
 
 	return ret, nil
 }
@@ -143,15 +180,10 @@ func generateArp(intfHW net.HardwareAddr, op arp.Operation, srcHW net.HardwareAd
 	return fb, err
 }
 
+// gratuitous sends a gratuitous arp request and reply for the ip.
 func (a *arpAnnouncer) gratuitous(ip net.IP) error {
-	if a.getMac(ip.String()) != nil {
-		return nil
-	}
-
-	a.setMac(ip.String(), a.intf.HardwareAddr)
-	klog.Infof("store ingress ip related mac: %s-%s", ip.String(), a.intf.HardwareAddr.String())
 	for _, op := range []arp.Operation{arp.OperationRequest, arp.OperationReply} {
-		klog.Infof("send gratuitous arp packet: %s-%s", ip, a.intf.HardwareAddr)
+		klog.V(4).Infof("send gratuitous arp packet: %s-%s", ip, a.intf.HardwareAddr)
 
 		fb, err := generateArp(a.intf.HardwareAddr, op, a.intf.HardwareAddr, ip, ethernet.Broadcast, ip)
 		if err != nil {
@@ -163,22 +195,28 @@ func (a *arpAnnouncer) gratuitous(ip net.IP) error {
 			klog.Errorf("send gratuitous arp packet: %v", err)
 			return err
 		}
+		metrics.UpdateGratuitousSentMetrics(ip.String())
 	}
 
 	return nil
 }
 
 func (a *arpAnnouncer) AddAnnouncedIP(ip net.IP) error {
-	if err := a.gratuitous(ip); err != nil {
-		return err
+	if a.getMac(ip.String()) == nil {
+		a.setMac(ip.String(), a.intf.HardwareAddr)
+		klog.Infof("store ingress ip related mac: %s-%s", ip.String(), a.intf.HardwareAddr.String())
 	}
 
-	metrics.UpdateGratuitousSentMetrics(ip.String())
-	return nil
+	return a.scheduler.announce(ip, a.announceConfig(ip))
+}
+
+func (a *arpAnnouncer) Reassert(ip net.IP) error {
+	return a.scheduler.reassert(ip)
 }
 
 func (a *arpAnnouncer) DelAnnouncedIP(ip net.IP) error {
 	klog.Infof("cancel respone %s's arp packet", ip)
+	a.scheduler.stop(ip.String())
 	a.lock.Lock()
 	defer a.lock.Unlock()
 
@@ -189,6 +227,7 @@ func (a *arpAnnouncer) DelAnnouncedIP(ip net.IP) error {
 }
 
 func (a *arpAnnouncer) Start() error {
+	a.conflicts.start()
 	go func() {
 		for {
 			select {
@@ -208,6 +247,8 @@ func (a *arpAnnouncer) Start() error {
 }
 
 func (a *arpAnnouncer) Stop() error {
+	a.scheduler.stopAll()
+	a.conflicts.stop()
 	a.conn.Close()
 	a.stopCh <- struct{}{}
 	return nil
@@ -225,6 +266,12 @@ func (a *arpAnnouncer) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	// another host claims an announced ip
+	if a.getMac(pkt.SenderIP.String()) != nil && !bytes.Equal(pkt.SenderHardwareAddr, a.intf.HardwareAddr) {
+		klog.Warningf("interface %s got ARP packet of %s from %s", a.intf.Name, pkt.SenderIP, pkt.SenderHardwareAddr)
+		a.conflicts.add(pkt.SenderIP, pkt.SenderHardwareAddr)
+	}
+
 	// Ignore ARP replies.
 	if pkt.Operation != arp.OperationRequest {
 		return dropReasonARPReply
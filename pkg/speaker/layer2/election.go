@@ -0,0 +1,118 @@
+package layer2
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"strconv"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+type candidate struct {
+	name      string
+	index     int
+	preferred bool
+	priority  int
+	hash      [sha256.Size]byte
+}
+
+func nodePriority(node *corev1.Node) int {
+	value, ok := node.Labels[constant.OpenELBNodeLayer2Priority]
+	if !ok {
+		return 0
+	}
+	priority, err := strconv.Atoi(value)
+	if err != nil {
+		klog.Warningf("node %s has invalid layer2 priority %q", node.Name, value)
+		return 0
+	}
+	return priority
+}
+
+// electNodes orders the nodes by their preference to announce the ip, the
+// first one wins. The order only depends on the nodes and the election, so
+// every speaker computes the same winner from the same inputs.
+func electNodes(ip string, nodes []corev1.Node, election *v1alpha2.Layer2Election) []string {
+	candidates := make([]candidate, 0, len(nodes))
+	for i := range nodes {
+		node := &nodes[i]
+		preferred, err := election.NodePreferred(node)
+		if err != nil {
+			klog.Warningf("invalid layer2 election node selector: %v", err)
+		}
+		candidates = append(candidates, candidate{
+			name:      node.Name,
+			index:     election.NodeIndex(node.Name),
+			preferred: preferred,
+			priority:  nodePriority(node),
+			hash:      sha256.Sum256([]byte(node.Name + "#" + ip)),
+		})
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.index != b.index {
+			return a.index < b.index
+		}
+		if a.preferred != b.preferred {
+			return a.preferred
+		}
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if c := bytes.Compare(a.hash[:], b.hash[:]); c != 0 {
+			return c < 0
+		}
+		return a.name < b.name
+	})
+
+	result := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		result = append(result, c.name)
+	}
+	return result
+}
+
+// balanceNodes orders the nodes like electNodes, but moves the node the ip
+// is assigned to in balanced mode first. No node is assigned more than
+// len(used)/len(nodes) ips, rounded up. The ips are assigned in rounds, in
+// round k every ip left tries the k-th node of its election order, so ips
+// only leave their first choice when it is full and membership changes
+// mostly move the ips of the nodes leaving. The used ips are taken in sorted
+// order, every speaker computes the same assignment.
+func balanceNodes(ip string, used []string, nodes []corev1.Node, election *v1alpha2.Layer2Election) []string {
+	ips := append([]string{}, used...)
+	if i := sort.SearchStrings(ips, ip); i == len(ips) || ips[i] != ip {
+		ips = append(ips, ip)
+		sort.Strings(ips)
+	}
+
+	orders := make([][]string, len(ips))
+	for i, addr := range ips {
+		orders[i] = electNodes(addr, nodes, election)
+	}
+
+	bound := (len(ips) + len(nodes) - 1) / len(nodes)
+	load := make(map[string]int)
+	assigned := make([]bool, len(ips))
+	for round := 0; round < len(nodes); round++ {
+		for i, addr := range ips {
+			node := orders[i][round]
+			if assigned[i] || load[node] >= bound {
+				continue
+			}
+			load[node]++
+			assigned[i] = true
+			if addr == ip {
+				order := orders[i]
+				return append([]string{node}, append(order[:round:round], order[round+1:]...)...)
+			}
+		}
+	}
+
+	return electNodes(ip, nodes, election)
+}
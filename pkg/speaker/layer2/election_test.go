@@ -0,0 +1,126 @@
+package layer2
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testNode(name string, labels map[string]string) corev1.Node {
+	return corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func TestElectNodes(t *testing.T) {
+	ip := "192.168.0.100"
+	nodes := []corev1.Node{
+		testNode("node1", nil),
+		testNode("node2", map[string]string{"edge": "true"}),
+		testNode("node3", map[string]string{constant.OpenELBNodeLayer2Priority: "10"}),
+		testNode("node4", map[string]string{constant.OpenELBNodeLayer2Priority: "invalid"}),
+	}
+
+	hashed := electNodes(ip, []corev1.Node{nodes[0], nodes[3]}, nil)
+	reversed := electNodes(ip, []corev1.Node{nodes[3], nodes[0]}, nil)
+	if !reflect.DeepEqual(hashed, reversed) {
+		t.Fatalf("order depends on the input order: %v, %v", hashed, reversed)
+	}
+
+	tests := []struct {
+		name     string
+		election *v1alpha2.Layer2Election
+		want     []string
+	}{
+		{
+			name: "priority label",
+			want: append([]string{"node3"}, electNodes(ip, []corev1.Node{nodes[0], nodes[1], nodes[3]}, nil)...),
+		},
+		{
+			name: "node selector",
+			election: &v1alpha2.Layer2Election{
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"edge": "true"}},
+			},
+			want: append([]string{"node2", "node3"}, hashed...),
+		},
+		{
+			name: "node list",
+			election: &v1alpha2.Layer2Election{
+				Nodes:        []string{"node4", "node1"},
+				NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"edge": "true"}},
+			},
+			want: []string{"node4", "node1", "node2", "node3"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := electNodes(ip, nodes, tt.election); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("electNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBalanceNodes(t *testing.T) {
+	election := &v1alpha2.Layer2Election{Balanced: true}
+	nodes := []corev1.Node{testNode("node1", nil), testNode("node2", nil), testNode("node3", nil)}
+	var used []string
+	for i := 10; i < 20; i++ {
+		used = append(used, fmt.Sprintf("192.168.0.%d", i))
+	}
+
+	assign := func(nodes []corev1.Node) map[string]string {
+		result := make(map[string]string)
+		for _, ip := range used {
+			result[ip] = balanceNodes(ip, used, nodes, election)[0]
+		}
+		return result
+	}
+	load := func(assignment map[string]string) map[string]int {
+		result := make(map[string]int)
+		for _, node := range assignment {
+			result[node]++
+		}
+		return result
+	}
+
+	before := assign(nodes)
+	for node, n := range load(before) {
+		if n > 4 {
+			t.Errorf("node %s announces %d ips, want at most 4", node, n)
+		}
+	}
+
+	reversed := assign([]corev1.Node{nodes[2], nodes[1], nodes[0]})
+	if !reflect.DeepEqual(before, reversed) {
+		t.Errorf("assignment depends on the node order: %v, %v", before, reversed)
+	}
+
+	after := assign(nodes[:2])
+	for node, n := range load(after) {
+		if n > 5 {
+			t.Errorf("node %s announces %d ips, want at most 5", node, n)
+		}
+	}
+	// the ips of node3 move, they push out at most as many other ips
+	moved, displaced := 0, 0
+	for ip, node := range before {
+		if node == "node3" {
+			displaced++
+		} else if after[ip] != node {
+			moved++
+		}
+	}
+	if moved > displaced {
+		t.Errorf("%d ips moved between the remaining nodes, want at most %d", moved, displaced)
+	}
+
+	order := balanceNodes(used[0], used, nodes, election)
+	if len(order) != len(nodes) {
+		t.Errorf("balanceNodes() = %v, want all nodes", order)
+	}
+}
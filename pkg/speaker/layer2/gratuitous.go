@@ -0,0 +1,128 @@
+package layer2
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/openelb/openelb/pkg/speaker"
+	"k8s.io/klog/v2"
+)
+
+type announcement struct {
+	config speaker.AnnounceConfig
+	cancel chan struct{}
+}
+
+// gratuitousScheduler sends the gratuitous packets of the announced ips in
+// bursts, repeated every refresh interval, so that switches which missed a
+// packet or aged out their mac tables learn the ips again.
+type gratuitousScheduler struct {
+	send func(ip net.IP) error
+
+	lock          sync.Mutex
+	announcements map[string]*announcement
+}
+
+func newGratuitousScheduler(send func(ip net.IP) error) *gratuitousScheduler {
+	return &gratuitousScheduler{
+		send:          send,
+		announcements: make(map[string]*announcement),
+	}
+}
+
+// announce sends the first packet of the ip at once and schedules the rest.
+// Announcing an ip again only restarts the announcement if the config changed.
+func (g *gratuitousScheduler) announce(ip net.IP, config speaker.AnnounceConfig) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if a, ok := g.announcements[ip.String()]; ok {
+		if a.config == config {
+			return nil
+		}
+		close(a.cancel)
+		delete(g.announcements, ip.String())
+	}
+
+	if err := g.send(ip); err != nil {
+		return err
+	}
+
+	a := &announcement{config: config, cancel: make(chan struct{})}
+	g.announcements[ip.String()] = a
+	go g.run(ip, a)
+	return nil
+}
+
+// reassert announces the ip again from the first packet, if it is announced.
+func (g *gratuitousScheduler) reassert(ip net.IP) error {
+	g.lock.Lock()
+	a, ok := g.announcements[ip.String()]
+	g.lock.Unlock()
+	if !ok {
+		return nil
+	}
+
+	g.stop(ip.String())
+	return g.announce(ip, a.config)
+}
+
+func (g *gratuitousScheduler) run(ip net.IP, a *announcement) {
+	// burst sends the packets after the first one of a burst
+	burst := func() bool {
+		for i := 1; i < a.config.BurstCount; i++ {
+			select {
+			case <-a.cancel:
+				return false
+			case <-time.After(a.config.BurstInterval):
+			}
+			if err := g.send(ip); err != nil {
+				klog.Errorf("send gratuitous packet of %s: %v", ip, err)
+			}
+		}
+		return true
+	}
+
+	if !burst() || a.config.RefreshInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(a.config.RefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.cancel:
+			return
+		case <-ticker.C:
+		}
+
+		klog.V(4).Infof("refresh gratuitous packets of %s", ip)
+		if err := g.send(ip); err != nil {
+			klog.Errorf("send gratuitous packet of %s: %v", ip, err)
+		}
+		if !burst() {
+			return
+		}
+	}
+}
+
+func (g *gratuitousScheduler) stop(ip string) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if a, ok := g.announcements[ip]; ok {
+		close(a.cancel)
+		delete(g.announcements, ip)
+	}
+}
+
+func (g *gratuitousScheduler) stopAll() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for ip, a := range g.announcements {
+		close(a.cancel)
+		delete(g.announcements, ip)
+	}
+}
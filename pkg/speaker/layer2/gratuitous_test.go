@@ -0,0 +1,101 @@
+package layer2
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openelb/openelb/pkg/speaker"
+)
+
+type sentCounter struct {
+	lock sync.Mutex
+	sent map[string]int
+}
+
+func (c *sentCounter) send(ip net.IP) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sent[ip.String()]++
+	return nil
+}
+
+func (c *sentCounter) count(ip string) int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sent[ip]
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGratuitousScheduler(t *testing.T) {
+	c := &sentCounter{sent: map[string]int{}}
+	g := newGratuitousScheduler(c.send)
+
+	burst := speaker.AnnounceConfig{BurstCount: 3, BurstInterval: time.Millisecond}
+	ip := net.ParseIP("192.168.0.1")
+	if err := g.announce(ip, burst); err != nil {
+		t.Fatal(err)
+	}
+	if c.count("192.168.0.1") < 1 {
+		t.Fatal("first packet should be sent at once")
+	}
+	waitFor(t, func() bool { return c.count("192.168.0.1") == 3 })
+
+	// announcing again with the same config sends nothing
+	if err := g.announce(ip, burst); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if got := c.count("192.168.0.1"); got != 3 {
+		t.Fatalf("sent %d packets, want 3", got)
+	}
+
+	refresh := speaker.AnnounceConfig{BurstCount: 1, RefreshInterval: 10 * time.Millisecond}
+	if err := g.announce(ip, refresh); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, func() bool { return c.count("192.168.0.1") >= 6 })
+
+	g.stop("192.168.0.1")
+	// a packet may be in flight while stopping
+	stopped := c.count("192.168.0.1") + 1
+	time.Sleep(30 * time.Millisecond)
+	if got := c.count("192.168.0.1"); got > stopped {
+		t.Fatalf("sent %d packets after stop", got-stopped)
+	}
+	if len(g.announcements) != 0 {
+		t.Fatalf("announcements left: %v", g.announcements)
+	}
+}
+
+func TestGratuitousSchedulerReassert(t *testing.T) {
+	c := &sentCounter{sent: map[string]int{}}
+	g := newGratuitousScheduler(c.send)
+	defer g.stopAll()
+
+	ip := net.ParseIP("192.168.0.1")
+	if err := g.reassert(ip); err != nil || c.count("192.168.0.1") != 0 {
+		t.Fatalf("reassert of an ip not announced sent %d packets, err %v", c.count("192.168.0.1"), err)
+	}
+
+	if err := g.announce(ip, speaker.AnnounceConfig{BurstCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.reassert(ip); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.count("192.168.0.1"); got != 2 {
+		t.Errorf("sent %d packets, want 2", got)
+	}
+}
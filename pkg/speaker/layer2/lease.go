@@ -0,0 +1,232 @@
+package layer2
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+)
+
+// duration of the speaker lease recording the memberlist members of a node
+const speakerLeaseDuration = time.Minute
+
+// leaseManager holds a Lease per ip announced by this node. Only the holder
+// announces an ip, so the api server arbitrates when the memberlist views of
+// the speakers disagree.
+type leaseManager struct {
+	client    kubernetes.Interface
+	namespace string
+	node      string
+	duration  time.Duration
+
+	lock sync.Mutex
+	// ips whose lease is held by this node, with the time of the last renew
+	held map[string]time.Time
+}
+
+func newLeaseManager(client kubernetes.Interface, namespace, node string, duration time.Duration) *leaseManager {
+	return &leaseManager{
+		client:    client,
+		namespace: namespace,
+		node:      node,
+		duration:  duration,
+		held:      map[string]time.Time{},
+	}
+}
+
+func (m *leaseManager) setHeld(ip string, renew time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.held[ip] = renew
+}
+
+func (m *leaseManager) delHeld(ip string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	_, ok := m.held[ip]
+	delete(m.held, ip)
+	return ok
+}
+
+// lapsed reports whether the lease of the ip held by this node was not
+// renewed within its duration, other nodes may have taken it over since.
+func (m *leaseManager) lapsed(ip string) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	renew, ok := m.held[ip]
+	return ok && time.Since(renew) > m.duration
+}
+
+func leaseName(ip string) string {
+	return "openelb-layer2-" + strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+}
+
+func speakerLeaseName(node string) string {
+	return "openelb-speaker-" + node
+}
+
+func (m *leaseManager) expired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" || lease.Spec.RenewTime == nil {
+		return true
+	}
+
+	duration := m.duration
+	if lease.Spec.LeaseDurationSeconds != nil {
+		duration = time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second
+	}
+	return lease.Spec.RenewTime.Add(duration).Before(time.Now())
+}
+
+// acquire takes or renews the lease of the ip, it returns false if another
+// node holds it.
+func (m *leaseManager) acquire(ctx context.Context, ip string) (bool, error) {
+	leases := m.client.CoordinationV1().Leases(m.namespace)
+	start := time.Now()
+	now := metav1.NewMicroTime(start)
+	seconds := int32(m.duration.Seconds())
+
+	lease, err := leases.Get(ctx, leaseName(ip), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{Name: leaseName(ip), Namespace: m.namespace},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.node,
+				LeaseDurationSeconds: &seconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		if _, err := leases.Create(ctx, lease, metav1.CreateOptions{}); err != nil {
+			if errors.IsAlreadyExists(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		m.setHeld(ip, start)
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	holder := ""
+	if lease.Spec.HolderIdentity != nil {
+		holder = *lease.Spec.HolderIdentity
+	}
+	if holder != m.node {
+		if !m.expired(lease) {
+			m.delHeld(ip)
+			return false, nil
+		}
+		klog.Infof("take over the lease of %s from [%s]", ip, holder)
+		transitions := int32(1)
+		if lease.Spec.LeaseTransitions != nil {
+			transitions = *lease.Spec.LeaseTransitions + 1
+		}
+		lease.Spec.LeaseTransitions = &transitions
+		lease.Spec.AcquireTime = &now
+	}
+
+	lease.Spec.HolderIdentity = &m.node
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	m.setHeld(ip, start)
+	return true, nil
+}
+
+// release deletes the lease of the ip if this node holds it.
+func (m *leaseManager) release(ctx context.Context, ip string) error {
+	if !m.delHeld(ip) {
+		return nil
+	}
+
+	leases := m.client.CoordinationV1().Leases(m.namespace)
+	lease, err := leases.Get(ctx, leaseName(ip), metav1.GetOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != m.node {
+		return nil
+	}
+
+	err = leases.Delete(ctx, lease.Name, metav1.DeleteOptions{
+		Preconditions: &metav1.Preconditions{UID: &lease.UID, ResourceVersion: &lease.ResourceVersion},
+	})
+	if errors.IsNotFound(err) || errors.IsConflict(err) {
+		return nil
+	}
+	return err
+}
+
+// reportMembers records the memberlist members seen by this node in the
+// speaker lease of the node. The lease is owned by the node, so it is garbage
+// collected with it.
+func reportMembers(ctx context.Context, client kubernetes.Interface, namespace, node string, members []string) error {
+	leases := client.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	seconds := int32(speakerLeaseDuration.Seconds())
+	value := strings.Join(members, ",")
+
+	lease, err := leases.Get(ctx, speakerLeaseName(node), metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        speakerLeaseName(node),
+				Namespace:   namespace,
+				Annotations: map[string]string{constant.OpenELBLayer2MembersAnnotation: value},
+			},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: &node, LeaseDurationSeconds: &seconds, RenewTime: &now},
+		}
+		n, err := client.CoreV1().Nodes().Get(ctx, node, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		lease.OwnerReferences = []metav1.OwnerReference{{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Node",
+			Name:       n.Name,
+			UID:        n.UID,
+		}}
+		_, err = leases.Create(ctx, lease, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if lease.Annotations == nil {
+		lease.Annotations = map[string]string{}
+	}
+	lease.Annotations[constant.OpenELBLayer2MembersAnnotation] = value
+	lease.Spec.HolderIdentity = &node
+	lease.Spec.LeaseDurationSeconds = &seconds
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// deleteSpeakerLease deletes the speaker lease of the node.
+func deleteSpeakerLease(ctx context.Context, client kubernetes.Interface, namespace, node string) error {
+	err := client.CoordinationV1().Leases(namespace).Delete(ctx, speakerLeaseName(node), metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package layer2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/speaker"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestLeaseName(t *testing.T) {
+	if got := leaseName("192.168.0.10"); got != "openelb-layer2-192-168-0-10" {
+		t.Errorf("leaseName() = %s", got)
+	}
+	if got := leaseName("fd00::10"); got != "openelb-layer2-fd00--10" {
+		t.Errorf("leaseName() = %s", got)
+	}
+}
+
+func TestLeaseManager(t *testing.T) {
+	ctx := context.Background()
+	ip := "192.168.0.10"
+	client := fake.NewSimpleClientset()
+	node1 := newLeaseManager(client, "openelb-system", "node1", 15*time.Second)
+	node2 := newLeaseManager(client, "openelb-system", "node2", 15*time.Second)
+
+	if held, err := node1.acquire(ctx, ip); err != nil || !held {
+		t.Fatalf("node1 acquire() = %v, %v, want true", held, err)
+	}
+	if held, err := node1.acquire(ctx, ip); err != nil || !held {
+		t.Fatalf("node1 renew = %v, %v, want true", held, err)
+	}
+	if held, err := node2.acquire(ctx, ip); err != nil || held {
+		t.Fatalf("node2 acquire() = %v, %v, want false", held, err)
+	}
+
+	// only the holder deletes the lease
+	if err := node2.release(ctx, ip); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoordinationV1().Leases("openelb-system").Get(ctx, leaseName(ip), metav1.GetOptions{}); err != nil {
+		t.Fatalf("lease deleted by node2: %v", err)
+	}
+
+	if err := node1.release(ctx, ip); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := node2.acquire(ctx, ip); err != nil || !held {
+		t.Fatalf("node2 acquire() after release = %v, %v, want true", held, err)
+	}
+
+	// node2 stops renewing, node1 takes over once the lease expired
+	leases := client.CoordinationV1().Leases("openelb-system")
+	lease, err := leases.Get(ctx, leaseName(ip), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	renew := metav1.NewMicroTime(time.Now().Add(-time.Minute))
+	lease.Spec.RenewTime = &renew
+	if _, err := leases.Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := node1.acquire(ctx, ip); err != nil || !held {
+		t.Fatalf("node1 acquire() of expired lease = %v, %v, want true", held, err)
+	}
+	lease, err = leases.Get(ctx, leaseName(ip), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *lease.Spec.HolderIdentity != "node1" || lease.Spec.LeaseTransitions == nil || *lease.Spec.LeaseTransitions != 1 {
+		t.Errorf("lease holder %s, transitions %v, want node1 and 1", *lease.Spec.HolderIdentity, lease.Spec.LeaseTransitions)
+	}
+	if held, err := node2.acquire(ctx, ip); err != nil || held {
+		t.Fatalf("node2 acquire() = %v, %v, want false", held, err)
+	}
+}
+
+func TestReportMembers(t *testing.T) {
+	ctx := context.Background()
+	client := fake.NewSimpleClientset(&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1", UID: "uid1"}})
+
+	for _, members := range [][]string{{"node1"}, {"node1", "node2"}} {
+		if err := reportMembers(ctx, client, "openelb-system", "node1", members); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	lease, err := client.CoordinationV1().Leases("openelb-system").Get(ctx, speakerLeaseName("node1"), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := lease.Annotations[constant.OpenELBLayer2MembersAnnotation]; got != "node1,node2" {
+		t.Errorf("members annotation = %q, want %q", got, "node1,node2")
+	}
+	if d := lease.Spec.LeaseDurationSeconds; d == nil || *d != int32(speakerLeaseDuration.Seconds()) {
+		t.Errorf("lease duration = %v, want %s", d, speakerLeaseDuration)
+	}
+	// garbage collected with the node
+	if refs := lease.OwnerReferences; len(refs) != 1 || refs[0].Kind != "Node" || refs[0].UID != "uid1" {
+		t.Errorf("owner references = %+v, want node1", refs)
+	}
+
+	if err := deleteSpeakerLease(ctx, client, "openelb-system", "node1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.CoordinationV1().Leases("openelb-system").Get(ctx, speakerLeaseName("node1"), metav1.GetOptions{}); !errors.IsNotFound(err) {
+		t.Errorf("speaker lease not deleted: %v", err)
+	}
+	if err := deleteSpeakerLease(ctx, client, "openelb-system", "node1"); err != nil {
+		t.Errorf("delete a missing speaker lease: %v", err)
+	}
+}
+
+func TestRenewLeasesLapsed(t *testing.T) {
+	t.Setenv(constant.EnvNodeName, "node1")
+	ip := "192.168.0.10"
+
+	client := fake.NewSimpleClientset()
+	a := newFakeAnnouncer()
+	l := &layer2Speaker{
+		members:    func() map[string]bool { return map[string]bool{"node1": true} },
+		leases:     newLeaseManager(client, "openelb-system", "node1", 15*time.Second),
+		announcers: map[string]Announcer{"eth0": a},
+		eips:       map[string]speaker.Config{},
+		balancers:  map[string][]corev1.Node{},
+		winners:    map[string]string{},
+		links:      newLinkManager(),
+	}
+	if err := l.SetBalancer(ip, []corev1.Node{testNode("node1", nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsAnnounced(net.ParseIP(ip)) {
+		t.Fatalf("ip %s not announced by the lease holder", ip)
+	}
+
+	// the api server is unreachable from now on
+	client.PrependReactor("*", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("connection refused")
+	})
+
+	l.renewLeases()
+	if !a.IsAnnounced(net.ParseIP(ip)) {
+		t.Fatalf("ip %s withdrawn before its lease lapsed", ip)
+	}
+
+	l.leases.setHeld(ip, time.Now().Add(-time.Minute))
+	l.renewLeases()
+	if a.IsAnnounced(net.ParseIP(ip)) {
+		t.Errorf("ip %s still announced after its lease lapsed", ip)
+	}
+}
@@ -0,0 +1,271 @@
+package layer2
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"k8s.io/klog/v2"
+)
+
+// alias marking the vlan interfaces created by the speaker, those left by a
+// previous run are removed when the speaker starts
+const vlanAlias = "openelb"
+
+const (
+	// interval the duplicate address detection of a source address is polled at
+	dadPollInterval = 100 * time.Millisecond
+	// time the duplicate address detection of a source address may take
+	dadTimeout = 5 * time.Second
+)
+
+type linkAddr struct {
+	link string
+	addr string
+}
+
+// linkManager creates the vlan sub-interfaces and source addresses the
+// layer2 eips announce on, and removes them once no eip uses them. Links and
+// addresses which existed before are used but never removed.
+type linkManager struct {
+	// eips using the vlans created by the speaker, keyed by link name
+	vlans map[string]map[string]bool
+	// eips using the addresses added by the speaker
+	addrs map[linkAddr]map[string]bool
+}
+
+func newLinkManager() *linkManager {
+	return &linkManager{
+		vlans: map[string]map[string]bool{},
+		addrs: map[linkAddr]map[string]bool{},
+	}
+}
+
+// vlanName returns the name of the vlan interface, the parent name is cut to
+// fit the interface name length.
+func vlanName(parent string, id int) string {
+	suffix := "." + strconv.Itoa(id)
+	if max := unix.IFNAMSIZ - 1 - len(suffix); len(parent) > max {
+		parent = parent[:max]
+	}
+	return parent + suffix
+}
+
+// ensureVlan returns the vlan interface of the parent, creating it if missing.
+func (m *linkManager) ensureVlan(eip string, parent *net.Interface, id int) (*net.Interface, error) {
+	name := vlanName(parent.Name, id)
+	link, err := netlink.LinkByName(name)
+	switch err.(type) {
+	case nil:
+		vlan, ok := link.(*netlink.Vlan)
+		if !ok || vlan.ParentIndex != parent.Index || vlan.VlanId != id {
+			return nil, fmt.Errorf("interface %s exists and is not the vlan %d of %s", name, id, parent.Name)
+		}
+	case netlink.LinkNotFoundError:
+		link = &netlink.Vlan{
+			LinkAttrs: netlink.LinkAttrs{Name: name, ParentIndex: parent.Index},
+			VlanId:    id,
+		}
+		if err := netlink.LinkAdd(link); err != nil {
+			return nil, fmt.Errorf("create vlan interface %s: %v", name, err)
+		}
+		if err := netlink.LinkSetAlias(link, vlanAlias); err != nil {
+			return nil, err
+		}
+		link.Attrs().Alias = vlanAlias
+		klog.Infof("created vlan interface %s for eip[%s]", name, eip)
+	default:
+		return nil, err
+	}
+
+	if link.Attrs().Alias == vlanAlias {
+		if m.vlans[name] == nil {
+			m.vlans[name] = map[string]bool{}
+		}
+		m.vlans[name][eip] = true
+	}
+
+	if err := netlink.LinkSetUp(link); err != nil {
+		return nil, err
+	}
+	return net.InterfaceByName(name)
+}
+
+// removeStaleVlans removes the vlan interfaces left by a previous run of the
+// speaker, the eips using them create them again.
+func removeStaleVlans() {
+	links, err := netlink.LinkList()
+	if err != nil {
+		klog.Errorf("list interfaces error: %v", err)
+		return
+	}
+	for _, name := range staleVlans(links) {
+		if err := delVlan(name); err != nil {
+			klog.Errorf("delete vlan interface %s: %v", name, err)
+		}
+	}
+}
+
+// staleVlans returns the names of the vlan interfaces marked by the speaker.
+func staleVlans(links []netlink.Link) []string {
+	var names []string
+	for _, link := range links {
+		if _, ok := link.(*netlink.Vlan); ok && link.Attrs().Alias == vlanAlias {
+			names = append(names, link.Attrs().Name)
+		}
+	}
+	return names
+}
+
+// ensureAddr assigns the ip to the interface as a host address if missing.
+func (m *linkManager) ensureAddr(eip string, netif *net.Interface, ip net.IP) error {
+	link, err := netlink.LinkByIndex(netif.Index)
+	if err != nil {
+		return err
+	}
+
+	addr := hostAddr(ip)
+	key := linkAddr{link: netif.Name, addr: addr.IPNet.String()}
+	if users, ok := m.addrs[key]; ok {
+		users[eip] = true
+		return nil
+	}
+
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return err
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return nil
+		}
+	}
+
+	if err := netlink.AddrAdd(link, addr); err != nil {
+		return fmt.Errorf("add source address %s to %s: %v", ip, netif.Name, err)
+	}
+	if err := waitDAD(link, ip); err != nil {
+		if err := netlink.AddrDel(link, addr); err != nil {
+			klog.Errorf("delete source address %s of %s: %v", ip, netif.Name, err)
+		}
+		return err
+	}
+	klog.Infof("added source address %s to %s for eip[%s]", ip, netif.Name, eip)
+	m.addrs[key] = map[string]bool{eip: true}
+	return nil
+}
+
+func hostAddr(ip net.IP) *netlink.Addr {
+	if ip.To4() != nil {
+		return &netlink.Addr{IPNet: &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}}
+	}
+	return &netlink.Addr{IPNet: &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}}
+}
+
+// waitDAD waits until the duplicate address detection of the ip is done, the
+// NDP packets can only be sent from it afterwards.
+func waitDAD(link netlink.Link, ip net.IP) error {
+	if ip.To4() != nil {
+		return nil
+	}
+
+	for deadline := time.Now().Add(dadTimeout); time.Now().Before(deadline); time.Sleep(dadPollInterval) {
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+		if err != nil {
+			return err
+		}
+		for _, a := range addrs {
+			if !a.IP.Equal(ip) {
+				continue
+			}
+			if a.Flags&unix.IFA_F_DADFAILED != 0 {
+				return fmt.Errorf("source address %s is used by another host on %s", ip, link.Attrs().Name)
+			}
+			if a.Flags&unix.IFA_F_TENTATIVE == 0 {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("duplicate address detection of %s on %s timed out", ip, link.Attrs().Name)
+}
+
+// release removes the addresses and vlans no other eip uses.
+func (m *linkManager) release(eip string) error {
+	for key, users := range m.addrs {
+		if !users[eip] {
+			continue
+		}
+		delete(users, eip)
+		if len(users) == 0 {
+			delete(m.addrs, key)
+			if err := delAddr(key); err != nil {
+				return err
+			}
+		}
+	}
+
+	for name, users := range m.vlans {
+		if !users[eip] {
+			continue
+		}
+		delete(users, eip)
+		if len(users) == 0 {
+			delete(m.vlans, name)
+			if err := delVlan(name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// releaseAll removes all addresses and vlans created by the speaker.
+func (m *linkManager) releaseAll() {
+	for key := range m.addrs {
+		if err := delAddr(key); err != nil {
+			klog.Errorf("delete source address %s of %s: %v", key.addr, key.link, err)
+		}
+	}
+	for name := range m.vlans {
+		if err := delVlan(name); err != nil {
+			klog.Errorf("delete vlan interface %s: %v", name, err)
+		}
+	}
+
+	m.addrs = map[linkAddr]map[string]bool{}
+	m.vlans = map[string]map[string]bool{}
+}
+
+func delAddr(key linkAddr) error {
+	link, err := netlink.LinkByName(key.link)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	ip, ipnet, err := net.ParseCIDR(key.addr)
+	if err != nil {
+		return err
+	}
+	ipnet.IP = ip
+	klog.Infof("delete source address %s of %s", key.addr, key.link)
+	return netlink.AddrDel(link, &netlink.Addr{IPNet: ipnet})
+}
+
+func delVlan(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		if _, ok := err.(netlink.LinkNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+
+	klog.Infof("delete vlan interface %s", name)
+	return netlink.LinkDel(link)
+}
@@ -0,0 +1,197 @@
+package layer2
+
+import (
+	"net"
+	"os"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestVlanName(t *testing.T) {
+	tests := []struct {
+		parent string
+		id     int
+		want   string
+	}{
+		{parent: "eth0", id: 100, want: "eth0.100"},
+		{parent: "enp0s31f6", id: 4094, want: "enp0s31f6.4094"},
+		{parent: "enx0123456789ab", id: 10, want: "enx012345678.10"},
+	}
+
+	for _, tt := range tests {
+		if got := vlanName(tt.parent, tt.id); got != tt.want {
+			t.Errorf("vlanName(%s, %d) = %s, want %s", tt.parent, tt.id, got, tt.want)
+		}
+		if got := vlanName(tt.parent, tt.id); len(got) > 15 {
+			t.Errorf("vlanName(%s, %d) = %s, longer than 15", tt.parent, tt.id, got)
+		}
+	}
+}
+
+func TestStaleVlans(t *testing.T) {
+	links := []netlink.Link{
+		&netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Alias: vlanAlias}},
+		&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "eth0.100", Alias: vlanAlias}, VlanId: 100},
+		&netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "eth0.200"}, VlanId: 200},
+	}
+
+	if got := staleVlans(links); !reflect.DeepEqual(got, []string{"eth0.100"}) {
+		t.Errorf("staleVlans() = %v, want [eth0.100]", got)
+	}
+}
+
+// inNetns runs f in a new network namespace with the veth interface eth0.
+func inNetns(t *testing.T, f func(parent *net.Interface)) {
+	if os.Getuid() != 0 {
+		t.Skip("the test requires root privileges")
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("create network namespace: %v", err)
+	}
+	defer ns.Close()
+	defer netns.Set(origin)
+
+	veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "eth0"}, PeerName: "eth1"}
+	if err := netlink.LinkAdd(veth); err != nil {
+		t.Fatal(err)
+	}
+	// the peer is up as well, so that the duplicate address detection runs
+	for _, name := range []string{"eth0", "eth1"} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			t.Fatal(err)
+		}
+	}
+	parent, err := net.InterfaceByName("eth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	probe := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "probe", ParentIndex: parent.Index}, VlanId: 1}
+	if err := netlink.LinkAdd(probe); err != nil {
+		t.Skipf("create vlan interface: %v", err)
+	}
+	if err := netlink.LinkDel(probe); err != nil {
+		t.Fatal(err)
+	}
+	f(parent)
+}
+
+func hasAddr(t *testing.T, name string, ip net.IP) bool {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range addrs {
+		if a.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLinkManager(t *testing.T) {
+	inNetns(t, func(parent *net.Interface) {
+		m := newLinkManager()
+		source := net.ParseIP("fd00::1")
+
+		for _, eip := range []string{"eip1", "eip2"} {
+			vlan, err := m.ensureVlan(eip, parent, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if vlan.Name != "eth0.100" {
+				t.Fatalf("vlan interface = %s, want eth0.100", vlan.Name)
+			}
+			if err := m.ensureAddr(eip, vlan, source); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if !hasAddr(t, "eth0.100", source) {
+			t.Fatalf("source address %s is not assigned", source)
+		}
+
+		// the vlan and the address are kept while another eip uses them
+		if err := m.release("eip1"); err != nil {
+			t.Fatal(err)
+		}
+		if !hasAddr(t, "eth0.100", source) {
+			t.Fatalf("source address %s of eip2 is removed", source)
+		}
+
+		if err := m.release("eip2"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := netlink.LinkByName("eth0.100"); err == nil {
+			t.Errorf("vlan interface eth0.100 is not removed")
+		}
+	})
+}
+
+func TestLinkManagerExistingLinks(t *testing.T) {
+	inNetns(t, func(parent *net.Interface) {
+		vlan := &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{Name: "eth0.100", ParentIndex: parent.Index}, VlanId: 100}
+		if err := netlink.LinkAdd(vlan); err != nil {
+			t.Fatal(err)
+		}
+		source := net.ParseIP("fd00::1")
+		if err := netlink.AddrAdd(vlan, hostAddr(source)); err != nil {
+			t.Fatal(err)
+		}
+
+		m := newLinkManager()
+		netif, err := m.ensureVlan("eip1", parent, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := m.ensureAddr("eip1", netif, source); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := m.ensureVlan("eip1", parent, 200); err != nil {
+			t.Fatal(err)
+		}
+
+		// links and addresses which existed before are never removed
+		if err := m.release("eip1"); err != nil {
+			t.Fatal(err)
+		}
+		if !hasAddr(t, "eth0.100", source) {
+			t.Errorf("existing source address %s is removed", source)
+		}
+		if _, err := netlink.LinkByName("eth0.200"); err == nil {
+			t.Errorf("vlan interface eth0.200 is not removed")
+		}
+
+		// the vlans created by the speaker are removed on the next start
+		if _, err := m.ensureVlan("eip1", parent, 300); err != nil {
+			t.Fatal(err)
+		}
+		removeStaleVlans()
+		if _, err := netlink.LinkByName("eth0.300"); err == nil {
+			t.Errorf("vlan interface eth0.300 is not removed")
+		}
+		if _, err := netlink.LinkByName("eth0.100"); err != nil {
+			t.Errorf("existing vlan interface eth0.100 is removed: %v", err)
+		}
+	})
+}
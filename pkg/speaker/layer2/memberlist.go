@@ -1,32 +1,50 @@
 package layer2
 
 import (
-	"bytes"
 	"context"
-	"crypto/sha256"
 	"fmt"
 	"log"
 	"net"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/memberlist"
 	"github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/metrics"
 	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/openelb/openelb/pkg/util"
-	"github.com/openelb/openelb/pkg/util/iprange"
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
 var _ speaker.Speaker = &layer2Speaker{}
 
-func NewSpeaker(client *kubernetes.Clientset, opt *Options, reloadChan chan event.GenericEvent) (speaker.Speaker, error) {
+const (
+	conflictEventInterval = time.Minute
+	// reasserts of an ip claimed by another host before the conflict is only
+	// reported, they are counted again once no conflict was seen for
+	// conflictEventInterval
+	maxReasserts = 3
+)
+
+// timeout of the api requests outside of the failover and the lease renewal
+const requestTimeout = 5 * time.Second
+
+var memberEvents = map[memberlist.NodeEventType]string{
+	memberlist.NodeJoin:   "join",
+	memberlist.NodeLeave:  "leave",
+	memberlist.NodeUpdate: "update",
+}
+
+func NewSpeaker(client *kubernetes.Clientset, recorder record.EventRecorder, opt *Options, reloadChan chan event.GenericEvent) (speaker.Speaker, error) {
 	config := memberlist.DefaultLANConfig()
 	config.Name = opt.NodeName
 	config.BindAddr = opt.BindAddr
@@ -44,16 +62,41 @@ func NewSpeaker(client *kubernetes.Clientset, opt *Options, reloadChan chan even
 		return nil, err
 	}
 
+	var leases *leaseManager
+	if opt.LeaseDuration > 0 {
+		leases = newLeaseManager(client, util.EnvNamespace(), util.GetNodeName(), opt.LeaseDuration)
+	}
+
+	removeStaleVlans()
+
 	return &layer2Speaker{
 		eventCh:    eventCh,
 		reloadChan: reloadChan,
 		mlist:      list,
-		client:     client,
-		announcers: map[string]Announcer{}}, nil
+		members: func() map[string]bool {
+			result := make(map[string]bool)
+			for _, m := range list.Members() {
+				result[m.Name] = true
+			}
+			return result
+		},
+		failoverTimeout: opt.FailoverTimeout,
+		rejoinInterval:  opt.RejoinInterval,
+		leases:          leases,
+		client:          client,
+		recorder:        recorder,
+		conflicts:       map[string]time.Time{},
+		reasserts:       map[string]*reassertion{},
+		announcers:      map[string]Announcer{},
+		eips:            map[string]speaker.Config{},
+		balancers:       map[string][]corev1.Node{},
+		winners:         map[string]string{},
+		links:           newLinkManager()}, nil
 }
 
-func (l *layer2Speaker) joinMembers() error {
-	iplist := []string{}
+// joinMembers joins the speaker pods, all of them or only those missing in
+// the member list.
+func (l *layer2Speaker) joinMembers(missing bool) error {
 	pods, err := l.client.CoreV1().Pods(util.EnvNamespace()).List(context.TODO(), v1.ListOptions{
 		LabelSelector: labels.SelectorFromSet(labels.Set{"app": "openelb", "component": "speaker"}).String(),
 	})
@@ -61,10 +104,24 @@ func (l *layer2Speaker) joinMembers() error {
 		return err
 	}
 
+	joined := map[string]bool{}
+	if missing {
+		for _, m := range l.mlist.Members() {
+			joined[m.Addr.String()] = true
+		}
+	}
+
+	iplist := []string{}
 	for _, p := range pods.Items {
-		iplist = append(iplist, p.Status.PodIP)
+		if p.Status.PodIP != "" && !joined[p.Status.PodIP] {
+			iplist = append(iplist, p.Status.PodIP)
+		}
+	}
+	if missing && len(iplist) == 0 {
+		return nil
 	}
 
+	klog.V(1).Infof("join speakers %v", iplist)
 	_, err = l.mlist.Join(iplist)
 	return err
 }
@@ -74,104 +131,566 @@ type layer2Speaker struct {
 	eventCh    chan memberlist.NodeEvent
 	reloadChan chan event.GenericEvent
 	client     *kubernetes.Clientset
+	recorder   record.EventRecorder
+	// names of the alive memberlist members
+	members func() map[string]bool
+	// deadline of the failover after a memberlist event, the eips are
+	// resynced if it is exceeded
+	failoverTimeout time.Duration
+	// interval the missing speakers are joined at
+	rejoinInterval time.Duration
+	// leases of the announced ips, nil if disabled
+	leases *leaseManager
 
+	lock sync.Mutex
 	// nic - announcers
 	announcers map[string]Announcer
+	// configs of the eips, keyed by name
+	eips map[string]speaker.Config
+	// nodes allowed to announce the ips, as of the last SetBalancer
+	balancers map[string][]corev1.Node
+	// nodes announcing the ips
+	winners map[string]string
+	// vlans and source addresses created for the eips
+	links *linkManager
+	// last events of conflicting hosts, keyed by ip and mac
+	conflicts map[string]time.Time
+	// reasserts of the ips claimed by other hosts
+	reasserts map[string]*reassertion
 }
 
-func (l *layer2Speaker) SetBalancer(ip string, clusterNodes []corev1.Node) error {
+// reassertion tracks the reasserts of an ip claimed by another host.
+type reassertion struct {
+	// last conflict and reassert
+	seen, reasserted time.Time
+	count            int
+}
+
+// eipConfig returns the config of the eip containing the ip.
+func (l *layer2Speaker) eipConfig(ip string) speaker.Config {
+	for _, c := range l.eips {
+		if c.IPRange != nil && c.IPRange.Contains(net.ParseIP(ip)) {
+			return c
+		}
+	}
+	return speaker.Config{}
+}
+
+func (l *layer2Speaker) announcer(ip string) Announcer {
 	for _, a := range l.announcers {
 		if a.ContainsIP(net.ParseIP(ip)) {
-			member := map[string]string{}
-			for _, m := range l.mlist.Members() {
-				member[m.Name] = m.Addr.String()
-			}
+			return a
+		}
+	}
+	return nil
+}
 
-			nodes := []string{}
-			for _, n := range clusterNodes {
-				if _, exist := member[n.GetName()]; exist {
-					nodes = append(nodes, n.GetName())
-				}
-			}
+// elect returns the memberlist members allowed to announce the ip, in
+// election order.
+func (l *layer2Speaker) elect(ip string) []string {
+	member := l.members()
+	candidates := []corev1.Node{}
+	for _, n := range l.balancers[ip] {
+		if member[n.GetName()] {
+			candidates = append(candidates, n)
+		}
+	}
 
-			if len(nodes) == 0 {
-				klog.Warningf("no suitable nodes to participate in the announced election.")
-				return nil
-			}
+	if len(candidates) == 0 {
+		return nil
+	}
 
-			klog.Infof("candidates: [%s]", strings.Join(nodes, ","))
-			// Sort the slice by the hash of node + load balancer ips. This
-			// produces an ordering of ready nodes that is unique to all the services
-			// with the same ip.
-			sort.Slice(nodes, func(i, j int) bool {
-				hi := sha256.Sum256([]byte(nodes[i] + "#" + ip))
-				hj := sha256.Sum256([]byte(nodes[j] + "#" + ip))
+	c := l.eipConfig(ip)
+	if c.Layer2Election != nil && c.Layer2Election.Balanced {
+		return balanceNodes(ip, c.Used, candidates, c.Layer2Election)
+	}
+	return electNodes(ip, candidates, c.Layer2Election)
+}
 
-				return bytes.Compare(hi[:], hj[:]) < 0
-			})
+// handover is the announcement of an ip by the winner of its election. It is
+// decided under l.lock and applied without it, as it takes or releases the
+// lease of the ip through the api server.
+type handover struct {
+	a      Announcer
+	ip     string
+	winner string
+}
 
-			klog.Infof("[%s] wins the right to announce the IP address %s", nodes[0], ip)
-			if nodes[0] != util.GetNodeName() {
-				return nil
+// apply announces the ip if this node is the winner and holds its lease, and
+// withdraws it otherwise. It must not be called with l.lock held.
+func (l *layer2Speaker) apply(ctx context.Context, h handover) error {
+	if h.winner != util.GetNodeName() {
+		if err := l.withdraw(h); err != nil {
+			return err
+		}
+		if err := l.releaseLease(ctx, h.ip); err != nil {
+			klog.Warningf("release the lease of %s error: %v", h.ip, err)
+		}
+		return nil
+	}
+
+	held := true
+	if l.leases != nil {
+		var err error
+		if held, err = l.leases.acquire(ctx, h.ip); err != nil {
+			return err
+		}
+	}
+
+	superseded, err := l.announceHeld(h, held)
+	if superseded && held {
+		// elected again meanwhile, the lease must not outlive the election
+		return l.releaseLease(ctx, h.ip)
+	}
+	return err
+}
+
+// withdraw stops announcing the ip unless it was elected again meanwhile.
+func (l *layer2Speaker) withdraw(h handover) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	ip := net.ParseIP(h.ip)
+	if l.winners[h.ip] != h.winner || !h.a.IsAnnounced(ip) {
+		return nil
+	}
+	return h.a.DelAnnouncedIP(ip)
+}
+
+// announceHeld announces the ip if this node holds its lease, it reports
+// whether the ip was elected again meanwhile instead.
+func (l *layer2Speaker) announceHeld(h handover, held bool) (bool, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.winners[h.ip] != h.winner {
+		return true, nil
+	}
+	ip := net.ParseIP(h.ip)
+	if held {
+		return false, h.a.AddAnnouncedIP(ip)
+	}
+	klog.Infof("the lease of %s is held by another node, wait for it to expire", h.ip)
+	if h.a.IsAnnounced(ip) {
+		return false, h.a.DelAnnouncedIP(ip)
+	}
+	return false, nil
+}
+
+func (l *layer2Speaker) releaseLease(ctx context.Context, ip string) error {
+	if l.leases == nil {
+		return nil
+	}
+	return l.leases.release(ctx, ip)
+}
+
+// renewLeases renews the leases of the ips this node won. The ips whose
+// lease became free are announced, those whose lease was lost, or could not
+// be renewed within its duration, withdrawn.
+func (l *layer2Speaker) renewLeases() {
+	ctx, cancel := context.WithTimeout(context.Background(), l.leases.duration/3)
+	defer cancel()
+
+	for _, h := range l.won() {
+		held, err := l.leases.acquire(ctx, h.ip)
+		if l.renewed(h, held, err) && held {
+			if err := l.releaseLease(ctx, h.ip); err != nil {
+				klog.Warningf("release the lease of %s error: %v", h.ip, err)
 			}
-			return a.AddAnnouncedIP(net.ParseIP(ip))
 		}
 	}
+}
 
-	klog.Warningf("The announcers of the speakers do not contain the %s", ip)
-	return nil
+// won returns the handovers of the ips this node won.
+func (l *layer2Speaker) won() []handover {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var result []handover
+	for ip, winner := range l.winners {
+		a := l.announcer(ip)
+		if winner != util.GetNodeName() || a == nil {
+			continue
+		}
+		result = append(result, handover{a: a, ip: ip, winner: winner})
+	}
+	return result
+}
+
+// renewed announces or withdraws the ip after the renewal of its lease, it
+// reports whether the ip was elected again meanwhile instead.
+func (l *layer2Speaker) renewed(h handover, held bool, err error) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.winners[h.ip] != h.winner {
+		return true
+	}
+
+	ip := net.ParseIP(h.ip)
+	announced := h.a.IsAnnounced(ip)
+	if err != nil {
+		klog.Warningf("renew the lease of %s error: %v", h.ip, err)
+		if !announced || !l.leases.lapsed(h.ip) {
+			return false
+		}
+		// another node may hold the lease by now
+		klog.Warningf("the lease of %s was not renewed for %s, stop announcing it", h.ip, l.leases.duration)
+		held = false
+	}
+
+	switch {
+	case held && !announced:
+		klog.Infof("acquired the lease of %s", h.ip)
+		err = h.a.AddAnnouncedIP(ip)
+	case !held && announced:
+		klog.Warningf("lost the lease of %s to another node", h.ip)
+		err = h.a.DelAnnouncedIP(ip)
+	default:
+		err = nil
+	}
+	if err != nil {
+		klog.Errorf("announce %s error: %v", h.ip, err)
+	}
+	return false
+}
+
+// reportMembers records the members seen by this speaker in its lease.
+func (l *layer2Speaker) reportMembers() {
+	if l.client == nil {
+		return
+	}
+
+	var members []string
+	for name := range l.members() {
+		members = append(members, name)
+	}
+	sort.Strings(members)
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if err := reportMembers(ctx, l.client, util.EnvNamespace(), util.GetNodeName(), members); err != nil {
+		klog.Warningf("report memberlist members error: %v", err)
+	}
+}
+
+func (l *layer2Speaker) SetBalancer(ip string, clusterNodes []corev1.Node) error {
+	h, ok := l.setBalancer(ip, clusterNodes)
+	if !ok {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	return l.apply(ctx, h)
+}
+
+// setBalancer elects the node announcing the ip, it returns false if no
+// announcer contains the ip.
+func (l *layer2Speaker) setBalancer(ip string, clusterNodes []corev1.Node) (handover, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	a := l.announcer(ip)
+	if a == nil {
+		klog.Warningf("The announcers of the speakers do not contain the %s", ip)
+		return handover{}, false
+	}
+
+	l.balancers[ip] = clusterNodes
+	h := handover{a: a, ip: ip}
+	nodes := l.elect(ip)
+	if len(nodes) == 0 {
+		// this node may have announced it before it stopped being a candidate
+		klog.Warningf("no suitable nodes to participate in the announced election.")
+	} else {
+		klog.Infof("candidates: [%s]", strings.Join(nodes, ","))
+		klog.Infof("[%s] wins the right to announce the IP address %s", nodes[0], ip)
+		h.winner = nodes[0]
+	}
+	l.winners[ip] = h.winner
+	return h, true
 }
 
 func (l *layer2Speaker) DelBalancer(ip string) error {
-	for _, a := range l.announcers {
-		if a.ContainsIP(net.ParseIP(ip)) {
-			return a.DelAnnouncedIP(net.ParseIP(ip))
+	l.lock.Lock()
+	delete(l.balancers, ip)
+	delete(l.winners, ip)
+	a := l.announcer(ip)
+	l.lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if a != nil {
+		return l.apply(ctx, handover{a: a, ip: ip})
+	}
+	if err := l.releaseLease(ctx, ip); err != nil {
+		klog.Warningf("release the lease of %s error: %v", ip, err)
+	}
+	return nil
+}
+
+// failover elects the announcing nodes of the ips again with the current
+// members, only the ips whose winner changed are announced or withdrawn.
+func (l *layer2Speaker) failover(ctx context.Context) error {
+	handovers, err := l.reelect(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, h := range handovers {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := l.apply(ctx, h); err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+// reelect returns the handovers of the ips whose winner changed.
+func (l *layer2Speaker) reelect(ctx context.Context) ([]handover, error) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	var handovers []handover
+	for ip := range l.balancers {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		a := l.announcer(ip)
+		nodes := l.elect(ip)
+		if a == nil || len(nodes) == 0 || nodes[0] == l.winners[ip] {
+			continue
+		}
+
+		klog.Infof("[%s] takes over the announcement of %s from [%s]", nodes[0], ip, l.winners[ip])
+		l.winners[ip] = nodes[0]
+		handovers = append(handovers, handover{a: a, ip: ip, winner: nodes[0]})
+	}
+	return handovers, nil
+}
+
+// conflict reports another host claiming an ip announced by this node and
+// applies the conflict policy of its eip.
+func (l *layer2Speaker) conflict(ip net.IP, mac net.HardwareAddr) {
+	metrics.UpdateLayer2ConflictMetrics(ip.String())
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	a := l.announcer(ip.String())
+	if a == nil || !a.IsAnnounced(ip) {
+		return
+	}
+	c := l.eipConfig(ip.String())
+
+	// one event per host and minute
+	for k, last := range l.conflicts {
+		if time.Since(last) > conflictEventInterval {
+			delete(l.conflicts, k)
+		}
+	}
+	key := ip.String() + "/" + mac.String()
+	if _, ok := l.conflicts[key]; !ok {
+		l.conflicts[key] = time.Now()
+		if l.recorder != nil {
+			eip := &v1alpha2.Eip{ObjectMeta: v1.ObjectMeta{Name: c.Name, UID: c.UID}}
+			l.recorder.Eventf(eip, corev1.EventTypeWarning, "Layer2Conflict",
+				"%s announced by node %s is claimed by %s, policy %s", ip, util.GetNodeName(), mac, conflictPolicy(c.Announce))
+		}
+	}
+
+	var err error
+	switch c.Announce.ConflictPolicy {
+	case v1alpha2.Layer2ConflictStepDown:
+		klog.Warningf("step down from announcing %s claimed by %s", ip, mac)
+		err = a.DelAnnouncedIP(ip)
+		l.stepDown(ip.String())
+	case v1alpha2.Layer2ConflictReassert:
+		switch n := l.reassert(ip.String()); n {
+		case 0:
+			return
+		case maxReasserts:
+			klog.Warningf("reassert %s claimed by %s for the last time, the conflict is only reported from now on", ip, mac)
+		default:
+			klog.Warningf("reassert %s claimed by %s", ip, mac)
+		}
+		err = a.Reassert(ip)
+	}
+	if err != nil {
+		klog.Errorf("handle conflict of %s: %v", ip, err)
+	}
+}
+
+// stepDown clears the winner of the ip, so the renewal of its lease does not
+// announce it again until the next election, and releases its lease to the
+// other nodes. It must be called with l.lock held.
+func (l *layer2Speaker) stepDown(ip string) {
+	if _, ok := l.winners[ip]; !ok {
+		return
+	}
+	l.winners[ip] = ""
+	if l.leases == nil {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+		defer cancel()
+		if err := l.releaseLease(ctx, ip); err != nil {
+			klog.Warningf("release the lease of %s error: %v", ip, err)
+		}
+	}()
+}
+
+// reassert returns the number of the reassert of the ip claimed by another
+// host, or 0 if it must not be reasserted now. An ip is reasserted once per
+// conflictEventInterval and at most maxReasserts times in a row.
+func (l *layer2Speaker) reassert(ip string) int {
+	now := time.Now()
+	for k, r := range l.reasserts {
+		if now.Sub(r.seen) > conflictEventInterval {
+			delete(l.reasserts, k)
+		}
+	}
+
+	r, ok := l.reasserts[ip]
+	if !ok {
+		r = &reassertion{}
+		l.reasserts[ip] = r
+	}
+	r.seen = now
+	if r.count >= maxReasserts || (r.count > 0 && now.Sub(r.reasserted) < conflictEventInterval) {
+		return 0
+	}
+	r.count++
+	r.reasserted = now
+	return r.count
+}
+
+func conflictPolicy(c speaker.AnnounceConfig) string {
+	if c.ConflictPolicy == "" {
+		return v1alpha2.Layer2ConflictReport
+	}
+	return c.ConflictPolicy
+}
+
+// reload lets the manager set the balancers of all layer2 eips again.
+func (l *layer2Speaker) reload() {
+	evt := v1alpha2.Eip{}
+	evt.Name = constant.Layer2ReloadEIPName
+	evt.Namespace = constant.Layer2ReloadEIPNamespace
+	l.reloadChan <- event.GenericEvent{Object: &evt}
+}
+
 func (l *layer2Speaker) Start(stopCh <-chan struct{}) error {
-	if err := l.joinMembers(); err != nil {
+	if err := l.joinMembers(false); err != nil {
 		return err
 	}
+	l.reportMembers()
+
+	l.watchMembers(stopCh)
+	return nil
+}
+
+func (l *layer2Speaker) watchMembers(stopCh <-chan struct{}) {
+	var rejoin, renew, report <-chan time.Time
+	if l.client != nil {
+		// keep the speaker lease from expiring
+		ticker := time.NewTicker(speakerLeaseDuration / 3)
+		defer ticker.Stop()
+		report = ticker.C
+	}
+	if l.rejoinInterval > 0 {
+		ticker := time.NewTicker(l.rejoinInterval)
+		defer ticker.Stop()
+		rejoin = ticker.C
+	}
+	if l.leases != nil {
+		ticker := time.NewTicker(l.leases.duration / 3)
+		defer ticker.Stop()
+		renew = ticker.C
+	}
 
 	for {
 		select {
 		case <-stopCh:
 			l.unregisterAllAnnouncers()
-			return nil
-		case <-l.eventCh:
-			evt := v1alpha2.Eip{}
-			evt.Name = constant.Layer2ReloadEIPName
-			evt.Namespace = constant.Layer2ReloadEIPNamespace
-			l.reloadChan <- event.GenericEvent{Object: &evt}
+			return
+		case e := <-l.eventCh:
+			start := time.Now()
+			klog.V(1).Infof("memberlist event %s of node %s", memberEvents[e.Event], e.Node.Name)
+
+			ctx, cancel := context.WithTimeout(context.Background(), l.failoverTimeout)
+			err := l.failover(ctx)
+			cancel()
+			if err != nil {
+				klog.Warningf("layer2 failover failed after %s, resync all eips: %v", time.Since(start), err)
+				l.reload()
+				continue
+			}
+			metrics.UpdateLayer2FailoverMetrics(time.Since(start))
+			l.reportMembers()
+		case <-rejoin:
+			if err := l.joinMembers(true); err != nil {
+				klog.Warningf("join speakers error: %v", err)
+			}
+		case <-report:
+			l.reportMembers()
+		case <-renew:
+			l.renewLeases()
 		}
 	}
 }
 
 func (l *layer2Speaker) ConfigureWithEIP(config speaker.Config, deleted bool) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
 	netif, err := speaker.ParseInterface(config.Iface)
 	if err != nil || netif == nil {
 		return err
 	}
 
-	if err := speaker.ValidateInterface(netif, config.IPRange); err != nil {
-		return err
+	if deleted {
+		delete(l.eips, config.Name)
+		name := netif.Name
+		if config.VlanID > 0 {
+			name = vlanName(netif.Name, config.VlanID)
+		}
+		if err := l.unregisterAnnouncer(config.Name, name); err != nil {
+			return err
+		}
+		return l.links.release(config.Name)
 	}
 
-	if deleted {
-		return l.unregisterAnnouncer(config.Name, netif.Name)
+	if config.VlanID > 0 {
+		if netif, err = l.links.ensureVlan(config.Name, netif, config.VlanID); err != nil {
+			return err
+		}
 	}
-	return l.registerAnnouncer(config.Name, netif, config.IPRange)
+
+	if config.SourceAddress != nil {
+		if err := l.links.ensureAddr(config.Name, netif, config.SourceAddress); err != nil {
+			return err
+		}
+	} else if err := speaker.ValidateInterface(netif, config.IPRange); err != nil {
+		return err
+	}
+
+	l.eips[config.Name] = config
+	return l.registerAnnouncer(config.Name, netif, config)
 }
 
-func (l *layer2Speaker) registerAnnouncer(eipName string, netif *net.Interface, r iprange.Range) error {
+func (l *layer2Speaker) registerAnnouncer(eipName string, netif *net.Interface, config speaker.Config) error {
 	a, exist := l.announcers[netif.Name]
 	if !exist {
 		// no announcer for the interface, create a new one
 		var err error
-		a, err = newAnnouncer(netif, r.Family())
+		a, err = newAnnouncer(netif, config.IPRange.Family(), config.SourceAddress, l.conflict)
 		if err != nil {
 			return fmt.Errorf("new Announcer error. interface %s, error %s", netif.Name, err.Error())
 		}
@@ -183,7 +702,7 @@ func (l *layer2Speaker) registerAnnouncer(eipName string, netif *net.Interface,
 		l.announcers[netif.Name] = a
 	}
 
-	a.RegisterIPRange(eipName, r)
+	a.RegisterIPRange(eipName, config.IPRange, config.Announce)
 	return nil
 }
 
@@ -206,11 +725,41 @@ func (l *layer2Speaker) unregisterAnnouncer(eipName, netifName string) error {
 }
 
 func (l *layer2Speaker) unregisterAllAnnouncers() {
+	ips := l.stopAnnouncers()
+
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+
+	// let the other nodes take over at once
+	for _, ip := range ips {
+		if err := l.releaseLease(ctx, ip); err != nil {
+			klog.Warningf("release the lease of %s error: %v", ip, err)
+		}
+	}
+	if l.client != nil {
+		if err := deleteSpeakerLease(ctx, l.client, util.EnvNamespace(), util.GetNodeName()); err != nil {
+			klog.Warningf("delete the speaker lease error: %v", err)
+		}
+	}
+}
+
+// stopAnnouncers stops all announcers and removes the links created for them,
+// it returns the ips elected so far.
+func (l *layer2Speaker) stopAnnouncers() []string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
 	for _, a := range l.announcers {
 		if err := a.Stop(); err != nil {
 			klog.Errorf("stop announcer error. %s", err.Error())
 		}
 	}
-
 	l.announcers = map[string]Announcer{}
+	l.links.releaseAll()
+
+	ips := make([]string, 0, len(l.winners))
+	for ip := range l.winners {
+		ips = append(ips, ip)
+	}
+	return ips
 }
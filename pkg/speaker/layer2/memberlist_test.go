@@ -0,0 +1,360 @@
+package layer2
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/memberlist"
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	"github.com/openelb/openelb/pkg/speaker"
+	"github.com/openelb/openelb/pkg/util/iprange"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+)
+
+type fakeAnnouncer struct {
+	lock      sync.Mutex
+	announced map[string]bool
+	// number of AddAnnouncedIP calls per ip
+	adds map[string]int
+	// number of Reassert calls per ip
+	reasserts map[string]int
+}
+
+func newFakeAnnouncer() *fakeAnnouncer {
+	return &fakeAnnouncer{announced: map[string]bool{}, adds: map[string]int{}, reasserts: map[string]int{}}
+}
+
+func (f *fakeAnnouncer) AddAnnouncedIP(ip net.IP) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.announced[ip.String()] = true
+	f.adds[ip.String()]++
+	return nil
+}
+
+func (f *fakeAnnouncer) DelAnnouncedIP(ip net.IP) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.announced, ip.String())
+	return nil
+}
+
+func (f *fakeAnnouncer) IsAnnounced(ip net.IP) bool {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.announced[ip.String()]
+}
+
+func (f *fakeAnnouncer) Reassert(ip net.IP) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.reasserts[ip.String()]++
+	return nil
+}
+
+func (f *fakeAnnouncer) addCount(ip string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.adds[ip]
+}
+
+func (f *fakeAnnouncer) Start() error                                                  { return nil }
+func (f *fakeAnnouncer) Stop() error                                                   { return nil }
+func (f *fakeAnnouncer) ContainsIP(net.IP) bool                                        { return true }
+func (f *fakeAnnouncer) RegisterIPRange(string, iprange.Range, speaker.AnnounceConfig) {}
+func (f *fakeAnnouncer) UnregisterIPRange(string)                                      {}
+func (f *fakeAnnouncer) Size() int                                                     { return 1 }
+
+func TestFailover(t *testing.T) {
+	t.Setenv(constant.EnvNodeName, "node1")
+
+	var lock sync.Mutex
+	alive := map[string]bool{"node1": true, "node2": true, "node3": true}
+	a := newFakeAnnouncer()
+	l := &layer2Speaker{
+		eventCh:    make(chan memberlist.NodeEvent),
+		reloadChan: make(chan event.GenericEvent, 1),
+		members: func() map[string]bool {
+			lock.Lock()
+			defer lock.Unlock()
+			result := map[string]bool{}
+			for k, v := range alive {
+				result[k] = v
+			}
+			return result
+		},
+		failoverTimeout: time.Second,
+		announcers:      map[string]Announcer{"eth0": a},
+		eips:            map[string]speaker.Config{},
+		balancers:       map[string][]corev1.Node{},
+		winners:         map[string]string{},
+		links:           newLinkManager(),
+	}
+
+	nodes := []corev1.Node{testNode("node1", nil), testNode("node2", nil), testNode("node3", nil)}
+	winners := map[string]string{}
+	for i := 1; i <= 20; i++ {
+		ip := fmt.Sprintf("192.168.0.%d", i)
+		if err := l.SetBalancer(ip, nodes); err != nil {
+			t.Fatal(err)
+		}
+		winners[ip] = electNodes(ip, nodes, nil)[0]
+		if a.IsAnnounced(net.ParseIP(ip)) != (winners[ip] == "node1") {
+			t.Fatalf("ip %s announced by node1: %v, winner %s", ip, a.IsAnnounced(net.ParseIP(ip)), winners[ip])
+		}
+	}
+
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		l.watchMembers(stopCh)
+		close(done)
+	}()
+	defer func() {
+		close(stopCh)
+		<-done
+	}()
+
+	lock.Lock()
+	delete(alive, "node2")
+	lock.Unlock()
+	l.eventCh <- memberlist.NodeEvent{Event: memberlist.NodeLeave, Node: &memberlist.Node{Name: "node2"}}
+
+	remaining := []corev1.Node{nodes[0], nodes[2]}
+	for ip, winner := range winners {
+		want := electNodes(ip, remaining, nil)[0] == "node1"
+		if err := wait(func() bool { return a.IsAnnounced(net.ParseIP(ip)) == want }); err != nil {
+			t.Errorf("ip %s announced by node1: %v, want %v", ip, !want, want)
+		}
+		// only the ips of node2 are announced again
+		if winner == "node1" && a.addCount(ip) != 1 {
+			t.Errorf("ip %s of node1 announced %d times", ip, a.addCount(ip))
+		}
+	}
+
+	select {
+	case <-l.reloadChan:
+		t.Errorf("failover resynced all eips")
+	default:
+	}
+}
+
+func TestFailoverDeadline(t *testing.T) {
+	l := &layer2Speaker{
+		eventCh:         make(chan memberlist.NodeEvent),
+		reloadChan:      make(chan event.GenericEvent, 1),
+		members:         func() map[string]bool { return map[string]bool{} },
+		announcers:      map[string]Announcer{},
+		balancers:       map[string][]corev1.Node{"192.168.0.1": nil},
+		winners:         map[string]string{},
+		failoverTimeout: 0,
+		links:           newLinkManager(),
+	}
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go l.watchMembers(stopCh)
+
+	l.eventCh <- memberlist.NodeEvent{Event: memberlist.NodeLeave, Node: &memberlist.Node{Name: "node2"}}
+	select {
+	case e := <-l.reloadChan:
+		if e.Object.GetNamespace() != constant.Layer2ReloadEIPNamespace {
+			t.Errorf("unexpected reload event %v", e.Object)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("exceeding the deadline did not resync the eips")
+	}
+}
+
+func wait(cond func() bool) error {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if cond() {
+			return nil
+		}
+	}
+	return fmt.Errorf("timed out")
+}
+
+func TestConflict(t *testing.T) {
+	ipRange, err := iprange.ParseRange("192.168.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, _ := net.ParseMAC("02:00:00:00:00:01")
+
+	tests := []struct {
+		policy        string
+		wantAnnounced bool
+		wantReasserts int
+	}{
+		{policy: "", wantAnnounced: true},
+		{policy: v1alpha2.Layer2ConflictStepDown, wantAnnounced: false},
+		// reasserted once per conflictEventInterval
+		{policy: v1alpha2.Layer2ConflictReassert, wantAnnounced: true, wantReasserts: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(conflictPolicy(speaker.AnnounceConfig{ConflictPolicy: tt.policy}), func(t *testing.T) {
+			a := newFakeAnnouncer()
+			recorder := record.NewFakeRecorder(10)
+			l := &layer2Speaker{
+				recorder:   recorder,
+				conflicts:  map[string]time.Time{},
+				reasserts:  map[string]*reassertion{},
+				announcers: map[string]Announcer{"eth0": a},
+				eips: map[string]speaker.Config{"eip": {
+					Name:     "eip",
+					IPRange:  ipRange,
+					Announce: speaker.AnnounceConfig{ConflictPolicy: tt.policy},
+				}},
+			}
+
+			ip := net.ParseIP("192.168.0.10")
+			a.AddAnnouncedIP(ip)
+			l.conflict(ip, foreign)
+			if tt.policy != v1alpha2.Layer2ConflictStepDown {
+				l.conflict(ip, foreign)
+			}
+
+			if len(recorder.Events) != 1 {
+				t.Errorf("got %d events, want 1", len(recorder.Events))
+			}
+			if a.IsAnnounced(ip) != tt.wantAnnounced {
+				t.Errorf("ip announced: %v, want %v", a.IsAnnounced(ip), tt.wantAnnounced)
+			}
+			if a.reasserts[ip.String()] != tt.wantReasserts {
+				t.Errorf("ip reasserted %d times, want %d", a.reasserts[ip.String()], tt.wantReasserts)
+			}
+		})
+	}
+}
+
+func TestSetBalancerCandidates(t *testing.T) {
+	t.Setenv(constant.EnvNodeName, "node1")
+
+	a := newFakeAnnouncer()
+	l := &layer2Speaker{
+		members:    func() map[string]bool { return map[string]bool{"node1": true, "node2": true} },
+		announcers: map[string]Announcer{"eth0": a},
+		eips:       map[string]speaker.Config{},
+		balancers:  map[string][]corev1.Node{},
+		winners:    map[string]string{},
+		links:      newLinkManager(),
+	}
+
+	ip := "192.168.0.10"
+	if err := l.SetBalancer(ip, []corev1.Node{testNode("node1", nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsAnnounced(net.ParseIP(ip)) {
+		t.Fatalf("ip %s not announced by the only candidate", ip)
+	}
+
+	// the endpoints moved to a node which is not a member
+	if err := l.SetBalancer(ip, []corev1.Node{testNode("node3", nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if a.IsAnnounced(net.ParseIP(ip)) {
+		t.Errorf("ip %s still announced without being a candidate", ip)
+	}
+
+	if err := l.SetBalancer(ip, []corev1.Node{testNode("node2", nil)}); err != nil {
+		t.Fatal(err)
+	}
+	if a.IsAnnounced(net.ParseIP(ip)) || l.winners[ip] != "node2" {
+		t.Errorf("ip %s announced %v, winner %s, want node2", ip, a.IsAnnounced(net.ParseIP(ip)), l.winners[ip])
+	}
+}
+
+func TestReassertLimit(t *testing.T) {
+	l := &layer2Speaker{reasserts: map[string]*reassertion{}}
+	ip := "192.168.0.10"
+
+	for i := 1; i <= maxReasserts; i++ {
+		if n := l.reassert(ip); n != i {
+			t.Fatalf("reassert() = %d, want %d", n, i)
+		}
+		if n := l.reassert(ip); n != 0 {
+			t.Fatalf("reassert() within the interval = %d, want 0", n)
+		}
+		l.reasserts[ip].reasserted = time.Now().Add(-2 * conflictEventInterval)
+	}
+	// given up while the conflict lasts
+	if n := l.reassert(ip); n != 0 {
+		t.Errorf("reassert() after %d reasserts = %d, want 0", maxReasserts, n)
+	}
+
+	// counted again once the conflict ended
+	l.reasserts[ip].seen = time.Now().Add(-2 * conflictEventInterval)
+	if n := l.reassert(ip); n != 1 {
+		t.Errorf("reassert() after the conflict ended = %d, want 1", n)
+	}
+}
+
+func TestStepDownRenewLeases(t *testing.T) {
+	t.Setenv(constant.EnvNodeName, "node1")
+	ipRange, err := iprange.ParseRange("192.168.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, _ := net.ParseMAC("02:00:00:00:00:01")
+	ip := net.ParseIP("192.168.0.10")
+
+	client := fake.NewSimpleClientset()
+	a := newFakeAnnouncer()
+	l := &layer2Speaker{
+		recorder:   record.NewFakeRecorder(10),
+		members:    func() map[string]bool { return map[string]bool{"node1": true} },
+		leases:     newLeaseManager(client, "openelb-system", "node1", 15*time.Second),
+		conflicts:  map[string]time.Time{},
+		reasserts:  map[string]*reassertion{},
+		announcers: map[string]Announcer{"eth0": a},
+		eips: map[string]speaker.Config{"eip": {
+			Name:     "eip",
+			IPRange:  ipRange,
+			Announce: speaker.AnnounceConfig{ConflictPolicy: v1alpha2.Layer2ConflictStepDown},
+		}},
+		balancers: map[string][]corev1.Node{},
+		winners:   map[string]string{},
+		links:     newLinkManager(),
+	}
+	nodes := []corev1.Node{testNode("node1", nil)}
+	if err := l.SetBalancer(ip.String(), nodes); err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsAnnounced(ip) {
+		t.Fatalf("ip %s not announced by the lease holder", ip)
+	}
+
+	l.conflict(ip, foreign)
+	leases := client.CoordinationV1().Leases("openelb-system")
+	if err := wait(func() bool {
+		_, err := leases.Get(context.Background(), leaseName(ip.String()), metav1.GetOptions{})
+		return errors.IsNotFound(err)
+	}); err != nil {
+		t.Fatalf("lease of %s not released after the step down: %v", ip, err)
+	}
+
+	l.renewLeases()
+	if a.IsAnnounced(ip) {
+		t.Fatalf("ip %s announced again by the renewal of its lease after the step down", ip)
+	}
+
+	// the next election announces it again
+	if err := l.SetBalancer(ip.String(), nodes); err != nil {
+		t.Fatal(err)
+	}
+	if !a.IsAnnounced(ip) {
+		t.Errorf("ip %s not announced after the next election", ip)
+	}
+}
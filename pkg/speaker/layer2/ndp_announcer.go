@@ -1,9 +1,11 @@
 package layer2
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/mdlayher/ndp"
 	"github.com/openelb/openelb/pkg/metrics"
+	"github.com/openelb/openelb/pkg/speaker"
 	"github.com/openelb/openelb/pkg/util/iprange"
 	"github.com/vishvananda/netlink"
 	"io"
@@ -22,10 +24,18 @@ type ndpAnnouncer struct {
 	lock     sync.RWMutex
 	ip2mac   map[string]net.HardwareAddr
 	ipranges map[string]iprange.Range
+	configs  map[string]speaker.AnnounceConfig
+
+	scheduler *gratuitousScheduler
+	conflicts *conflictQueue
 }
 
-func newNDPAnnouncer(ifi *net.Interface) (*ndpAnnouncer, error) {
-	conn, _, err := ndp.Listen(ifi, ndp.LinkLocal)
+func newNDPAnnouncer(ifi *net.Interface, source net.IP, conflicts conflictHandler) (*ndpAnnouncer, error) {
+	addr := ndp.LinkLocal
+	if source != nil {
+		addr = ndp.Addr(source.String())
+	}
+	conn, _, err := ndp.Listen(ifi, addr)
 	if err != nil {
 		return nil, fmt.Errorf("creating NDP Announcer for %s, err=%v", ifi.Name, err)
 	}
@@ -34,13 +44,22 @@ func newNDPAnnouncer(ifi *net.Interface) (*ndpAnnouncer, error) {
 	addrs, _ := netlink.AddrList(link, netlink.FAMILY_V6)
 
 	ret := &ndpAnnouncer{
-		intf:     ifi,
-		conn:     conn,
-		addrs:    addrs,
-		stopCh:   make(chan struct{}),
-		ip2mac:   make(map[string]net.HardwareAddr),
-		ipranges: make(map[string]iprange.Range),
+		intf:      ifi,
+		conn:      conn,
+		addrs:     addrs,
+		stopCh:    make(chan struct{}),
+		ip2mac:    make(map[string]net.HardwareAddr),
+		ipranges:  make(map[string]iprange.Range),
+		configs:   make(map[string]speaker.AnnounceConfig),
+		conflicts: newConflictQueue(conflicts),
 	}
+	ret.scheduler = newGratuitousScheduler(func(ip net.IP) error {
+		addr, err := netip.ParseAddr(ip.String())
+		if err != nil {
+			return err
+		}
+		return ret.Gratuitous(addr)
+	})
 	return ret, nil
 }
 
@@ -54,16 +73,17 @@ func (n *ndpAnnouncer) AddAnnouncedIP(ip net.IP) error {
 		return fmt.Errorf(" ip: %s join multicastgroup err", ip)
 	}
 
-	if err := n.Gratuitous(svcIP); err != nil {
-		return err
+	if n.getMac(ip.String()) == nil {
+		n.setMac(ip.String(), n.intf.HardwareAddr)
+		klog.Infof("store ingress ip related node ip and mac. %s-%s", ip.String(), n.intf.HardwareAddr.String())
 	}
 
-	metrics.UpdateGratuitousSentMetrics(ip.String())
-	return nil
+	return n.scheduler.announce(ip, n.announceConfig(ip))
 }
 
 func (n *ndpAnnouncer) DelAnnouncedIP(ip net.IP) error {
 	klog.Infof("cancel respone %s's ndp packet", ip)
+	n.scheduler.stop(ip.String())
 	n.lock.Lock()
 	defer n.lock.Unlock()
 
@@ -115,6 +135,7 @@ func (n *ndpAnnouncer) LeaveMulticastGroup(ip netip.Addr) error {
 }
 
 func (n *ndpAnnouncer) Start() error {
+	n.conflicts.start()
 	go func() {
 		for {
 			select {
@@ -134,12 +155,17 @@ func (n *ndpAnnouncer) Start() error {
 }
 
 func (n *ndpAnnouncer) Stop() error {
+	n.scheduler.stopAll()
+	n.conflicts.stop()
 	n.conn.Close()
 	n.stopCh <- struct{}{}
 	return nil
 }
 
 func (n *ndpAnnouncer) ContainsIP(ip net.IP) bool {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
 	for _, r := range n.ipranges {
 		if r.Contains(ip) {
 			return true
@@ -148,28 +174,50 @@ func (n *ndpAnnouncer) ContainsIP(ip net.IP) bool {
 	return false
 }
 
-func (n *ndpAnnouncer) RegisterIPRange(name string, r iprange.Range) {
+func (n *ndpAnnouncer) RegisterIPRange(name string, r iprange.Range, config speaker.AnnounceConfig) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	n.ipranges[name] = r
+	n.configs[name] = config
+}
+
+func (n *ndpAnnouncer) announceConfig(ip net.IP) speaker.AnnounceConfig {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
+	for name, r := range n.ipranges {
+		if r.Contains(ip) {
+			return n.configs[name]
+		}
+	}
+	return speaker.AnnounceConfig{BurstCount: 1}
 }
 
 func (n *ndpAnnouncer) UnregisterIPRange(name string) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
 	r, exist := n.ipranges[name]
 	if !exist {
 		return
 	}
 
-	n.lock.RLock()
-	defer n.lock.RUnlock()
-	for ip, _ := range n.ip2mac {
+	for ip := range n.ip2mac {
 		if r.Contains(net.ParseIP(ip)) {
+			n.scheduler.stop(ip)
 			delete(n.ip2mac, ip)
 		}
 	}
 
 	delete(n.ipranges, name)
+	delete(n.configs, name)
 }
 
 func (n *ndpAnnouncer) Size() int {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+
 	return len(n.ipranges)
 }
 
@@ -184,6 +232,11 @@ func (n *ndpAnnouncer) processRequest() dropReason {
 		return dropReasonError
 	}
 
+	if na, ok := msg.(*ndp.NeighborAdvertisement); ok {
+		n.checkConflict(na)
+		return dropReasonNotNeighborSolicitation
+	}
+
 	ns, ok := msg.(*ndp.NeighborSolicitation)
 	if !ok {
 		return dropReasonNotNeighborSolicitation
@@ -220,6 +273,32 @@ func (n *ndpAnnouncer) processRequest() dropReason {
 	return dropReasonNone
 }
 
+// checkConflict reports advertisements of other hosts for announced ips.
+func (n *ndpAnnouncer) checkConflict(na *ndp.NeighborAdvertisement) {
+	if n.getMac(na.TargetAddress.String()) == nil {
+		return
+	}
+
+	for _, o := range na.Options {
+		lla, ok := o.(*ndp.LinkLayerAddress)
+		if !ok || lla.Direction != ndp.Target || bytes.Equal(lla.Addr, n.intf.HardwareAddr) {
+			continue
+		}
+
+		klog.Warningf("interface %s got NDP advertisement of %s from %s", n.intf.Name, na.TargetAddress, lla.Addr)
+		n.conflicts.add(net.IP(na.TargetAddress.AsSlice()), lla.Addr)
+		return
+	}
+}
+
+func (n *ndpAnnouncer) Reassert(ip net.IP) error {
+	return n.scheduler.reassert(ip)
+}
+
+func (n *ndpAnnouncer) IsAnnounced(ip net.IP) bool {
+	return n.getMac(ip.String()) != nil
+}
+
 func (n *ndpAnnouncer) getMac(ip string) *net.HardwareAddr {
 	n.lock.RLock()
 	defer n.lock.RUnlock()
@@ -256,20 +335,18 @@ func generateNDP(gratuitous bool, srcHW net.HardwareAddr, srcIP netip.Addr) *ndp
 	return na
 }
 
+// Gratuitous sends an unsolicited neighbor advertisement for the ip.
 func (n *ndpAnnouncer) Gratuitous(ip netip.Addr) error {
-	if n.getMac(ip.String()) != nil {
-		return nil
-	}
-
-	n.setMac(ip.String(), n.intf.HardwareAddr)
-	klog.Infof("store ingress ip related node ip and mac. %s-%s", ip.String(), n.intf.HardwareAddr.String())
-
 	addr, err := netip.ParseAddr(net.IPv6linklocalallnodes.String())
 	if err != nil {
 		return fmt.Errorf("parse IPv6linklocalallnodes: %v", err)
 	}
 
-	klog.Infof("send gratuitous ndp packet: %s-%s", ip, n.intf.HardwareAddr)
+	klog.V(4).Infof("send gratuitous ndp packet: %s-%s", ip, n.intf.HardwareAddr)
 	na := generateNDP(true, n.intf.HardwareAddr, ip)
-	return n.conn.WriteTo(na, nil, addr)
+	if err := n.conn.WriteTo(na, nil, addr); err != nil {
+		return err
+	}
+	metrics.UpdateGratuitousSentMetrics(ip.String())
+	return nil
 }
@@ -1,6 +1,8 @@
 package layer2
 
 import (
+	"time"
+
 	"github.com/openelb/openelb/pkg/constant"
 	"github.com/openelb/openelb/pkg/util"
 	"github.com/spf13/pflag"
@@ -12,15 +14,23 @@ type Options struct {
 	BindAddr     string
 	BindPort     int
 	SecretKey    string
+	// deadline of the failover after a memberlist event
+	FailoverTimeout time.Duration
+	// interval the speaker pods are joined again at
+	RejoinInterval time.Duration
+	// duration of the leases of the announced ips, 0 disables them
+	LeaseDuration time.Duration
 }
 
 func NewOptions() *Options {
 	return &Options{
-		EnableLayer2: false,
-		NodeName:     util.GetNodeName(),
-		BindAddr:     "0.0.0.0",
-		BindPort:     7946,
-		SecretKey:    constant.Layer2MemberlistDefaultSecret,
+		EnableLayer2:    false,
+		NodeName:        util.GetNodeName(),
+		BindAddr:        "0.0.0.0",
+		BindPort:        7946,
+		SecretKey:       constant.Layer2MemberlistDefaultSecret,
+		FailoverTimeout: 3 * time.Second,
+		RejoinInterval:  30 * time.Second,
 	}
 }
 
@@ -30,4 +40,7 @@ func (v *Options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&v.BindAddr, "bind-addr", v.BindAddr, "specify the port on which the member list listens")
 	fs.IntVar(&v.BindPort, "bind-port", v.BindPort, "specify the address where the member list listens")
 	fs.StringVar(&v.SecretKey, "secret", v.SecretKey, "specify the memberlist's secret")
+	fs.DurationVar(&v.RejoinInterval, "rejoin-interval", v.RejoinInterval, "specify the interval the speakers missing in the member list are joined again at")
+	fs.DurationVar(&v.LeaseDuration, "layer2-lease-duration", v.LeaseDuration, "specify the duration of the leases the elected node needs to hold to announce an ip, the leases arbitrate when the member lists of the speakers disagree and delay the failover until they expire, 0 disables them")
+	fs.DurationVar(&v.FailoverTimeout, "failover-timeout", v.FailoverTimeout, "specify the deadline of the layer2 failover after a memberlist event, all eips are resynced if it is exceeded")
 }
@@ -22,6 +22,7 @@ import (
 
 	"github.com/openelb/openelb/pkg/constant"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -32,6 +33,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
@@ -51,13 +53,16 @@ func IsOpenELBService(svc *corev1.Service) bool {
 	return svc.Annotations[constant.OpenELBAnnotationKey] == constant.OpenELBAnnotationValue
 }
 
+// shouldReconcileEP returns whether the endpoint slice belongs to an OpenELB
+// service with Local traffic policy, whose nodes follow the endpoints.
 func (r *LBReconciler) shouldReconcileEP(e metav1.Object) bool {
-	if e.GetAnnotations()["control-plane.alpha.kubernetes.io/leader"] != "" {
+	name := e.GetLabels()[discoveryv1.LabelServiceName]
+	if name == "" {
 		return false
 	}
 
 	svc := &corev1.Service{}
-	err := r.Get(context.TODO(), types.NamespacedName{Namespace: e.GetNamespace(), Name: e.GetName()}, svc)
+	err := r.Get(context.TODO(), types.NamespacedName{Namespace: e.GetNamespace(), Name: name}, svc)
 	if err != nil {
 		return !errors.IsNotFound(err)
 	}
@@ -68,6 +73,15 @@ func (r *LBReconciler) shouldReconcileEP(e metav1.Object) bool {
 	return false
 }
 
+// serviceOfSlice maps an endpoint slice to its service.
+func serviceOfSlice(ctx context.Context, obj client.Object) []reconcile.Request {
+	name := obj.GetLabels()[discoveryv1.LabelServiceName]
+	if name == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: obj.GetNamespace(), Name: name}}}
+}
+
 func (r *LBReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	p := predicate.Funcs{
 		CreateFunc: func(e event.CreateEvent) bool {
@@ -97,7 +111,6 @@ func (r *LBReconciler) SetupWithManager(mgr ctrl.Manager) error {
 
 	ctl, err := ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
-		Owns(&corev1.Endpoints{}).
 		WithEventFilter(p).
 		Named("LBController").
 		Build(r)
@@ -105,7 +118,7 @@ func (r *LBReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
-	//endpoints
+	//endpointslices
 	ep := predicate.Funcs{
 		UpdateFunc: func(e event.UpdateEvent) bool {
 			return r.shouldReconcileEP(e.ObjectNew)
@@ -118,7 +131,7 @@ func (r *LBReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		},
 	}
 
-	return ctl.Watch(source.Kind(mgr.GetCache(), &corev1.Endpoints{}), &handler.EnqueueRequestForObject{}, ep)
+	return ctl.Watch(source.Kind(mgr.GetCache(), &discoveryv1.EndpointSlice{}), handler.EnqueueRequestsFromMapFunc(serviceOfSlice), ep)
 }
 
 //+kubebuilder:rbac:groups=network.kubesphere.io,resources=bgpconfs,verbs=get;list;watch;create;update;patch;delete
@@ -0,0 +1,141 @@
+package speaker
+
+import (
+	"context"
+	"sort"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/openelb/openelb/pkg/client"
+	"github.com/openelb/openelb/pkg/constant"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+)
+
+var _ = Describe("Speaker endpoint slices", func() {
+	yes, no := true, false
+
+	endpoint := func(addr, node string, ready bool) discoveryv1.Endpoint {
+		ep := discoveryv1.Endpoint{
+			Addresses: []string{addr},
+			NodeName:  &node,
+		}
+		if !ready {
+			ep.Conditions = discoveryv1.EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes}
+		}
+		return ep
+	}
+
+	ipv4 := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name + "-ipv4",
+			Namespace: svc.Namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: svc.Name},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints: []discoveryv1.Endpoint{
+			endpoint("10.1.0.1", node1.Name, true),
+			endpoint("10.1.0.2", node2.Name, false),
+		},
+	}
+
+	ipv6 := &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name + "-ipv6",
+			Namespace: svc.Namespace,
+			Labels:    map[string]string{discoveryv1.LabelServiceName: svc.Name},
+		},
+		AddressType: discoveryv1.AddressTypeIPv6,
+		Endpoints: []discoveryv1.Endpoint{
+			endpoint("fd00::3", node3.Name, true),
+		},
+	}
+
+	serviceNodes := func() []string {
+		nodes, err := spmanager.getServiceNodes(context.Background(), constant.OpenELBProtocolLayer2,
+			"192.168.0.100", svc.Namespace+"/"+svc.Name)
+		if err != nil {
+			return nil
+		}
+
+		var names []string
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	drain := func() {
+		for {
+			select {
+			case <-handled:
+			default:
+				return
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		for _, slice := range []*discoveryv1.EndpointSlice{ipv4, ipv6} {
+			Expect(client.Client.Create(context.Background(), slice.DeepCopy())).Should(Succeed())
+		}
+	})
+
+	AfterEach(func() {
+		for _, slice := range []*discoveryv1.EndpointSlice{ipv4, ipv6} {
+			err := client.Client.Delete(context.Background(), slice.DeepCopy())
+			Expect(err == nil || k8serrors.IsNotFound(err)).Should(BeTrue())
+		}
+		Eventually(serviceNodes).Should(BeEmpty())
+	})
+
+	It("Should use the ready endpoints of all address families", func() {
+		Eventually(serviceNodes).Should(Equal([]string{node1.Name, node3.Name}))
+	})
+
+	It("Should drop the nodes with only terminating endpoints", func() {
+		Eventually(serviceNodes).Should(Equal([]string{node1.Name, node3.Name}))
+
+		drain()
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			slice := &discoveryv1.EndpointSlice{}
+			if err := client.Client.Get(context.Background(), types.NamespacedName{Namespace: ipv4.Namespace, Name: ipv4.Name}, slice); err != nil {
+				return err
+			}
+			slice.Endpoints = []discoveryv1.Endpoint{
+				endpoint("10.1.0.1", node1.Name, false),
+				endpoint("10.1.0.2", node2.Name, true),
+			}
+			return client.Client.Update(context.Background(), slice)
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(handled).Should(Receive(Equal(types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name})))
+		Eventually(serviceNodes).Should(Equal([]string{node2.Name, node3.Name}))
+	})
+
+	It("Should drop the serving nodes while no endpoint is ready", func() {
+		Eventually(serviceNodes).Should(Equal([]string{node1.Name, node3.Name}))
+
+		err := client.Client.Delete(context.Background(), ipv6.DeepCopy())
+		Expect(err).ToNot(HaveOccurred())
+
+		err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			slice := &discoveryv1.EndpointSlice{}
+			if err := client.Client.Get(context.Background(), types.NamespacedName{Namespace: ipv4.Namespace, Name: ipv4.Name}, slice); err != nil {
+				return err
+			}
+			slice.Endpoints = []discoveryv1.Endpoint{
+				endpoint("10.1.0.2", node2.Name, false),
+			}
+			return client.Client.Update(context.Background(), slice)
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		Eventually(serviceNodes).Should(BeEmpty())
+	})
+})
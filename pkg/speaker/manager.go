@@ -2,18 +2,21 @@ package speaker
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net"
 	"reflect"
 	"sort"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/openelb/openelb/api/v1alpha2"
 	"github.com/openelb/openelb/pkg/constant"
 	"github.com/openelb/openelb/pkg/util"
 	"github.com/openelb/openelb/pkg/util/iprange"
 	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
@@ -30,9 +33,11 @@ type Manager struct {
 	client.Client
 	record.EventRecorder
 
-	mgr       manager.Manager
-	speakers  map[string]speakerWithCancelFunc
-	pools     map[string]*v1alpha2.Eip
+	mgr      manager.Manager
+	speakers map[string]speakerWithCancelFunc
+	pools    map[string]*v1alpha2.Eip
+	// configs the speakers were configured with, keyed by eip name
+	configs   map[string]Config
 	waitGroup sync.WaitGroup
 	errChan   chan error
 }
@@ -44,6 +49,7 @@ func NewSpeakerManager(mgr manager.Manager) *Manager {
 		EventRecorder: mgr.GetEventRecorderFor("speakerManager"),
 		speakers:      make(map[string]speakerWithCancelFunc, 0),
 		pools:         make(map[string]*v1alpha2.Eip, 0),
+		configs:       make(map[string]Config),
 		errChan:       make(chan error),
 	}
 }
@@ -166,7 +172,19 @@ func (m *Manager) HandleEIP(ctx context.Context, eip *v1alpha2.Eip) error {
 		if err := m.delBalancer(ctx, eip.GetProtocol(), del); err != nil {
 			return err
 		}
-		if err := m.setBalancer(ctx, eip.GetProtocol(), add); err != nil {
+		// a balanced assignment depends on all used ips, elect them again
+		if eip.Spec.Layer2Election != nil && eip.Spec.Layer2Election.Balanced {
+			c, err := m.speakerConfig(ctx, eip)
+			if err != nil {
+				return err
+			}
+			if err := m.speakers[eip.GetProtocol()].ConfigureWithEIP(c, false); err != nil {
+				return err
+			}
+			m.configs[eip.GetName()] = c
+			add = eip.Status.Used
+		}
+		if err := m.setBalancer(ctx, eip, add); err != nil {
 			return err
 		}
 		m.pools[eip.GetName()] = eip
@@ -177,16 +195,140 @@ func (m *Manager) HandleEIP(ctx context.Context, eip *v1alpha2.Eip) error {
 }
 
 // update speaker configurate
-// protocol change or interface change
+// protocol change, interface change, aggregation, vrf or layer2 settings change
 func (m *Manager) isSpeakerConfigUpdate(old, new v1alpha2.EipSpec) bool {
 	if old.Protocol != new.Protocol {
 		return true
 	}
 
-	if new.Protocol != constant.OpenELBProtocolBGP && old.Interface != new.Interface {
+	if new.Protocol != constant.OpenELBProtocolBGP &&
+		(old.Interface != new.Interface || !reflect.DeepEqual(old.NodeInterfaces, new.NodeInterfaces)) {
 		return true
 	}
-	return false
+
+	if old.AggregationLength != new.AggregationLength || old.Vrf != new.Vrf {
+		return true
+	}
+
+	if new.Protocol != constant.OpenELBProtocolLayer2 {
+		return false
+	}
+	if !reflect.DeepEqual(old.Layer2Announce, new.Layer2Announce) || !reflect.DeepEqual(old.Layer2Interface, new.Layer2Interface) {
+		return true
+	}
+	// the election ranks the nodes announcing the addresses again
+	return !reflect.DeepEqual(old.Layer2Election, new.Layer2Election)
+}
+
+func eipConfig(eip *v1alpha2.Eip, r iprange.Range) Config {
+	used := make([]string, 0, len(eip.Status.Used))
+	for ip := range eip.Status.Used {
+		used = append(used, ip)
+	}
+	sort.Strings(used)
+
+	c := Config{
+		Name:           eip.Name,
+		UID:            eip.UID,
+		Iface:          eip.Spec.Interface,
+		IPRange:        r,
+		Vrf:            eip.Spec.Vrf,
+		Announce:       announceConfig(eip),
+		Layer2Election: eip.Spec.Layer2Election,
+		Used:           used,
+	}
+	if i := eip.Spec.Layer2Interface; i != nil {
+		c.VlanID = i.VlanID
+	}
+	return c
+}
+
+// speakerConfig returns the config of the eip with the interface of this node.
+func (m *Manager) speakerConfig(ctx context.Context, eip *v1alpha2.Eip) (Config, error) {
+	r, err := iprange.ParseRange(eip.Spec.Address)
+	if err != nil {
+		return Config{}, err
+	}
+
+	c := eipConfig(eip, r)
+	if eip.GetProtocol() == constant.OpenELBProtocolBGP {
+		return c, nil
+	}
+
+	node := &corev1.Node{}
+	if err := m.Get(ctx, types.NamespacedName{Name: util.GetNodeName()}, node); err != nil {
+		return c, err
+	}
+	iface, err := eip.InterfaceOfNode(node)
+	if err != nil {
+		return c, err
+	}
+	if i := eip.Spec.Layer2Interface; i != nil {
+		c.SourceAddress = net.ParseIP(i.SourceAddresses[node.Name])
+	}
+	c.Iface, err = ResolveNodeInterface(iface, node)
+	return c, err
+}
+
+// reportInterface records the interface of this node, or the error resolving
+// it, in the status of the eip.
+func (m *Manager) reportInterface(ctx context.Context, eip *v1alpha2.Eip, iface string, err error) {
+	if eip.GetProtocol() == constant.OpenELBProtocolBGP {
+		return
+	}
+
+	status := v1alpha2.NodeInterfaceStatus{}
+	if err == nil {
+		var netif *net.Interface
+		if netif, err = ParseInterface(iface); err == nil {
+			status.Interface = netif.Name
+		}
+	}
+	if err != nil {
+		status.Error = err.Error()
+	}
+
+	node := util.GetNodeName()
+	if current, ok := eip.Status.NodeInterfaces[node]; ok && current == status {
+		return
+	}
+
+	// null removes the field left from the previous status
+	value := map[string]interface{}{"interface": nil, "error": nil}
+	if status.Interface != "" {
+		value["interface"] = status.Interface
+	}
+	if status.Error != "" {
+		value["error"] = status.Error
+	}
+	patch, _ := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{
+			"nodeInterfaces": map[string]interface{}{node: value},
+		},
+	})
+	if err := m.Status().Patch(ctx, eip.DeepCopy(), client.RawPatch(types.MergePatchType, patch)); err != nil {
+		klog.Warningf("failed to report the interface of eip %s: %v", eip.Name, err)
+	}
+}
+
+func announceConfig(eip *v1alpha2.Eip) AnnounceConfig {
+	c := AnnounceConfig{BurstCount: 1, BurstInterval: time.Second}
+	a := eip.Spec.Layer2Announce
+	if a == nil {
+		return c
+	}
+
+	if a.BurstCount > 0 {
+		c.BurstCount = a.BurstCount
+	}
+	if a.BurstInterval != nil {
+		c.BurstInterval = a.BurstInterval.Duration
+	}
+	if a.RefreshInterval != nil {
+		c.RefreshInterval = a.RefreshInterval.Duration
+	}
+	c.ConflictPolicy = a.ConflictPolicy
+	return c
 }
 
 func (m *Manager) delBalancerWithEIP(ctx context.Context, eip *v1alpha2.Eip) error {
@@ -194,16 +336,24 @@ func (m *Manager) delBalancerWithEIP(ctx context.Context, eip *v1alpha2.Eip) err
 		return err
 	}
 
-	r, err := iprange.ParseRange(eip.Spec.Address)
-	if err != nil {
+	if err := m.delAggregateBalancer(eip); err != nil {
 		return err
 	}
 
-	c := Config{Name: eip.Name, Iface: eip.Spec.Interface, IPRange: r}
+	// unconfigure the interface configured before, the node may have changed
+	c, exist := m.configs[eip.GetName()]
+	if !exist {
+		var err error
+		if c, err = m.speakerConfig(ctx, eip); err != nil {
+			return err
+		}
+	}
+
 	if err := m.speakers[eip.GetProtocol()].ConfigureWithEIP(c, true); err != nil {
 		m.Event(eip, corev1.EventTypeWarning, "ConfigSpeakerFailed", err.Error())
 		return err
 	}
+	delete(m.configs, eip.GetName())
 	m.Event(eip, corev1.EventTypeNormal, "ConfigSpeaker", fmt.Sprintf("unconfig openelb %s speaker successfully", eip.GetProtocol()))
 	return nil
 }
@@ -222,27 +372,114 @@ func (m *Manager) delBalancer(ctx context.Context, protocol string, usage map[st
 }
 
 func (m *Manager) setBalancerWithEIP(ctx context.Context, eip *v1alpha2.Eip) error {
-	r, err := iprange.ParseRange(eip.Spec.Address)
+	c, err := m.speakerConfig(ctx, eip)
+	if err == nil {
+		err = m.speakers[eip.GetProtocol()].ConfigureWithEIP(c, false)
+	}
+	m.reportInterface(ctx, eip, c.Iface, err)
 	if err != nil {
+		m.Event(eip, corev1.EventTypeWarning, "ConfigSpeakerFailed", err.Error())
 		return err
 	}
+	m.configs[eip.GetName()] = c
+	m.Event(eip, corev1.EventTypeNormal, "ConfigSpeaker", fmt.Sprintf("config openelb %s speaker successfully", eip.GetProtocol()))
 
-	c := Config{Name: eip.Name, Iface: eip.Spec.Interface, IPRange: r}
-	if err := m.speakers[eip.GetProtocol()].ConfigureWithEIP(c, false); err != nil {
-		m.Event(eip, corev1.EventTypeWarning, "ConfigSpeakerFailed", err.Error())
+	if err := m.setAggregateBalancer(ctx, eip); err != nil {
 		return err
 	}
-	m.Event(eip, corev1.EventTypeNormal, "ConfigSpeaker", fmt.Sprintf("config openelb %s speaker successfully", eip.GetProtocol()))
 
-	if err := m.setBalancer(ctx, eip.GetProtocol(), eip.Status.Used); err != nil {
+	if err := m.setBalancer(ctx, eip, eip.Status.Used); err != nil {
 		return err
 	}
 	return nil
 }
 
-func (m *Manager) setBalancer(ctx context.Context, protocol string, usage map[string]string) error {
+// setAggregateBalancer advertises the aggregated prefixes of the eip from all nodes.
+func (m *Manager) setAggregateBalancer(ctx context.Context, eip *v1alpha2.Eip) error {
+	if !eip.IsAggregated() {
+		return nil
+	}
+
+	prefixes, err := aggregatePrefixes(eip)
+	if err != nil {
+		return err
+	}
+
+	nodeList := &corev1.NodeList{}
+	if err := m.List(ctx, nodeList); err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if err := m.speakers[eip.GetProtocol()].SetBalancer(prefix, announceNodes(nodeList.Items)); err != nil {
+			m.Event(eip, corev1.EventTypeWarning, "SetBalancer", err.Error())
+			return err
+		}
+	}
+
+	m.Event(eip, corev1.EventTypeNormal, "SetBalancer", fmt.Sprintf("success to advertise aggregated prefixes [%s]", strings.Join(prefixes, ", ")))
+	return nil
+}
+
+func (m *Manager) delAggregateBalancer(eip *v1alpha2.Eip) error {
+	if !eip.IsAggregated() {
+		return nil
+	}
+
+	prefixes, err := aggregatePrefixes(eip)
+	if err != nil {
+		return err
+	}
+
+	for _, prefix := range prefixes {
+		if err := m.speakers[eip.GetProtocol()].DelBalancer(prefix); err != nil {
+			m.Event(eip, corev1.EventTypeWarning, "DelBalancer", err.Error())
+			return err
+		}
+	}
+
+	m.Event(eip, corev1.EventTypeNormal, "DelBalancer", fmt.Sprintf("success to withdraw aggregated prefixes [%s]", strings.Join(prefixes, ", ")))
+	return nil
+}
+
+func aggregatePrefixes(eip *v1alpha2.Eip) ([]string, error) {
+	r, err := iprange.ParseRange(eip.Spec.Address)
+	if err != nil {
+		return nil, err
+	}
+
+	nets, err := iprange.Prefixes(r, eip.Spec.AggregationLength)
+	if err != nil {
+		return nil, err
+	}
+
+	prefixes := []string{}
+	for _, n := range nets {
+		prefixes = append(prefixes, n.String())
+	}
+	return prefixes, nil
+}
+
+func (m *Manager) setBalancer(ctx context.Context, eip *v1alpha2.Eip, usage map[string]string) error {
+	protocol := eip.GetProtocol()
 	for ip, value := range usage {
-		nodes, err := m.getServiceNodes(ctx, ip, value)
+		if eip.IsAggregated() {
+			local, err := m.hasLocalTrafficPolicy(ctx, value)
+			if err != nil {
+				return err
+			}
+
+			// the ip is covered by the aggregated prefixes, only drop a host route
+			// that may be left over from a service with Local traffic policy
+			if !local {
+				if err := m.speakers[protocol].DelBalancer(ip); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+
+		nodes, err := m.getServiceNodes(ctx, protocol, ip, value)
 		if err != nil {
 			return err
 		}
@@ -251,6 +488,10 @@ func (m *Manager) setBalancer(ctx context.Context, protocol string, usage map[st
 			warnStr := fmt.Sprintf("no available nodes for service ip %s:%s", ip, value)
 			m.addSvcEventRecorder(ctx, value, corev1.EventTypeWarning, "SetBalancer", warnStr)
 			klog.Warning(warnStr)
+			// the nodes announcing it before may have been excluded since
+			if err := m.speakers[protocol].DelBalancer(ip); err != nil {
+				return err
+			}
 			continue
 		}
 
@@ -319,7 +560,7 @@ func (m *Manager) HandleService(ctx context.Context, svc *corev1.Service) error
 		}
 	}
 
-	if err := m.setBalancer(ctx, eip.GetProtocol(), ingress); err != nil {
+	if err := m.setBalancer(ctx, eip, ingress); err != nil {
 		return err
 	}
 	return nil
@@ -335,8 +576,32 @@ func (m *Manager) getSvcEIPUsed(svc *corev1.Service) string {
 	return svc.Annotations[constant.OpenELBEIPAnnotationKeyV1Alpha2]
 }
 
-func (m *Manager) getServiceNodes(ctx context.Context, ip, svcs string) ([]corev1.Node, error) {
-	nodeSets := map[string]corev1.Node{}
+func (m *Manager) hasLocalTrafficPolicy(ctx context.Context, svcs string) (bool, error) {
+	for _, str := range strings.Split(svcs, ";") {
+		svcInfo := strings.Split(str, "/")
+		if len(svcInfo) != 2 {
+			continue
+		}
+
+		svc := &corev1.Service{}
+		if err := m.Get(ctx, types.NamespacedName{Namespace: svcInfo[0], Name: svcInfo[1]}, svc); err != nil {
+			return false, err
+		}
+
+		if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// getServiceNodes returns the nodes allowed to announce the ip of the
+// services. Services with Local traffic policy limit them to the nodes with
+// ready endpoints. A layer2 ip is announced by a single node, which has to
+// serve all services sharing the ip, while the next hops of the other
+// protocols serve any of them.
+func (m *Manager) getServiceNodes(ctx context.Context, protocol, ip, svcs string) ([]corev1.Node, error) {
 	nodeList := &corev1.NodeList{}
 	if err := m.List(ctx, nodeList); err != nil {
 		return nil, err
@@ -348,6 +613,8 @@ func (m *Manager) getServiceNodes(ctx context.Context, ip, svcs string) ([]corev
 		share = true
 	}
 
+	// nodes allowed by each service, nil allows all nodes
+	allowed := []map[string]bool{}
 	for _, str := range svcArray {
 		//1. filter endpoints
 		svcInfo := strings.Split(str, "/")
@@ -359,64 +626,168 @@ func (m *Manager) getServiceNodes(ctx context.Context, ip, svcs string) ([]corev
 		if err := m.Get(ctx, types.NamespacedName{Namespace: svcInfo[0], Name: svcInfo[1]}, svc); err != nil {
 			return nil, err
 		}
-		endpoints := &corev1.Endpoints{}
-		if err := m.Get(ctx, types.NamespacedName{Namespace: svc.GetNamespace(), Name: svc.GetName()}, endpoints); err != nil {
+
+		//2. get next hops
+		if svc.Spec.ExternalTrafficPolicy != corev1.ServiceExternalTrafficPolicyTypeLocal {
+			allowed = append(allowed, nil)
+			continue
+		}
+
+		if share {
+			klog.Warningf("service %s's ExternalTrafficPolicyType is Local, but specify %s as a shared ip", svc.GetName(), ip)
+		}
+
+		active, err := m.endpointNodes(ctx, svc)
+		if err != nil {
 			return nil, err
 		}
+		if len(active) == 0 {
+			klog.Warningf("service %s's ExternalTrafficPolicyType is Local, but it has no ready endpoints on any node", svc.GetName())
+			continue
+		}
+		allowed = append(allowed, active)
+	}
 
-		//2. get next hops
-		if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
-			if share {
-				klog.Warningf("service %s's ExternalTrafficPolicyType is Local, but specify %s as a shared ip", svc.GetName(), ip)
-			}
+	if len(allowed) == 0 {
+		return []corev1.Node{}, nil
+	}
 
-			active := make(map[string]bool)
-			for _, subnet := range endpoints.Subsets {
-				for _, addr := range subnet.Addresses {
-					if addr.NodeName == nil {
-						continue
-					}
-					active[*addr.NodeName] = true
-				}
-			}
+	resultNodes := []corev1.Node{}
+	for _, node := range nodeList.Items {
+		if nodeAllowed(node.Name, allowed, protocol == constant.OpenELBProtocolLayer2) {
+			resultNodes = append(resultNodes, node)
+		}
+	}
+	return announceNodes(resultNodes), nil
+}
+
+// endpointNodes returns the nodes with ready endpoints of the service, the
+// slices of all address families are merged. Terminating endpoints are not
+// ready, so their nodes are dropped at once, even if they are still serving.
+func (m *Manager) endpointNodes(ctx context.Context, svc *corev1.Service) (map[string]bool, error) {
+	slices := &discoveryv1.EndpointSliceList{}
+	if err := m.List(ctx, slices, client.InNamespace(svc.GetNamespace()),
+		client.MatchingLabels{discoveryv1.LabelServiceName: svc.GetName()}); err != nil {
+		return nil, err
+	}
 
-			if len(active) == 0 {
-				klog.Warningf("service %s's ExternalTrafficPolicyType is Local, and endpoint don't have nodeName, Please make sure the endpoints are configured correctly", svc.GetName())
+	ready := make(map[string]bool)
+	for _, slice := range slices.Items {
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+
+		for _, ep := range slice.Endpoints {
+			if ep.NodeName == nil {
 				continue
 			}
-
-			for _, node := range nodeList.Items {
-				if active[node.Name] {
-					nodeSets[node.Name] = node
-				}
+			if endpointReady(ep.Conditions) {
+				ready[*ep.NodeName] = true
 			}
+		}
+	}
 
-		} else {
-			for _, node := range nodeList.Items {
-				nodeSets[node.Name] = node
-			}
+	return ready, nil
+}
+
+// endpointReady returns whether the endpoint is ready, an unknown condition
+// counts as ready.
+func endpointReady(c discoveryv1.EndpointConditions) bool {
+	if c.Terminating != nil && *c.Terminating {
+		return false
+	}
+	return c.Ready == nil || *c.Ready
+}
+
+// nodeAllowed returns whether all services allow the node if all is set, and
+// whether any of them does otherwise.
+func nodeAllowed(node string, allowed []map[string]bool, all bool) bool {
+	for _, nodes := range allowed {
+		ok := nodes == nil || nodes[node]
+		if ok != all {
+			return ok
 		}
 	}
+	return all
+}
 
-	resultNodes := []corev1.Node{}
-	for _, node := range nodeSets {
-		resultNodes = append(resultNodes, node)
+// announceNodes drops the nodes excluded from the announcements, and the
+// unschedulable or NotReady nodes unless no other node is left.
+func announceNodes(nodes []corev1.Node) []corev1.Node {
+	included := []corev1.Node{}
+	healthy := []corev1.Node{}
+	for _, node := range nodes {
+		if util.NodeExcluded(&node) {
+			continue
+		}
+		included = append(included, node)
+		if util.NodeAnnounceable(&node) {
+			healthy = append(healthy, node)
+		}
 	}
-	return resultNodes, nil
+
+	if len(healthy) == 0 {
+		return included
+	}
+	return healthy
 }
 
 func (m *Manager) ResyncEIPSpeaker(ctx context.Context) error {
+	return m.resyncEIPs(ctx, constant.OpenELBProtocolLayer2)
+}
+
+// ResyncNodes announces the eips of all protocols again once the nodes
+// allowed to announce them changed.
+func (m *Manager) ResyncNodes(ctx context.Context) error {
+	m.resyncInterfaces(ctx)
+	return m.resyncEIPs(ctx, "")
+}
+
+// resyncInterfaces configures the eips whose interface on this node changed
+// again.
+func (m *Manager) resyncInterfaces(ctx context.Context) {
+	for name, eip := range m.pools {
+		if eip.GetProtocol() == constant.OpenELBProtocolBGP {
+			continue
+		}
+
+		c, err := m.speakerConfig(ctx, eip)
+		if err == nil && c.Iface == m.configs[name].Iface {
+			continue
+		}
+
+		klog.V(1).Infof("interface of eip %s changed from %q to %q", name, m.configs[name].Iface, c.Iface)
+		if err := m.delBalancerWithEIP(ctx, eip); err != nil {
+			klog.Warningf("resync interface of eip %s error: %s", name, err.Error())
+			continue
+		}
+		if err := m.setBalancerWithEIP(ctx, eip); err != nil {
+			klog.Warningf("resync interface of eip %s error: %s", name, err.Error())
+		}
+	}
+}
+
+// resyncEIPs sets the balancers of the eips handled so far, of the protocol
+// or all if empty.
+func (m *Manager) resyncEIPs(ctx context.Context, protocol string) error {
 	eips := &v1alpha2.EipList{}
 	if err := m.Client.List(ctx, eips, &client.ListOptions{}); err != nil {
 		return err
 	}
 
 	for _, e := range eips.Items {
-		if e.Spec.Protocol != constant.OpenELBProtocolLayer2 {
+		if protocol != "" && e.GetProtocol() != protocol {
+			continue
+		}
+		if _, exist := m.pools[e.Name]; !exist || !e.DeletionTimestamp.IsZero() {
 			continue
 		}
 
-		if err := m.setBalancer(ctx, constant.OpenELBProtocolLayer2, e.Status.Used); err != nil {
+		if err := m.setAggregateBalancer(ctx, &e); err != nil {
+			klog.Warningf("resync speaker error: %s", err.Error())
+		}
+
+		if err := m.setBalancer(ctx, &e, e.Status.Used); err != nil {
 			klog.Warningf("resync speaker error: %s", err.Error())
 		}
 	}
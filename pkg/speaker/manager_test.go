@@ -0,0 +1,162 @@
+package speaker
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/openelb/openelb/api/v1alpha2"
+	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestGetServiceNodes(t *testing.T) {
+	node := func(name string) *corev1.Node {
+		return &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	}
+	service := func(name string, policy corev1.ServiceExternalTrafficPolicyType) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, ExternalTrafficPolicy: policy},
+		}
+	}
+	yes, no := true, false
+	slice := func(name string, family discoveryv1.AddressType, ready, terminating []string) *discoveryv1.EndpointSlice {
+		result := &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: "default",
+				Name:      name + "-" + strings.ToLower(string(family)),
+				Labels:    map[string]string{discoveryv1.LabelServiceName: name},
+			},
+			AddressType: family,
+		}
+		for _, n := range ready {
+			n := n
+			result.Endpoints = append(result.Endpoints, discoveryv1.Endpoint{
+				Addresses: []string{"10.0.0.1"},
+				NodeName:  &n,
+			})
+		}
+		for _, n := range terminating {
+			n := n
+			result.Endpoints = append(result.Endpoints, discoveryv1.Endpoint{
+				Addresses:  []string{"10.0.0.2"},
+				NodeName:   &n,
+				Conditions: discoveryv1.EndpointConditions{Ready: &no, Serving: &yes, Terminating: &yes},
+			})
+		}
+		return result
+	}
+
+	m := &Manager{Client: fake.NewClientBuilder().WithObjects(
+		node("node1"), node("node2"), node("node3"),
+		service("local", corev1.ServiceExternalTrafficPolicyTypeLocal),
+		slice("local", discoveryv1.AddressTypeIPv4, []string{"node1"}, []string{"node3"}),
+		slice("local", discoveryv1.AddressTypeIPv6, []string{"node2"}, nil),
+		service("cluster", corev1.ServiceExternalTrafficPolicyTypeCluster),
+		slice("cluster", discoveryv1.AddressTypeIPv4, []string{"node1"}, nil),
+		service("idle", corev1.ServiceExternalTrafficPolicyTypeLocal),
+		service("draining", corev1.ServiceExternalTrafficPolicyTypeLocal),
+		slice("draining", discoveryv1.AddressTypeIPv4, nil, []string{"node3"}),
+		service("other", corev1.ServiceExternalTrafficPolicyTypeLocal),
+		slice("other", discoveryv1.AddressTypeIPv4, []string{"node2", "node3"}, nil),
+	).Build()}
+
+	tests := []struct {
+		name     string
+		protocol string
+		svcs     string
+		want     []string
+	}{
+		{"local", constant.OpenELBProtocolLayer2, "default/local", []string{"node1", "node2"}},
+		{"cluster", constant.OpenELBProtocolLayer2, "default/cluster", []string{"node1", "node2", "node3"}},
+		{"no endpoints", constant.OpenELBProtocolLayer2, "default/idle", nil},
+		{"only terminating endpoints", constant.OpenELBProtocolLayer2, "default/draining", nil},
+		{"layer2 shared", constant.OpenELBProtocolLayer2, "default/local;default/other", []string{"node2"}},
+		{"layer2 shared with cluster", constant.OpenELBProtocolLayer2, "default/local;default/cluster", []string{"node1", "node2"}},
+		{"bgp shared", constant.OpenELBProtocolBGP, "default/local;default/other", []string{"node1", "node2", "node3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nodes, err := m.getServiceNodes(context.Background(), tt.protocol, "192.168.0.100", tt.svcs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, n := range nodes {
+				got = append(got, n.Name)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("getServiceNodes() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetServiceNodesDrained(t *testing.T) {
+	// the speaker of node1 advertises the next hops of the other nodes as well
+	t.Setenv(constant.EnvNodeName, "node1")
+
+	drained := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:        "node2",
+		Annotations: map[string]string{constant.OpenELBNodeDrain: "true"},
+	}}
+	m := &Manager{Client: fake.NewClientBuilder().WithObjects(
+		&corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node1"}},
+		drained,
+		&corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "cluster"},
+			Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+		},
+	).Build()}
+
+	for _, protocol := range []string{constant.OpenELBProtocolBGP, constant.OpenELBProtocolLayer2} {
+		nodes, err := m.getServiceNodes(context.Background(), protocol, "192.168.0.100", "default/cluster")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(nodes) != 1 || nodes[0].Name != "node1" {
+			t.Errorf("getServiceNodes(%s) = %v, want the nodes without node2", protocol, nodes)
+		}
+	}
+}
+
+func TestIsSpeakerConfigUpdate(t *testing.T) {
+	layer2 := v1alpha2.EipSpec{
+		Protocol:       constant.OpenELBProtocolLayer2,
+		Interface:      "eth0",
+		Layer2Announce: &v1alpha2.Layer2Announce{BurstCount: 2},
+	}
+	m := &Manager{}
+
+	tests := []struct {
+		name   string
+		update func(spec *v1alpha2.EipSpec)
+		want   bool
+	}{
+		{"unchanged", func(spec *v1alpha2.EipSpec) {}, false},
+		{"interface", func(spec *v1alpha2.EipSpec) { spec.Interface = "eth1" }, true},
+		{"layer2 announce", func(spec *v1alpha2.EipSpec) { spec.Layer2Announce.BurstCount = 3 }, true},
+		{"layer2 announce removed", func(spec *v1alpha2.EipSpec) { spec.Layer2Announce = nil }, true},
+		{"layer2 election", func(spec *v1alpha2.EipSpec) {
+			spec.Layer2Election = &v1alpha2.Layer2Election{Nodes: []string{"node1"}}
+		}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec := *layer2.DeepCopy()
+			tt.update(&spec)
+			if got := m.isSpeakerConfigUpdate(layer2, spec); got != tt.want {
+				t.Errorf("isSpeakerConfigUpdate() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
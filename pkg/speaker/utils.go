@@ -1,14 +1,19 @@
 package speaker
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"strings"
 
 	"github.com/openelb/openelb/pkg/util/iprange"
 	"github.com/vishvananda/netlink"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// ParseInterface returns the interface named ifaceName, or the one found by
+// can_reach:<ip>, subnet:<cidr> or mac:<address>. label:<key> needs to be
+// resolved by ResolveNodeInterface first.
 func ParseInterface(ifaceName string) (iface *net.Interface, err error) {
 	strs := strings.SplitN(ifaceName, ":", 2)
 	if len(strs) == 1 {
@@ -35,13 +40,78 @@ func ParseInterface(ifaceName string) (iface *net.Interface, err error) {
 		if iface.Name == "lo" {
 			return nil, fmt.Errorf("invalid interface lo")
 		}
+	case "subnet":
+		_, subnet, err := net.ParseCIDR(strs[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid subnet %s", strs[1])
+		}
+		return findInterface(ifaceName, func(i net.Interface) bool {
+			addrs, err := i.Addrs()
+			if err != nil {
+				return false
+			}
+			for _, addr := range addrs {
+				if ipnet, ok := addr.(*net.IPNet); ok && subnet.Contains(ipnet.IP) {
+					return true
+				}
+			}
+			return false
+		})
+	case "mac":
+		mac, err := net.ParseMAC(strs[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid mac address %s", strs[1])
+		}
+		// vlans and bonds share the mac of their parent, prefer the interface
+		// owning it
+		iface, err = findInterface(ifaceName, func(i net.Interface) bool {
+			link, err := netlink.LinkByIndex(i.Index)
+			return err == nil && link.Type() == "device" && bytes.Equal(i.HardwareAddr, mac)
+		})
+		if err == nil {
+			return iface, nil
+		}
+		return findInterface(ifaceName, func(i net.Interface) bool {
+			return bytes.Equal(i.HardwareAddr, mac)
+		})
+	case "label":
+		return nil, fmt.Errorf("interface %s is not resolved for the node", ifaceName)
 	default:
-		return nil, fmt.Errorf("invalid interface string, now only support can_reach")
+		return nil, fmt.Errorf("invalid interface string, now only support can_reach, subnet, mac and label")
 	}
 
 	return iface, nil
 }
 
+func findInterface(ifaceName string, match func(net.Interface) bool) (*net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range ifaces {
+		if i.Flags&net.FlagLoopback == 0 && match(i) {
+			iface := i
+			return &iface, nil
+		}
+	}
+	return nil, fmt.Errorf("no interface matches %s", ifaceName)
+}
+
+// ResolveNodeInterface replaces label:<key> with the value of the node label key.
+func ResolveNodeInterface(ifaceName string, node *corev1.Node) (string, error) {
+	key, ok := strings.CutPrefix(ifaceName, "label:")
+	if !ok {
+		return ifaceName, nil
+	}
+
+	value := node.Labels[key]
+	if value == "" {
+		return "", fmt.Errorf("node %s has no label %s", node.Name, key)
+	}
+	return value, nil
+}
+
 func ValidateInterface(netif *net.Interface, r iprange.Range) error {
 	addrs, err := netif.Addrs()
 	if err != nil {
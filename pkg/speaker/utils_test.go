@@ -0,0 +1,74 @@
+package speaker
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestResolveNodeInterface(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+		Name:   "node1",
+		Labels: map[string]string{"openelb.kubesphere.io/interface": "ens192"},
+	}}
+
+	if got, err := ResolveNodeInterface("label:openelb.kubesphere.io/interface", node); err != nil || got != "ens192" {
+		t.Errorf("ResolveNodeInterface() = %s, %v, want ens192", got, err)
+	}
+	if got, err := ResolveNodeInterface("can_reach:192.168.0.1", node); err != nil || got != "can_reach:192.168.0.1" {
+		t.Errorf("ResolveNodeInterface() = %s, %v, want it unchanged", got, err)
+	}
+	if _, err := ResolveNodeInterface("label:missing", node); err == nil {
+		t.Errorf("ResolveNodeInterface() of a missing label should fail")
+	}
+}
+
+func TestParseInterface(t *testing.T) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+
+		got, err := ParseInterface("mac:" + iface.HardwareAddr.String())
+		if err != nil || !bytes.Equal(got.HardwareAddr, iface.HardwareAddr) {
+			t.Errorf("ParseInterface(mac:%s) = %v, %v", iface.HardwareAddr, got, err)
+		}
+
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			ipnet, ok := addr.(*net.IPNet)
+			if !ok || ipnet.IP.To4() == nil {
+				continue
+			}
+			got, err := ParseInterface("subnet:" + ipnet.String())
+			if err != nil {
+				t.Errorf("ParseInterface(subnet:%s) error: %v", ipnet, err)
+			} else if gotAddrs, _ := got.Addrs(); !containsNet(gotAddrs, ipnet) {
+				t.Errorf("ParseInterface(subnet:%s) = %s", ipnet, got.Name)
+			}
+		}
+	}
+
+	for _, name := range []string{"subnet:invalid", "mac:invalid", "label:key", "unknown:eth0", "subnet:203.0.113.0/24"} {
+		if _, err := ParseInterface(name); err == nil {
+			t.Errorf("ParseInterface(%s) should fail", name)
+		}
+	}
+}
+
+func containsNet(addrs []net.Addr, subnet *net.IPNet) bool {
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && subnet.Contains(ipnet.IP) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package iprange
+
+import (
+	"fmt"
+	"math/big"
+	"net"
+)
+
+// MaxPrefixes is the maximum number of networks Prefixes is allowed to return.
+const MaxPrefixes = 1024
+
+// Prefixes returns the networks with the given prefix length that cover the range.
+// The range must start and end on the boundaries of these networks, so that they
+// do not extend beyond it.
+func Prefixes(r Range, length int) ([]*net.IPNet, error) {
+	if r == nil {
+		return nil, fmt.Errorf("ip range is nil")
+	}
+
+	bits, start, end := 32, r.Start().To4(), r.End().To4()
+	if r.Family() == V6Family {
+		bits, start, end = 128, r.Start().To16(), r.End().To16()
+	}
+	if length < 1 || length > bits {
+		return nil, fmt.Errorf("prefix length %d is out of range [1, %d]", length, bits)
+	}
+	if prefixLen := commonPrefixLen(start, end, bits); length < prefixLen {
+		return nil, fmt.Errorf("prefix length %d is shorter than the prefix length %d of ip range (%s)",
+			length, prefixLen, r)
+	}
+
+	mask := net.CIDRMask(length, bits)
+	first := big.NewInt(0).SetBytes(start.Mask(mask))
+	last := big.NewInt(0).SetBytes(end.Mask(mask))
+	step := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-length))
+
+	// the last address of the last network
+	lastEnd := big.NewInt(0).Add(last, step)
+	lastEnd.Sub(lastEnd, big.NewInt(1))
+	if first.Cmp(big.NewInt(0).SetBytes(start)) != 0 || lastEnd.Cmp(big.NewInt(0).SetBytes(end)) != 0 {
+		return nil, fmt.Errorf("ip range (%s) is not aligned to networks of prefix length %d", r, length)
+	}
+
+	count := big.NewInt(0).Sub(last, first)
+	count.Div(count, step).Add(count, big.NewInt(1))
+	if count.Cmp(big.NewInt(MaxPrefixes)) > 0 {
+		return nil, fmt.Errorf("ip range (%s) splits into %s networks of length %d, more than %d",
+			r, count, length, MaxPrefixes)
+	}
+
+	var prefixes []*net.IPNet
+	for cur := first; cur.Cmp(last) <= 0; cur = big.NewInt(0).Add(cur, step) {
+		ip := make(net.IP, len(start))
+		cur.FillBytes(ip)
+		prefixes = append(prefixes, &net.IPNet{IP: ip, Mask: mask})
+	}
+	return prefixes, nil
+}
+
+// commonPrefixLen returns the length of the shortest network that contains both ips.
+func commonPrefixLen(start, end net.IP, bits int) int {
+	diff := big.NewInt(0).Xor(big.NewInt(0).SetBytes(start), big.NewInt(0).SetBytes(end))
+	return bits - diff.BitLen()
+}
@@ -0,0 +1,60 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+
+package iprange
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrefixes(t *testing.T) {
+	tests := map[string]struct {
+		input        string
+		length       int
+		wantPrefixes []string
+		wantLen      int
+		wantErr      bool
+	}{
+		"v4 CIDR":              {input: "192.0.2.0/24", length: 24, wantPrefixes: []string{"192.0.2.0/24"}},
+		"v4 CIDR split":        {input: "192.0.2.0/24", length: 26, wantPrefixes: []string{"192.0.2.0/26", "192.0.2.64/26", "192.0.2.128/26", "192.0.2.192/26"}},
+		"v4 CIDR supernet":     {input: "192.0.2.0/24", length: 16, wantErr: true},
+		"v4 Range supernet":    {input: "192.0.2.100-192.0.3.10", length: 22, wantErr: true},
+		"v4 Range":             {input: "192.0.2.0-192.0.3.255", length: 24, wantPrefixes: []string{"192.0.2.0/24", "192.0.3.0/24"}},
+		"v4 Range misaligned":  {input: "192.168.0.10-192.168.0.20", length: 27, wantErr: true},
+		"v4 Range host routes": {input: "192.168.0.10-192.168.0.12", length: 32, wantPrefixes: []string{"192.168.0.10/32", "192.168.0.11/32", "192.168.0.12/32"}},
+		"v4 IP":                {input: "192.0.2.1", length: 32, wantPrefixes: []string{"192.0.2.1/32"}},
+		"v6 CIDR":              {input: "2001:db8::/64", length: 64, wantPrefixes: []string{"2001:db8::/64"}},
+		"v6 Range":             {input: "2001:db8::-2001:db8::1:ffff", length: 112, wantPrefixes: []string{"2001:db8::/112", "2001:db8::1:0/112"}},
+		"v6 Range misaligned":  {input: "2001:db8::ffff-2001:db8::1:1", length: 112, wantErr: true},
+		"v4 length too long":   {input: "192.0.2.0/24", length: 33, wantErr: true},
+		"v4 length zero":       {input: "192.0.2.0/24", length: 0, wantErr: true},
+		"v6 too many":          {input: "2001:db8::/64", length: 96, wantErr: true},
+		"v4 max prefixes":      {input: "10.0.0.0/14", length: 24, wantLen: MaxPrefixes},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			r, err := ParseRange(test.input)
+			require.NoError(t, err)
+
+			prefixes, err := Prefixes(r, test.length)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			if test.wantLen != 0 {
+				assert.Len(t, prefixes, test.wantLen)
+				return
+			}
+			var got []string
+			for _, p := range prefixes {
+				got = append(got, p.String())
+			}
+			assert.Equal(t, test.wantPrefixes, got)
+		})
+	}
+}
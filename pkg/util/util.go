@@ -4,6 +4,7 @@ import (
 	"context"
 	"net"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/openelb/openelb/pkg/constant"
@@ -134,6 +135,31 @@ func NodeReady(obj runtime.Object) bool {
 	return true
 }
 
+// NodeExcluded reports whether the node is excluded from the announcements
+// by label or annotation, or drained.
+func NodeExcluded(node *corev1.Node) bool {
+	if _, ok := node.Labels[constant.KubernetesExcludeLBLabel]; ok {
+		return true
+	}
+
+	return node.Labels[constant.OpenELBNodeExclude] == "true" ||
+		node.Annotations[constant.OpenELBNodeExclude] == "true" ||
+		NodeDrained(node)
+}
+
+// NodeDrained reports whether the node is annotated to move the traffic away
+// while it stays up.
+func NodeDrained(node *corev1.Node) bool {
+	drain, _ := strconv.ParseBool(node.Annotations[constant.OpenELBNodeDrain])
+	return drain
+}
+
+// NodeAnnounceable reports whether the node is schedulable and ready to
+// announce the eips.
+func NodeAnnounceable(node *corev1.Node) bool {
+	return !node.Spec.Unschedulable && NodeReady(node)
+}
+
 func DiffMaps(old, new map[string]string) (add, del map[string]string) {
 	add = make(map[string]string)
 	del = make(map[string]string)
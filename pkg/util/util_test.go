@@ -4,6 +4,7 @@ import (
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/openelb/openelb/pkg/constant"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -58,4 +59,30 @@ var _ = Describe("Util", func() {
 			},
 		})).To(BeFalse())
 	})
+
+	It("Should exclude nodes from the announcements", func() {
+		node := &corev1.Node{
+			Status: corev1.NodeStatus{
+				Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		Expect(NodeExcluded(node)).To(BeFalse())
+		Expect(NodeAnnounceable(node)).To(BeTrue())
+
+		node.Spec.Unschedulable = true
+		Expect(NodeAnnounceable(node)).To(BeFalse())
+		node.Spec.Unschedulable = false
+		node.Status.Conditions[0].Status = corev1.ConditionUnknown
+		Expect(NodeAnnounceable(node)).To(BeFalse())
+
+		node.Annotations = map[string]string{constant.OpenELBNodeExclude: "true"}
+		Expect(NodeExcluded(node)).To(BeTrue())
+		node.Annotations = map[string]string{constant.OpenELBNodeDrain: "false"}
+		Expect(NodeExcluded(node)).To(BeFalse())
+		node.Annotations = map[string]string{constant.OpenELBNodeDrain: "true"}
+		Expect(NodeExcluded(node)).To(BeTrue())
+		node.Annotations = nil
+		node.Labels = map[string]string{constant.KubernetesExcludeLBLabel: ""}
+		Expect(NodeExcluded(node)).To(BeTrue())
+	})
 })